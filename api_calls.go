@@ -124,10 +124,28 @@ func ApiCall(method, url string, z Bundle, payload jsonT, params strMapT, verbos
 			utl.PrintJsonColor(payload)
 		}
 	}
-	r, err := client.Do(req) // Make the call
+	defer acquireApiSlot(z)() // Held until the response is read, throttling retries included
+	r, err := client.Do(req)  // Make the call
 	if err != nil {
 		panic(err.Error())
 	}
+	// Respect Azure throttling, which becomes more likely with parallel calls. Wait as long as
+	// the API asks for, or back off exponentially if it doesn't say, then try again.
+	for attempt := 1; attempt <= ConstMaxThrottleRetries && (r.StatusCode == 429 || r.StatusCode == 503); attempt++ {
+		wait := RetryAfter(r.Header.Get("Retry-After"), attempt)
+		r.Body.Close()
+		if verbose {
+			fmt.Printf("%s: %d, retrying in %s\n", utl.Yel("throttled"), r.StatusCode, wait)
+		}
+		time.Sleep(wait)
+		if req.GetBody != nil {
+			req.Body, _ = req.GetBody() // Rewind the payload
+		}
+		r, err = client.Do(req)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
 	defer r.Body.Close()
 	body, err := io.ReadAll(r.Body) // Read the response body
 	if err != nil {
@@ -168,6 +186,30 @@ func ApiCall(method, url string, z Bundle, payload jsonT, params strMapT, verbos
 	return jsonResult, r.StatusCode, err
 }
 
+// Returns how long to wait before retrying a throttled call. Uses the Retry-After header value
+// in seconds if there is one, otherwise backs off exponentially. Never waits over a minute.
+func RetryAfter(header string, attempt int) time.Duration {
+	wait := time.Duration(1<<attempt) * time.Second
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait > time.Minute {
+		wait = time.Minute
+	}
+	return wait
+}
+
+// Returns a copy of given headers with key set to value. Use this instead of setting headers
+// directly on the Bundle's shared maps, which may be read by other goroutines at the same time.
+func HeadersWith(headers map[string]string, key, value string) map[string]string {
+	clone := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		clone[k] = v
+	}
+	clone[key] = value
+	return clone
+}
+
 // Prints useful error information if they occur
 func ApiErrorCheck(method, url, caller string, r jsonT) {
 	if r["error"] != nil {
@@ -177,6 +219,16 @@ func ApiErrorCheck(method, url, caller string, r jsonT) {
 	}
 }
 
+// Prints the message of an API error response, and returns it as an error
+func printApiError(r jsonT, statusCode int) error {
+	msg := fmt.Sprintf("HTTP status %d", statusCode)
+	if e, ok := r["error"].(map[string]interface{}); ok && utl.Str(e["message"]) != "" {
+		msg = utl.Str(e["message"])
+	}
+	fmt.Println(msg)
+	return fmt.Errorf("%s", msg)
+}
+
 // Prints API error messages in 2 parts separated by a newline: A header, then a JSON byte slice
 func PrintApiErrMsg(msg string) {
 	parts := strings.Split(msg, "\n")
@@ -0,0 +1,377 @@
+package maz
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/queone/utl"
+)
+
+// Least-privilege analysis of custom role definitions. Every role's actions are expanded
+// against the provider operations catalog into the set of operations it actually grants, which
+// is then scored for breadth and compared against the built-in roles.
+//
+// The breadth score runs from 0, for a role that grants nothing, to 100, for a role that grants
+// every operation in the catalog, on a log scale so that small roles are still told apart.
+// Operations other than reads count four times as much as reads.
+
+// Kinds of least-privilege findings
+const (
+	FindingWildcard  = "wildcard"  // A wildcard pattern
+	FindingUnknown   = "unknown"   // A pattern that matches no operation
+	FindingRedundant = "redundant" // A pattern whose operations another pattern already grants
+	FindingCancelled = "cancelled" // A pattern whose operations notActions take away entirely
+	FindingNoop      = "noop"      // A notActions pattern that takes nothing away
+	FindingOverlap   = "overlap"   // A built-in role that grants much the same operations
+	FindingSuggest   = "suggest"   // The smallest built-in role that covers the role
+)
+
+// One least-privilege finding about a role
+type RoleFinding struct {
+	Kind    string
+	Field   string // actions, notActions, dataActions or notDataActions, if about a pattern
+	Pattern string
+	Message string
+}
+
+// Least-privilege analysis of one role definition
+type RoleAnalysis struct {
+	RoleId   string
+	RoleName string
+	Score    int // Breadth score, 0 to 100
+	Reads    int // Granted operations that are reads
+	Writes   int // Granted operations that are not reads, i.e. writes, deletes and other actions
+	Findings []RoleFinding
+}
+
+// Expands roles into the sets of operations they grant, remembering pattern expansions, since
+// many roles share the same patterns
+type roleAnalyzer struct {
+	catalog     *OperationCatalog
+	expansions  map[string]map[string]bool
+	totalWeight float64
+	builtins    []analyzedRole
+}
+
+type analyzedRole struct {
+	name    string
+	granted map[string]bool
+}
+
+// Returns an analyzer for given catalog, with the built-in roles among roleDefs expanded
+func newRoleAnalyzer(catalog *OperationCatalog, roleDefs []interface{}) *roleAnalyzer {
+	a := &roleAnalyzer{catalog: catalog, expansions: map[string]map[string]bool{}}
+	for _, op := range catalog.ops {
+		a.totalWeight += opWeight(op.lower)
+	}
+	for _, i := range roleDefs {
+		x, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		xProp, ok := x["properties"].(map[string]interface{})
+		if !ok || utl.Str(xProp["type"]) == "CustomRole" {
+			continue
+		}
+		granted := a.granted(x)
+		if len(granted) > 0 {
+			a.builtins = append(a.builtins, analyzedRole{name: utl.Str(xProp["roleName"]), granted: granted})
+		}
+	}
+	return a
+}
+
+// Returns the weight of an operation in the breadth score
+func opWeight(op string) float64 {
+	if strings.HasSuffix(op, "/read") {
+		return 1
+	}
+	return 4
+}
+
+// Returns the set of operations that pattern matches in given permissions field. Set members
+// are lowercased operation names, prefixed with "d:" for data actions so they can't clash
+// with control plane actions.
+func (a *roleAnalyzer) expand(field, pattern string) map[string]bool {
+	data := dataActionFields[field]
+	key := fmt.Sprintf("%t:%s", data, strings.ToLower(pattern))
+	if set, ok := a.expansions[key]; ok {
+		return set
+	}
+	set := map[string]bool{}
+	for _, op := range a.catalog.Expand(pattern, data) {
+		set[opKey(op, data)] = true
+	}
+	a.expansions[key] = set
+	return set
+}
+
+func opKey(op string, data bool) string {
+	if data {
+		return "d:" + strings.ToLower(op)
+	}
+	return strings.ToLower(op)
+}
+
+// Returns the permission patterns of role x, as a list of field:patterns maps, one per
+// permissions block
+func rolePermissions(x map[string]interface{}) (blocks []map[string][]string) {
+	xProp, _ := x["properties"].(map[string]interface{})
+	perms, _ := xProp["permissions"].([]interface{})
+	for _, i := range perms {
+		perm, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		block := map[string][]string{}
+		for _, field := range []string{"actions", "notActions", "dataActions", "notDataActions"} {
+			patterns, _ := perm[field].([]interface{})
+			for _, p := range patterns {
+				block[field] = append(block[field], utl.Str(p))
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// Returns the set of operations that role x grants, after notActions and notDataActions
+func (a *roleAnalyzer) granted(x map[string]interface{}) map[string]bool {
+	granted := map[string]bool{}
+	for _, block := range rolePermissions(x) {
+		for _, fields := range [][2]string{{"actions", "notActions"}, {"dataActions", "notDataActions"}} {
+			excluded := a.union(fields[1], block[fields[1]])
+			for op := range a.union(fields[0], block[fields[0]]) {
+				if !excluded[op] {
+					granted[op] = true
+				}
+			}
+		}
+	}
+	return granted
+}
+
+// Returns the union of the operations given patterns match in given field
+func (a *roleAnalyzer) union(field string, patterns []string) map[string]bool {
+	set := map[string]bool{}
+	for _, p := range patterns {
+		for op := range a.expand(field, p) {
+			set[op] = true
+		}
+	}
+	return set
+}
+
+// Returns true if every member of a is in b
+func isSubset(a, b map[string]bool) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of members a and b have in common
+func intersectionSize(a, b map[string]bool) (n int) {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	for k := range a {
+		if b[k] {
+			n++
+		}
+	}
+	return n
+}
+
+// Describes how broad a wildcard pattern is
+func wildcardKind(pattern string) string {
+	switch {
+	case pattern == "*":
+		return "a wildcard for every operation"
+	case strings.HasPrefix(pattern, "*"):
+		return "a cross-provider wildcard"
+	case strings.Count(pattern, "/") == 1 && strings.HasSuffix(pattern, "/*"):
+		return "a provider-wide wildcard"
+	}
+	return "a wildcard"
+}
+
+// Analyzes role definition x
+func (a *roleAnalyzer) analyze(x map[string]interface{}) (r RoleAnalysis) {
+	xProp, _ := x["properties"].(map[string]interface{})
+	r.RoleId = utl.Str(x["name"])
+	r.RoleName = utl.Str(xProp["roleName"])
+	add := func(kind, field, pattern, format string, args ...interface{}) {
+		r.Findings = append(r.Findings, RoleFinding{Kind: kind, Field: field, Pattern: pattern, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, block := range rolePermissions(x) {
+		for _, fields := range [][2]string{{"actions", "notActions"}, {"dataActions", "notDataActions"}} {
+			field, notField := fields[0], fields[1]
+			patterns := block[field]
+			excluded := a.union(notField, block[notField])
+			for n, p := range patterns {
+				ops := a.expand(field, p)
+				if len(ops) == 0 {
+					add(FindingUnknown, field, p, "'%s' does not match any operation", p)
+					continue
+				}
+				if strings.Contains(p, "*") {
+					add(FindingWildcard, field, p, "'%s' is %s, granting %d operations", p, wildcardKind(p), len(ops))
+				}
+				if isSubset(ops, excluded) {
+					add(FindingCancelled, field, p, "'%s' is cancelled, since %s take away all of its operations", p, notField)
+					continue
+				}
+				// Redundant if another pattern grants all the same operations. When two patterns grant
+				// exactly the same operations, only the later one is flagged.
+				for m, q := range patterns {
+					other := a.expand(field, q)
+					if m != n && isSubset(ops, other) && (len(other) > len(ops) || m < n) {
+						add(FindingRedundant, field, p, "'%s' is redundant, '%s' already grants its operations", p, q)
+						break
+					}
+				}
+			}
+			granted := a.union(field, patterns)
+			for _, p := range block[notField] {
+				if intersectionSize(a.expand(notField, p), granted) == 0 {
+					add(FindingNoop, notField, p, "'%s' takes away nothing granted by %s", p, field)
+				}
+			}
+		}
+	}
+
+	granted := a.granted(x)
+	weight := 0.0
+	for op := range granted {
+		weight += opWeight(op)
+		if strings.HasSuffix(op, "/read") {
+			r.Reads++
+		} else {
+			r.Writes++
+		}
+	}
+	if a.totalWeight > 0 {
+		r.Score = int(math.Round(100 * math.Log1p(weight) / math.Log1p(a.totalWeight)))
+	}
+	if len(granted) == 0 {
+		return r
+	}
+
+	// Compare against the built-in roles
+	var smallest, closest *analyzedRole
+	bestOverlap := 0.0
+	for n := range a.builtins {
+		b := &a.builtins[n]
+		if isSubset(granted, b.granted) {
+			if smallest == nil || len(b.granted) < len(smallest.granted) ||
+				(len(b.granted) == len(smallest.granted) && b.name < smallest.name) {
+				smallest = b
+			}
+			continue
+		}
+		common := intersectionSize(granted, b.granted)
+		overlap := float64(common) / float64(len(granted)+len(b.granted)-common) // Jaccard index
+		if overlap > bestOverlap {
+			bestOverlap, closest = overlap, b
+		}
+	}
+	if smallest != nil && len(smallest.granted) == len(granted) {
+		add(FindingOverlap, "", "", "grants the same %d operations as built-in role '%s'", len(granted), smallest.name)
+		add(FindingSuggest, "", "", "use built-in role '%s' instead", smallest.name)
+		return r
+	}
+	if closest != nil && bestOverlap >= 0.5 {
+		add(FindingOverlap, "", "", "%.0f%% of its operations overlap with built-in role '%s'", 100*bestOverlap, closest.name)
+	}
+	if smallest != nil {
+		add(FindingSuggest, "", "", "smallest covering built-in role is '%s', which grants %d more operations",
+			smallest.name, len(smallest.granted)-len(granted))
+	}
+	return r
+}
+
+// Returns the analyzer for the current tenant, from the cached or fetched catalog and role
+// definitions
+func getRoleAnalyzer(z Bundle) *roleAnalyzer {
+	catalog := GetOperationCatalog(false, z)
+	if catalog.Len() == 0 {
+		utl.Die("Provider operations catalog is not available\n")
+	}
+	return newRoleAnalyzer(catalog, GetMatchingRoleDefinitions("", false, z))
+}
+
+// Analyzes existing custom role definitions matching on 'filter', broadest first
+func AnalyzeCustomRoles(filter string, z Bundle) (list []RoleAnalysis) {
+	a := getRoleAnalyzer(z)
+	for _, i := range GetMatchingRoleDefinitions(filter, false, z) {
+		x := i.(map[string]interface{})
+		if xProp, ok := x["properties"].(map[string]interface{}); ok && utl.Str(xProp["type"]) == "CustomRole" {
+			list = append(list, a.analyze(x))
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Score > list[j].Score })
+	return list
+}
+
+// Analyzes the role definitions in given specfile, broadest first
+func AnalyzeSpecfileRoles(filePath string, z Bundle) (list []RoleAnalysis) {
+	objects, _ := GetObjectsFromSpecfile(filePath, z)
+	var a *roleAnalyzer
+	for _, o := range objects {
+		if o.MazType != "d" {
+			continue
+		}
+		if a == nil {
+			a = getRoleAnalyzer(z)
+		}
+		list = append(list, a.analyze(o.Object))
+	}
+	if a == nil {
+		utl.Die("File has no role definitions\n")
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Score > list[j].Score })
+	return list
+}
+
+// Prints role analyses
+func PrintRoleAnalyses(list []RoleAnalysis) {
+	for _, r := range list {
+		score := fmt.Sprintf("%3d", r.Score)
+		switch {
+		case r.Score >= 60:
+			score = utl.Red(score)
+		case r.Score >= 30:
+			score = utl.Yel(score)
+		default:
+			score = utl.Gre(score)
+		}
+		id := ""
+		if r.RoleId != "" {
+			id = r.RoleId + "  "
+		}
+		fmt.Printf("%s  %s%s  %d reads, %d writes\n", score, id, utl.Blu(r.RoleName), r.Reads, r.Writes)
+		for _, f := range r.Findings {
+			kind := utl.PostSpc(f.Kind, 10)
+			if f.Field != "" {
+				kind += utl.PostSpc(f.Field, 15)
+			}
+			switch f.Kind {
+			case FindingSuggest:
+				fmt.Printf("     %s%s\n", utl.Gre(kind), f.Message)
+			case FindingOverlap:
+				fmt.Printf("     %s%s\n", utl.Blu(kind), f.Message)
+			default:
+				fmt.Printf("     %s%s\n", utl.Yel(kind), f.Message)
+			}
+		}
+	}
+}
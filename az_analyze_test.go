@@ -0,0 +1,162 @@
+package maz
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns a role definition object with one permissions block
+func testRole(name, roleType string, actions, notActions, dataActions []string) map[string]interface{} {
+	list := func(patterns []string) (l []interface{}) {
+		for _, p := range patterns {
+			l = append(l, p)
+		}
+		return l
+	}
+	return map[string]interface{}{
+		"name": strings.ToLower(strings.ReplaceAll(name, " ", "-")),
+		"properties": map[string]interface{}{
+			"roleName": name,
+			"type":     roleType,
+			"permissions": []interface{}{
+				map[string]interface{}{
+					"actions":     list(actions),
+					"notActions":  list(notActions),
+					"dataActions": list(dataActions),
+				},
+			},
+		},
+	}
+}
+
+func testRoleAnalyzer() *roleAnalyzer {
+	return newRoleAnalyzer(testCatalog(), []interface{}{
+		testRole("Contributor", "BuiltInRole", []string{"*"}, nil, nil),
+		testRole("Compute Contributor", "BuiltInRole", []string{"Microsoft.Compute/*"}, nil, nil),
+		testRole("Virtual Machine Reader", "BuiltInRole", []string{"Microsoft.Compute/virtualMachines/read"}, nil, nil),
+		testRole("Storage Blob Data Reader", "BuiltInRole", nil, nil,
+			[]string{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read"}),
+		testRole("Nothing", "BuiltInRole", []string{"Microsoft.Nope/*"}, nil, nil),                // Grants nothing, so ignored
+		testRole("Custom Compute", "CustomRole", []string{"Microsoft.Compute/disks/*"}, nil, nil), // Not a built-in
+	})
+}
+
+func TestNewRoleAnalyzer(t *testing.T) {
+	a := testRoleAnalyzer()
+	var names []string
+	for _, b := range a.builtins {
+		names = append(names, b.name)
+	}
+	want := []string{"Contributor", "Compute Contributor", "Virtual Machine Reader", "Storage Blob Data Reader"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("builtins = %v, want %v", names, want)
+	}
+}
+
+func TestRoleAnalyzerAnalyze(t *testing.T) {
+	blobRead := "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read"
+	tests := []struct {
+		name        string
+		role        map[string]interface{}
+		reads       int
+		writes      int
+		findings    []string // Kind, field and pattern of each finding
+		suggestions []string // Substrings of the overlap and suggest messages, in order
+	}{
+		{"same as built-in",
+			testRole("VM Reader", "CustomRole", []string{"Microsoft.Compute/virtualMachines/read"}, nil, nil),
+			1, 0,
+			[]string{"overlap  ", "suggest  "},
+			[]string{"same 1 operations as built-in role 'Virtual Machine Reader'", "use built-in role 'Virtual Machine Reader'"}},
+		{"wildcard and redundant",
+			testRole("Compute", "CustomRole", []string{"Microsoft.Compute/*", "Microsoft.Compute/disks/read"}, nil, nil),
+			2, 4,
+			[]string{
+				"wildcard actions Microsoft.Compute/*",
+				"redundant actions Microsoft.Compute/disks/read",
+				"overlap  ",
+				"suggest  ",
+			},
+			[]string{"built-in role 'Compute Contributor'", "use built-in role 'Compute Contributor'"}},
+		{"unknown",
+			testRole("Typo", "CustomRole", []string{"Microsoft.Nope/x/read"}, nil, nil),
+			0, 0,
+			[]string{"unknown actions Microsoft.Nope/x/read"},
+			nil},
+		{"cancelled and noop",
+			testRole("Cancelled", "CustomRole", []string{"Microsoft.Compute/disks/*"},
+				[]string{"Microsoft.Compute/disks/*", "Microsoft.Storage/storageAccounts/read"}, nil),
+			0, 0,
+			[]string{
+				"wildcard actions Microsoft.Compute/disks/*",
+				"cancelled actions Microsoft.Compute/disks/*",
+				"noop notActions Microsoft.Storage/storageAccounts/read",
+			},
+			nil},
+		{"overlap and covering role",
+			testRole("VM Operator", "CustomRole",
+				[]string{"Microsoft.Compute/virtualMachines/*", "Microsoft.Storage/storageAccounts/read"}, nil, nil),
+			2, 3,
+			[]string{"wildcard actions Microsoft.Compute/virtualMachines/*", "overlap  ", "suggest  "},
+			[]string{"57% of its operations overlap with built-in role 'Compute Contributor'",
+				"smallest covering built-in role is 'Contributor', which grants 4 more operations"}},
+		{"duplicate data actions",
+			testRole("Blob Reader", "CustomRole", nil, nil, []string{blobRead, strings.ToUpper(blobRead)}),
+			1, 0,
+			[]string{"redundant dataActions " + strings.ToUpper(blobRead), "overlap  ", "suggest  "},
+			[]string{"built-in role 'Storage Blob Data Reader'", "use built-in role 'Storage Blob Data Reader'"}},
+		{"cross-provider wildcard",
+			testRole("Reads", "CustomRole", []string{"*/read"}, nil, nil),
+			3, 0,
+			[]string{"wildcard actions */read", "suggest  "},
+			[]string{"smallest covering built-in role is 'Contributor', which grants 6 more operations"}},
+	}
+	a := testRoleAnalyzer()
+	for _, tt := range tests {
+		r := a.analyze(tt.role)
+		if r.Reads != tt.reads || r.Writes != tt.writes {
+			t.Errorf("%s: reads, writes = %d, %d, want %d, %d", tt.name, r.Reads, r.Writes, tt.reads, tt.writes)
+		}
+		var findings, suggestions []string
+		for _, f := range r.Findings {
+			findings = append(findings, f.Kind+" "+f.Field+" "+f.Pattern)
+			if f.Kind == FindingOverlap || f.Kind == FindingSuggest {
+				suggestions = append(suggestions, f.Message)
+			}
+		}
+		if !reflect.DeepEqual(findings, tt.findings) {
+			t.Errorf("%s: findings = %q, want %q", tt.name, findings, tt.findings)
+			continue
+		}
+		for n, s := range tt.suggestions {
+			if !strings.Contains(suggestions[n], s) {
+				t.Errorf("%s: finding message %q does not contain %q", tt.name, suggestions[n], s)
+			}
+		}
+	}
+}
+
+func TestRoleAnalyzerScore(t *testing.T) {
+	a := testRoleAnalyzer()
+	tests := []struct {
+		name     string
+		role     map[string]interface{}
+		min, max int
+	}{
+		{"nothing", testRole("None", "CustomRole", []string{"Microsoft.Nope/*"}, nil, nil), 0, 0},
+		{"one read", testRole("Read", "CustomRole", []string{"Microsoft.Compute/disks/read"}, nil, nil), 1, 30},
+		{"one write", testRole("Write", "CustomRole", []string{"Microsoft.Compute/disks/write"}, nil, nil), 31, 60},
+		{"everything", testRole("All", "CustomRole", []string{"*"}, nil, []string{"*"}), 100, 100},
+	}
+	for _, tt := range tests {
+		if r := a.analyze(tt.role); r.Score < tt.min || r.Score > tt.max {
+			t.Errorf("%s: score = %d, want %d to %d", tt.name, r.Score, tt.min, tt.max)
+		}
+	}
+	read := a.analyze(testRole("Read", "CustomRole", []string{"Microsoft.Compute/disks/read"}, nil, nil)).Score
+	write := a.analyze(testRole("Write", "CustomRole", []string{"Microsoft.Compute/disks/write"}, nil, nil)).Score
+	if read >= write {
+		t.Errorf("read score %d is not below write score %d", read, write)
+	}
+}
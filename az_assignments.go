@@ -2,8 +2,8 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/queone/utl"
@@ -67,6 +67,13 @@ func PrintRoleAssignment(x map[string]interface{}, z Bundle) {
 	} else {
 		fmt.Printf("  %s: %s\n", cScope, utl.Gre(scope))
 	}
+
+	// Optional attributes, including any ABAC condition
+	for _, k := range roleAssignmentOptionalKeys {
+		if v := utl.Str(xProp[k]); v != "" {
+			fmt.Printf("  %s: %s\n", utl.Blu(k), utl.Gre(v))
+		}
+	}
 }
 
 // Prints a human-readable report of all RBAC role assignments
@@ -103,14 +110,18 @@ func PrintRoleAssignmentReport(z Bundle) {
 		}
 		Scope = strings.TrimSpace(Scope)
 
-		fmt.Printf("\"%s\",\"%s\",\"%s\",\"%s\"\n", roleNameMap[Rid], pName, Type, Scope)
+		// Double up any quotes in the condition, to keep this valid CSV
+		condition := strings.ReplaceAll(utl.Str(xProp["condition"]), "\"", "\"\"")
+		condition = strings.Join(strings.Fields(condition), " ") // Conditions are often multi-line
+
+		fmt.Printf("\"%s\",\"%s\",\"%s\",\"%s\",\"%s\"\n", roleNameMap[Rid], pName, Type, Scope, condition)
 	}
 }
 
 // Creates an RBAC role assignment as defined by give x object
-func CreateAzRoleAssignment(x map[string]interface{}, z Bundle) {
+func CreateAzRoleAssignment(x map[string]interface{}, z Bundle) error {
 	if x == nil {
-		return
+		return nil
 	}
 	xProp := x["properties"].(map[string]interface{})
 	roleDefinitionId := utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/") // Note we only care about the UUID
@@ -128,24 +139,39 @@ func CreateAzRoleAssignment(x map[string]interface{}, z Bundle) {
 			"See script '-k*' options to create properly formatted sample files.\n")
 	}
 
+	validateRoleAssignmentCondition(xProp) // Dies if the ABAC condition is invalid
+
+	r, statusCode := putAzRoleAssignment("", roleDefinitionId, principalId, scope, xProp, z)
+	if statusCode == 200 || statusCode == 201 {
+		utl.PrintYaml(r)
+		return nil
+	}
+	return printApiError(r, statusCode)
+}
+
+// Creates an RBAC role assignment, with any optional attributes in xProp, without printing
+// anything. An existing assignment is updated instead if its UUID is given as name. Returns
+// the API response and its HTTP status code.
+func putAzRoleAssignment(name, roleDefinitionId, principalId, scope string, xProp map[string]interface{}, z Bundle) (map[string]interface{}, int) {
 	// Note, there is no need to pre-check if assignment exists, since call will simply let us know
-	newUuid := uuid.New().String() // Generate a new global UUID in string format
-	payload := map[string]interface{}{
-		"properties": map[string]string{
-			"roleDefinitionId": "/providers/Microsoft.Authorization/roleDefinitions/" + roleDefinitionId,
-			"principalId":      principalId,
-		},
+	if name == "" {
+		name = uuid.New().String() // Generate a new global UUID in string format
 	}
+	properties := map[string]string{
+		"roleDefinitionId": "/providers/Microsoft.Authorization/roleDefinitions/" + roleDefinitionId,
+		"principalId":      principalId,
+	}
+	for _, k := range roleAssignmentOptionalKeys {
+		if v := utl.Str(xProp[k]); v != "" {
+			properties[k] = v
+		}
+	}
+	payload := map[string]interface{}{"properties": properties}
 	params := map[string]string{"api-version": "2022-04-01"} // roleAssignments
-	url := ConstAzUrl + scope + "/providers/Microsoft.Authorization/roleAssignments/" + newUuid
+	url := ConstAzUrl + scope + "/providers/Microsoft.Authorization/roleAssignments/" + name
 	r, statusCode, _ := ApiPut(url, z, payload, params)
 	//ApiErrorCheck("PUT", url, utl.Trace(), r)
-	if statusCode == 200 || statusCode == 201 {
-		utl.PrintYaml(r)
-	} else {
-		e := r["error"].(map[string]interface{})
-		fmt.Println(e["message"].(string))
-	}
+	return r, statusCode
 }
 
 // Deletes an RBAC role assignment by its fully qualified object Id
@@ -153,7 +179,7 @@ func CreateAzRoleAssignment(x map[string]interface{}, z Bundle) {
 //
 //	/providers/Microsoft.Management/managementGroups/33550b0b-2929-4b4b-adad-cccc66664444 \
 //	  /providers/Microsoft.Authorization/roleAssignments/5d586a7b-3f4b-4b5c-844a-3fa8efe49ab3
-func DeleteAzRoleAssignmentByFqid(fqid string, z Bundle) map[string]interface{} {
+func DeleteAzRoleAssignmentByFqid(fqid string, z Bundle) error {
 	params := map[string]string{"api-version": "2022-04-01"} // roleAssignments
 	url := ConstAzUrl + fqid
 	r, statusCode, _ := ApiDelete(url, z, params)
@@ -162,8 +188,7 @@ func DeleteAzRoleAssignmentByFqid(fqid string, z Bundle) map[string]interface{}
 		if statusCode == 204 {
 			fmt.Println("Role assignment already deleted or does not exist. Give Azure a minute to flush it out.")
 		} else {
-			e := r["error"].(map[string]interface{})
-			fmt.Println(e["message"].(string))
+			return printApiError(r, statusCode)
 		}
 	}
 	return nil
@@ -171,16 +196,8 @@ func DeleteAzRoleAssignmentByFqid(fqid string, z Bundle) map[string]interface{}
 
 // Retrieves count of all role assignment objects in local cache file
 func RoleAssignmentsCountLocal(z Bundle) int64 {
-	var cachedList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_roleAssignments."+ConstCacheFileExtension)
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-			return int64(len(cachedList))
-		}
-	}
-	return 0
+	cachedList := GetCachedObjects(CacheFile("a", z))
+	return int64(len(cachedList))
 }
 
 // Calculates count of all role assignment objects in Azure
@@ -191,10 +208,11 @@ func RoleAssignmentsCountAzure(z Bundle) int64 {
 
 // Gets all RBAC role assignments matching on 'filter'. Return entire list if filter is empty ""
 func GetMatchingRoleAssignments(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_roleAssignments."+ConstCacheFileExtension)
+	MigrateCache("a", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("a", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstAzCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzRoleAssignments(z, true)
@@ -228,9 +246,10 @@ func GetMatchingRoleAssignments(filter string, force bool, z Bundle) (list []int
 //	https://learn.microsoft.com/en-us/azure/role-based-access-control/role-assignments-list-rest
 //	https://learn.microsoft.com/en-us/rest/api/authorization/role-assignments/list-for-subscription
 func GetAzRoleAssignments(z Bundle, verbose bool) (list []interface{}) {
-	list = nil             // We have to zero it out
-	var uniqueIds []string // Keep track of assignment objects
-	k := 1                 // Track number of API calls to provide progress
+	list = nil                // We have to zero it out
+	uniqueIds := NewSyncSet() // Keep track of assignment objects, across all parallel workers
+	var mu sync.Mutex         // Guards list, k, and the progress output
+	k := 1                    // Track number of API calls to provide progress
 
 	var mgGroupNameMap, subNameMap map[string]string
 	if verbose {
@@ -238,38 +257,39 @@ func GetAzRoleAssignments(z Bundle, verbose bool) (list []interface{}) {
 		subNameMap = GetIdMapSubs(z)
 	}
 
-	scopes := GetAzRbacScopes(z)                             // Get all scopes
-	params := map[string]string{"api-version": "2022-04-01"} // roleAssignments
-	for _, scope := range scopes {
+	scopes := append(GetAzRbacScopes(z), GetAzDeepRbacScopes(z)...) // Get all scopes, down to ScopeDepth
+	params := map[string]string{"api-version": "2022-04-01"}        // roleAssignments
+	RunParallel(len(scopes), ConcurrencyLimit(z), func(n int) bool {
+		scope := scopes[n]
 		url := ConstAzUrl + scope + "/providers/Microsoft.Authorization/roleAssignments"
 		r, _, _ := ApiGet(url, z, params)
+		var objects []interface{} = nil
 		if r != nil && r["value"] != nil {
 			objectsUnderThisScope := r["value"].([]interface{})
-			count := 0
 			for _, i := range objectsUnderThisScope {
 				x := i.(map[string]interface{})
-				uuid := utl.Str(x["name"])
-				if utl.ItemInList(uuid, uniqueIds) {
+				if !uniqueIds.Add(utl.Str(x["name"])) {
 					continue // Skip this repeated one. This can happen due to inherited nesting
 				}
-				uniqueIds = append(uniqueIds, uuid) // Keep track of the UUIDs we are seeing
-				list = append(list, x)
-				count++
+				objects = append(objects, x)
 			}
-			if verbose && count > 0 {
-				scopeName := scope
-				if strings.HasPrefix(scope, "/providers") {
-					scopeName = mgGroupNameMap[scope]
-				} else if strings.HasPrefix(scope, "/subscriptions") {
-					scopeName = subNameMap[utl.LastElem(scope, "/")]
-				}
-				fmt.Printf("API call %4d: %5d objects under %s\n", k, count, scopeName)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		list = append(list, objects...)
+		if verbose && len(objects) > 0 {
+			scopeName := scope
+			if strings.HasPrefix(scope, "/providers") {
+				scopeName = mgGroupNameMap[scope]
+			} else if strings.HasPrefix(scope, "/subscriptions") {
+				scopeName = subNameMap[utl.LastElem(scope, "/")]
 			}
+			fmt.Printf("API call %4d: %5d objects under %s\n", k, len(objects), scopeName)
 		}
 		k++
-	}
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_roleAssignments."+ConstCacheFileExtension)
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+		return false
+	})
+	SaveCachedObjects("a", list, z) // Update the local cache
 	return list
 }
 
@@ -320,22 +340,38 @@ func GetAzRoleAssignmentByObject(x map[string]interface{}, z Bundle) (y map[stri
 }
 
 // Gets RBAC role assignment by its Object UUID. Unfortunately we have to iterate
-// through the entire tenant scope hierarchy, which can take time.
-func GetAzRoleAssignmentByUuid(uuid string, z Bundle) map[string]interface{} {
-	scopes := GetAzRbacScopes(z)
+// through the entire tenant scope hierarchy, which can take time, so scopes are
+// searched in parallel and the search stops as soon as one of them has a match.
+func GetAzRoleAssignmentByUuid(uuid string, z Bundle) (y map[string]interface{}) {
+	var mu sync.Mutex
+	scopes := append(GetAzRbacScopes(z), GetAzDeepRbacScopes(z)...)
 	params := map[string]string{"api-version": "2022-04-01"} // roleAssignments
-	for _, scope := range scopes {
-		url := ConstAzUrl + scope + "/providers/Microsoft.Authorization/roleAssignments"
+	RunParallel(len(scopes), ConcurrencyLimit(z), func(n int) bool {
+		url := ConstAzUrl + scopes[n] + "/providers/Microsoft.Authorization/roleAssignments"
 		r, _, _ := ApiGet(url, z, params)
 		if r != nil && r["value"] != nil {
 			assignmentsUnderThisScope := r["value"].([]interface{})
 			for _, i := range assignmentsUnderThisScope {
 				x := i.(map[string]interface{})
 				if utl.Str(x["name"]) == uuid {
-					return x // Return as soon as we find a match
+					mu.Lock()
+					y = x
+					mu.Unlock()
+					return true // Stop as soon as we find a match
 				}
 			}
 		}
-	}
-	return nil
+		return false
+	})
+	return y
+}
+
+// Returns typed role assignments matching on filter. See GetMatchingRoleAssignments.
+func ListRoleAssignments(filter string, force bool, z Bundle) ([]RoleAssignment, error) {
+	return ToModelList[RoleAssignment](GetMatchingRoleAssignments(filter, force, z))
+}
+
+// Returns typed role assignment with given UUID from Azure, or nil if not found
+func GetRoleAssignment(uuid string, z Bundle) (*RoleAssignment, error) {
+	return toModelPtr[RoleAssignment](GetAzRoleAssignmentByUuid(uuid, z))
 }
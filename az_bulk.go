@@ -0,0 +1,373 @@
+package maz
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/queone/utl"
+)
+
+// Bulk role assignments from CSV. Each row has a role, a principal and a scope, optionally
+// followed by a description and an ABAC condition:
+//
+//	role,principal,scope[,description[,condition]]
+//
+// Roles and principals can be names or UUIDs, and scopes can be in any form specfiles accept.
+// The 5-column output of PrintRoleAssignmentReport is also accepted as is, so an existing
+// report can be replayed into another tenant:
+//
+//	role,principal,principalType,scope,condition
+//
+// A first row of column names is optional; if present, it can name the columns in any order.
+
+// One row of a bulk role assignment CSV, and what became of it
+type BulkAssignmentRow struct {
+	Line          int                    // Line number in the CSV file
+	Role          string                 // As given in the CSV
+	Principal     string                 // As given in the CSV
+	PrincipalType string                 // Optional, as given in the CSV
+	Scope         string                 // As given in the CSV
+	Object        map[string]interface{} // Resolved role assignment specfile object
+	Status        string                 // "create", "exists" or "invalid" in the plan, then "created" or "failed"
+	Err           error
+}
+
+// Column names accepted in a CSV header row
+var bulkCsvColumns = map[string]string{
+	"role": "role", "rolename": "role", "roledefinitionid": "role",
+	"principal": "principal", "principalname": "principal", "principalid": "principal",
+	"type": "type", "principaltype": "type",
+	"scope": "scope", "description": "description", "condition": "condition",
+}
+
+// Returns the column index of every known field, from the header row if there is one, or
+// else from the row's shape. Returns true if the first row is a header.
+func bulkCsvLayout(first []string) (cols map[string]int, header bool) {
+	cols = map[string]int{}
+	for n, name := range first {
+		if field, ok := bulkCsvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			cols[field] = n
+		}
+	}
+	_, hasRole := cols["role"]
+	_, hasPrincipal := cols["principal"]
+	_, hasScope := cols["scope"]
+	if hasRole && hasPrincipal && hasScope {
+		return cols, true
+	}
+	cols = map[string]int{"role": 0, "principal": 1, "scope": 2, "description": 3, "condition": 4}
+	if len(first) >= 4 && utl.ItemInList(strings.TrimSpace(first[2]), []string{"User", "Group", "ServicePrincipal", "SomeObject"}) {
+		cols = map[string]int{"role": 0, "principal": 1, "type": 2, "scope": 3, "condition": 4} // Report layout
+	}
+	return cols, false
+}
+
+// Principal names by type, for resolving CSV principals that may come with a type column
+type bulkPrincipals struct {
+	byType map[string]map[string][]string // Type to lowercased name to ids
+	types  map[string]string              // Id to type
+}
+
+func getBulkPrincipals(z Bundle) (p bulkPrincipals) {
+	p.byType = map[string]map[string][]string{"User": {}, "Group": {}, "ServicePrincipal": {}}
+	p.types = map[string]string{}
+	for _, i := range GetMatchingUsers("", false, z) {
+		x := i.(map[string]interface{})
+		id := utl.Str(x["id"])
+		addName(p.byType["User"], utl.Str(x["userPrincipalName"]), id)
+		addName(p.byType["User"], utl.Str(x["displayName"]), id)
+		p.types[id] = "User"
+	}
+	for pType, m := range map[string]map[string]string{"Group": GetIdMapGroups(z), "ServicePrincipal": GetIdMapSps(z)} {
+		for id, name := range m {
+			addName(p.byType[pType], name, id)
+			p.types[id] = pType
+		}
+	}
+	return p
+}
+
+// Returns the UUID of the principal with given name or UUID, limited to pType if given
+func (p bulkPrincipals) resolve(principal, pType string) (string, error) {
+	if utl.ValidUuid(principal) {
+		id := strings.ToLower(principal)
+		if p.types[id] == "" {
+			return "", fmt.Errorf("principal %s is not in the local cache", principal)
+		}
+		if pType != "" && pType != "SomeObject" && p.types[id] != pType {
+			return "", fmt.Errorf("principal %s is a %s, not a %s", principal, p.types[id], pType)
+		}
+		return id, nil
+	}
+	if pType != "" && pType != "SomeObject" {
+		names, ok := p.byType[pType]
+		if !ok {
+			return "", fmt.Errorf("unknown principal type '%s'", pType)
+		}
+		return lookupName(names, strings.ToLower(pType), principal)
+	}
+	all := map[string][]string{}
+	for _, names := range p.byType {
+		for _, id := range names[strings.ToLower(principal)] {
+			addName(all, principal, id)
+		}
+	}
+	return lookupName(all, "principal", principal)
+}
+
+// Returns the fully qualified scope for a scope in PrintRoleAssignmentReport format, where the
+// subscription is given by name followed by a space and the rest of the path
+func reportScope(scope string, r *nameResolver, z Bundle) (string, error) {
+	if strings.HasPrefix(scope, "/") || strings.Contains(strings.SplitN(scope, "/", 2)[0], ":") || strings.EqualFold(scope, "tenant") {
+		return r.resolveScope(scope, z)
+	}
+	subName, rest := scope, ""
+	if n := strings.LastIndex(scope, " "); n > 0 && strings.Contains(scope[n+1:], "/") {
+		subName, rest = scope[:n], scope[n+1:]
+	}
+	sub, err := r.resolveScope("sub:"+subName, z)
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		sub += "/" + strings.TrimPrefix(rest, "/")
+	}
+	return sub, nil
+}
+
+// Reads a bulk role assignment CSV file and validates every row against the local cache. Rows
+// are marked "create", "exists" (already assigned) or "invalid", with the reason in Err.
+func LoadBulkAssignmentsCsv(filePath string, z Bundle) (rows []BulkAssignmentRow, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := bulkCsvCache{roleNames: GetIdMapRoleDefs(z), existing: map[string]bool{}}
+	for _, i := range GetMatchingRoleAssignments("", false, z) {
+		xProp := i.(map[string]interface{})["properties"].(map[string]interface{})
+		c.existing[assignmentKey(utl.Str(xProp["roleDefinitionId"]), utl.Str(xProp["principalId"]), utl.Str(xProp["scope"]))] = true
+	}
+	return c.readCsv(f, z)
+}
+
+// The cached objects that bulk CSV rows are validated against
+type bulkCsvCache struct {
+	roleNames  map[string]string // Role definition UUID to name
+	existing   map[string]bool   // Existing role assignments, by assignmentKey
+	principals *bulkPrincipals   // Only loaded once there is a row to resolve
+	resolver   nameResolver
+}
+
+// Reads bulk role assignment CSV rows from in, and validates each one
+func (c *bulkCsvCache) readCsv(in io.Reader, z Bundle) (rows []BulkAssignmentRow, err error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1 // Optional trailing columns
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var cols map[string]int
+	seen := map[string]int{}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if cols == nil {
+			var header bool
+			if cols, header = bulkCsvLayout(record); header {
+				continue
+			}
+		}
+		field := func(name string) string {
+			if n, ok := cols[name]; ok && n < len(record) {
+				return strings.TrimSpace(record[n])
+			}
+			return ""
+		}
+		row := BulkAssignmentRow{
+			Line:          line,
+			Role:          field("role"),
+			Principal:     field("principal"),
+			PrincipalType: field("type"),
+			Scope:         field("scope"),
+			Status:        "invalid",
+		}
+		if c.principals == nil {
+			principals := getBulkPrincipals(z)
+			c.principals = &principals
+		}
+		rows = append(rows, row)
+		r := &rows[len(rows)-1]
+
+		if r.Role == "" || r.Principal == "" || r.Scope == "" {
+			r.Err = fmt.Errorf("row needs a role, a principal and a scope")
+			continue
+		}
+		xProp := map[string]interface{}{}
+		if utl.ValidUuid(utl.LastElem(r.Role, "/")) {
+			xProp["roleDefinitionId"] = strings.ToLower(utl.LastElem(r.Role, "/"))
+			if c.roleNames[xProp["roleDefinitionId"].(string)] == "" {
+				r.Err = fmt.Errorf("role %s is not in the local cache", r.Role)
+				continue
+			}
+		} else {
+			xProp["roleName"] = r.Role
+		}
+		if xProp["principalId"], r.Err = c.principals.resolve(r.Principal, r.PrincipalType); r.Err != nil {
+			continue
+		}
+		if xProp["scope"], r.Err = reportScope(r.Scope, &c.resolver, z); r.Err != nil {
+			continue
+		}
+		for _, k := range []string{"description", "condition"} {
+			if v := field(k); v != "" {
+				xProp[k] = v
+			}
+		}
+		r.Object = map[string]interface{}{"properties": xProp}
+		if r.Err = c.resolver.resolve("a", r.Object, z); r.Err != nil {
+			continue
+		}
+		if r.Err = validateSpecObject("a", r.Object); r.Err != nil {
+			continue
+		}
+		if xProp["condition"] != nil && xProp["conditionVersion"] == nil {
+			xProp["conditionVersion"] = ConstAbacConditionVersion
+		}
+
+		key := assignmentKey(utl.Str(xProp["roleDefinitionId"]), utl.Str(xProp["principalId"]), utl.Str(xProp["scope"]))
+		switch {
+		case seen[key] > 0:
+			r.Err = fmt.Errorf("same assignment as line %d", seen[key])
+		case c.existing[key]:
+			r.Status = "exists"
+		default:
+			r.Status = "create"
+		}
+		seen[key] = r.Line
+	}
+	return rows, nil
+}
+
+// Prints one line per row: line number, status, role, principal and scope, with any error
+func printBulkAssignmentRows(rows []BulkAssignmentRow, z Bundle) {
+	roleNames := GetIdMapRoleDefs(z)
+	for _, r := range rows {
+		padded := fmt.Sprintf("%-8s", r.Status) // Pad before coloring, escape codes throw off widths
+		status := utl.Gre(padded)
+		switch r.Status {
+		case "invalid", "failed":
+			status = utl.Red(padded)
+		case "exists":
+			status = utl.Yel(padded)
+		}
+		role, principal, scope := r.Role, r.Principal, r.Scope
+		if r.Object != nil {
+			xProp := r.Object["properties"].(map[string]interface{})
+			role = orDefault(roleNames[utl.Str(xProp["roleDefinitionId"])], role)
+			scope = utl.Str(xProp["scope"])
+		}
+		line := fmt.Sprintf("  %4d  %s  %-40s  %-40s  %s", r.Line, status, role, principal, scope)
+		if r.Err != nil {
+			line += "  # " + r.Err.Error()
+		}
+		fmt.Println(line)
+	}
+}
+
+// Prints the dry-run plan for the rows, and returns the number to create and the number invalid
+func PrintBulkAssignmentPlan(rows []BulkAssignmentRow, z Bundle) (create, invalid int) {
+	exists := 0
+	for _, r := range rows {
+		switch r.Status {
+		case "create":
+			create++
+		case "invalid":
+			invalid++
+		case "exists":
+			exists++
+		}
+	}
+	fmt.Println(utl.Blu("plan") + ":")
+	printBulkAssignmentRows(rows, z)
+	fmt.Printf("Plan: %d to create, %d already exist, %d invalid\n", create, exists, invalid)
+	return create, invalid
+}
+
+// Creates the assignments of all rows marked "create", concurrently, and marks each one
+// "created" or "failed"
+func ApplyBulkAssignments(rows []BulkAssignmentRow, z Bundle) {
+	var todo []int
+	for n := range rows {
+		if rows[n].Status == "create" {
+			todo = append(todo, n)
+		}
+	}
+	var mu sync.Mutex
+	RunParallel(len(todo), ConcurrencyLimit(z), func(i int) bool {
+		r := &rows[todo[i]]
+		xProp := r.Object["properties"].(map[string]interface{})
+		resp, statusCode := putAzRoleAssignment("", utl.Str(xProp["roleDefinitionId"]), utl.Str(xProp["principalId"]),
+			utl.Str(xProp["scope"]), xProp, z)
+		mu.Lock()
+		defer mu.Unlock()
+		if statusCode == 200 || statusCode == 201 {
+			r.Status = "created"
+		} else {
+			r.Status = "failed"
+			r.Err = fmt.Errorf("HTTP status %d", statusCode)
+			if e, ok := resp["error"].(map[string]interface{}); ok && utl.Str(e["message"]) != "" {
+				r.Err = fmt.Errorf("%s", utl.Str(e["message"]))
+			}
+		}
+		return false
+	})
+}
+
+// Creates role assignments in bulk from a CSV file. Always validates every row and prints the
+// plan. If apply is true, it then creates the assignments concurrently, after prompting unless
+// force is true, and prints a per-row result report. Invalid rows are never created, and any
+// of them stop the apply unless force is true. Exits with an error status if any row failed, or
+// was skipped as invalid.
+func BulkCreateRoleAssignments(filePath string, apply, force bool, z Bundle) {
+	rows, err := LoadBulkAssignmentsCsv(filePath, z)
+	if err != nil {
+		utl.Die("Error reading %s: %s\n", filePath, err.Error())
+	}
+	create, invalid := PrintBulkAssignmentPlan(rows, z)
+	if !apply {
+		return
+	}
+	if invalid > 0 && !force {
+		utl.Die("Fix the invalid rows first, or force to skip them.\n")
+	}
+	failed := 0
+	if create > 0 {
+		if !force && utl.PromptMsg(fmt.Sprintf("CREATE above %d assignments? y/n ", create)) != 'y' {
+			utl.Die("Aborted.\n")
+		}
+		ApplyBulkAssignments(rows, z)
+		for _, r := range rows {
+			if r.Status == "failed" {
+				failed++
+			}
+		}
+		fmt.Println(utl.Blu("results") + ":")
+		printBulkAssignmentRows(rows, z)
+		fmt.Printf("%d rows, %d created, %d failed\n", len(rows), create-failed, failed)
+		GetAzRoleAssignments(z, false) // Refresh the local cache with the result
+	}
+	if failed > 0 || invalid > 0 {
+		utl.Die("%d rows failed, %d invalid rows skipped\n", failed, invalid)
+	}
+}
@@ -0,0 +1,229 @@
+package maz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBulkCsvLayout(t *testing.T) {
+	defaults := map[string]int{"role": 0, "principal": 1, "scope": 2, "description": 3, "condition": 4}
+	report := map[string]int{"role": 0, "principal": 1, "type": 2, "scope": 3, "condition": 4}
+	tests := []struct {
+		name       string
+		first      []string
+		want       map[string]int
+		wantHeader bool
+	}{
+		{"header", []string{"role", "principal", "scope"},
+			map[string]int{"role": 0, "principal": 1, "scope": 2}, true},
+		{"header in any order", []string{" Scope", "PrincipalName", "description", "RoleDefinitionId"},
+			map[string]int{"scope": 0, "principal": 1, "description": 2, "role": 3}, true},
+		{"header with type and unknown column", []string{"roleName", "principalId", "principalType", "notes", "scope", "condition"},
+			map[string]int{"role": 0, "principal": 1, "type": 2, "scope": 4, "condition": 5}, true},
+		{"incomplete header is data", []string{"role", "principal", "where"}, defaults, false},
+		{"data row", []string{"Reader", "jo@example.com", "sub:Prod-Shared"}, defaults, false},
+		{"data row with description", []string{"Reader", "jo@example.com", "/", "Read everything"}, defaults, false},
+		{"report row", []string{"Reader", "jo@example.com", "User", "Prod-Shared rg/network"}, report, false},
+		{"report row with condition", []string{"Reader", "Admins", " Group", "/", "(cond)"}, report, false},
+		{"report row with unknown object", []string{"Reader", testUser, "SomeObject", "/"}, report, false},
+		{"short row with type", []string{"Reader", "Admins", "Group"}, defaults, false},
+		{"lowercase type is not a report", []string{"Reader", "Admins", "group", "/"}, defaults, false},
+	}
+	for _, tt := range tests {
+		cols, header := bulkCsvLayout(tt.first)
+		if !reflect.DeepEqual(cols, tt.want) || header != tt.wantHeader {
+			t.Errorf("%s: bulkCsvLayout() = %v, %v, want %v, %v", tt.name, cols, header, tt.want, tt.wantHeader)
+		}
+	}
+}
+
+// Returns bulk CSV principals for the users and groups of testNameResolver
+func testBulkPrincipals() *bulkPrincipals {
+	p := &bulkPrincipals{
+		byType: map[string]map[string][]string{"User": {}, "Group": {}, "ServicePrincipal": {}},
+		types:  map[string]string{testUser: "User", testGrp1: "Group", testGrp2: "Group"},
+	}
+	addName(p.byType["User"], "jo@example.com", testUser)
+	addName(p.byType["User"], "Readers", testUser) // Same name as a group
+	addName(p.byType["Group"], "Admins", testGrp1)
+	addName(p.byType["Group"], "Readers", testGrp2)
+	return p
+}
+
+func TestBulkPrincipalsResolve(t *testing.T) {
+	p := testBulkPrincipals()
+	tests := []struct {
+		principal, pType string
+		want             string
+		wantErr          string // Substring of the expected error, "" for no error
+	}{
+		{"jo@example.com", "", testUser, ""},
+		{"JO@example.com", "User", testUser, ""},
+		{"Admins", "SomeObject", testGrp1, ""},
+		{"Readers", "Group", testGrp2, ""},
+		{"Readers", "User", testUser, ""},
+		{"Readers", "", "", "ambiguous principal 'Readers' matches 2 objects"},
+		{"Admins", "User", "", "unknown user 'Admins'"},
+		{"Admins", "Device", "", "unknown principal type 'Device'"},
+		{"Nobody", "", "", "unknown principal 'Nobody'"},
+		{strings.ToUpper(testGrp1), "", testGrp1, ""},
+		{testGrp1, "Group", testGrp1, ""},
+		{testGrp1, "User", "", "is a Group, not a User"},
+		{testSub1, "", "", "is not in the local cache"},
+	}
+	for _, tt := range tests {
+		got, err := p.resolve(tt.principal, tt.pType)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("resolve(%q, %q) error: %v", tt.principal, tt.pType, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("resolve(%q, %q) = %q, want error containing %q", tt.principal, tt.pType, got, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("resolve(%q, %q) error = %q, want error containing %q", tt.principal, tt.pType, err, tt.wantErr)
+		case tt.wantErr == "" && got != tt.want:
+			t.Errorf("resolve(%q, %q) = %q, want %q", tt.principal, tt.pType, got, tt.want)
+		}
+	}
+}
+
+func TestReportScope(t *testing.T) {
+	r := testNameResolver()
+	addName(r.subs, "Prod Shared EU", testSub1) // A subscription name with spaces
+	sub1, sub2 := "/subscriptions/"+testSub1, "/subscriptions/"+testSub2
+	tests := []struct {
+		scope   string
+		want    string
+		wantErr string // Substring of the expected error, "" for no error
+	}{
+		{"Prod-Shared", sub1, ""},
+		{"prod-shared", sub1, ""},
+		{"Dev-Shared resourceGroups/apps", sub2 + "/resourceGroups/apps", ""},
+		{"Dev-Shared /resourceGroups/apps/providers/Microsoft.Storage/storageAccounts/sa1",
+			sub2 + "/resourceGroups/apps/providers/Microsoft.Storage/storageAccounts/sa1", ""},
+		{"Prod Shared EU", sub1, ""},
+		{"Prod Shared EU resourceGroups/network", sub1 + "/resourceGroups/network", ""},
+		{testSub2 + " resourceGroups/apps", sub2 + "/resourceGroups/apps", ""},
+		{sub1 + "/resourceGroups/anything", sub1 + "/resourceGroups/anything", ""},
+		{"/", "/", ""},
+		{"tenant", "/", ""},
+		{"mg:platform", "/providers/Microsoft.Management/managementGroups/platform", ""},
+		{"sub:Prod-Shared/rg:network", sub1 + "/resourceGroups/network", ""},
+		{"Staging", "", "unknown subscription 'Staging'"},
+		{"Staging resourceGroups/apps", "", "unknown subscription 'Staging'"},
+		{"Prod Shared resourceGroups/apps", "", "unknown subscription 'Prod Shared'"},
+	}
+	for _, tt := range tests {
+		got, err := reportScope(tt.scope, r, Bundle{})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("reportScope(%q) error: %v", tt.scope, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("reportScope(%q) = %q, want error containing %q", tt.scope, got, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("reportScope(%q) error = %q, want error containing %q", tt.scope, err, tt.wantErr)
+		case tt.wantErr == "" && got != tt.want:
+			t.Errorf("reportScope(%q) = %q, want %q", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestBulkCsvCacheReadCsv(t *testing.T) {
+	sub1 := "/subscriptions/" + testSub1
+	condition := `(@Resource[Microsoft.Storage/storageAccounts/blobServices/containers:name] StringEquals 'logs')`
+	tests := []struct {
+		name    string
+		csv     string
+		want    []string // "line status scope" prefix of each row, with the error instead of the scope if there is one
+		wantErr string   // Substring of the expected error, "" for no error
+	}{
+		{"header and rows", `
+principal,role,scope
+jo@example.com,Reader,sub:Prod-Shared
+Admins,Reader,/
+`, []string{"3 create " + sub1, "4 exists /"}, ""},
+		{"rows without header", `
+# Comment
+Reader,jo@example.com,Prod-Shared resourceGroups/network,Network reader
+` + testRoleId + `,Admins,tenant
+`, []string{"3 create " + sub1 + "/resourceGroups/network", "4 exists /"}, ""},
+		{"report layout", `
+Reader,Readers,Group,Dev-Shared resourceGroups/apps
+Reader,Readers,User,/,
+`, []string{"2 create /subscriptions/" + testSub2 + "/resourceGroups/apps", "3 create /"}, ""},
+		{"duplicates", `
+Reader,jo@example.com,/
+/providers/Microsoft.Authorization/roleDefinitions/` + testRoleId + `,` + testUser + `,tenant
+`, []string{"2 create /", "3 invalid same assignment as line 2"}, ""},
+		{"invalid rows", `
+role,principal,scope,description,condition
+Reader,jo@example.com
+Owner,jo@example.com,/
+` + testSub1 + `,jo@example.com,/
+Reader,Nobody,/
+Reader,jo@example.com,Staging
+Reader,Admins,/,,(bad condition
+`, []string{
+			"3 invalid row needs a role, a principal and a scope",
+			"4 invalid unknown role 'Owner'",
+			"5 invalid role " + testSub1 + " is not in the local cache",
+			"6 invalid unknown principal 'Nobody'",
+			"7 invalid unknown subscription 'Staging'",
+			"8 invalid invalid condition",
+		}, ""},
+		{"bad CSV", "Reader,\"jo,/\n", nil, "line 1:"},
+	}
+	for _, tt := range tests {
+		c := bulkCsvCache{
+			roleNames:  map[string]string{testRoleId: "Reader"},
+			existing:   map[string]bool{assignmentKey(testRoleId, testGrp1, "/"): true},
+			principals: testBulkPrincipals(),
+			resolver:   *testNameResolver(),
+		}
+		rows, err := c.readCsv(strings.NewReader(tt.csv), Bundle{})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: readCsv() error: %v", tt.name, err)
+			continue
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("%s: readCsv() = %v, want error containing %q", tt.name, rows, tt.wantErr)
+			continue
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("%s: readCsv() error = %q, want error containing %q", tt.name, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, r := range rows {
+			result := ""
+			if r.Err != nil {
+				result = r.Err.Error()
+			} else {
+				result = r.Object["properties"].(map[string]interface{})["scope"].(string)
+			}
+			got = append(got, fmt.Sprintf("%d %s %s", r.Line, r.Status, result))
+		}
+		ok := len(got) == len(tt.want)
+		for n := 0; ok && n < len(got); n++ {
+			ok = strings.HasPrefix(got[n], tt.want[n])
+		}
+		if !ok {
+			t.Errorf("%s: rows =\n  %s\nwant\n  %s", tt.name, strings.Join(got, "\n  "), strings.Join(tt.want, "\n  "))
+		}
+	}
+
+	// A condition gets the default conditionVersion, and the description is kept
+	c := bulkCsvCache{roleNames: map[string]string{}, existing: map[string]bool{}, principals: testBulkPrincipals(),
+		resolver: *testNameResolver()}
+	rows, err := c.readCsv(strings.NewReader("Reader,Admins,/,Logs only,\""+condition+"\"\n"), Bundle{})
+	if err != nil || len(rows) != 1 || rows[0].Status != "create" {
+		t.Fatalf("readCsv() with condition = %v, %v, want one row to create", rows, err)
+	}
+	want := map[string]interface{}{
+		"roleDefinitionId": testRoleId, "principalId": testGrp1, "scope": "/", "description": "Logs only",
+		"condition": condition, "conditionVersion": ConstAbacConditionVersion,
+	}
+	if got := rows[0].Object["properties"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("readCsv() with condition = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package maz
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/queone/utl"
+)
+
+// Azure ABAC role assignment condition support.
+// References:
+//
+//	https://learn.microsoft.com/en-us/azure/role-based-access-control/conditions-format
+//	https://learn.microsoft.com/en-us/azure/storage/blobs/storage-auth-abac-attributes
+
+const ConstAbacConditionVersion = "2.0" // The only version Azure currently accepts
+
+// Optional role assignment attributes, beyond roleDefinitionId, principalId and scope
+var roleAssignmentOptionalKeys = []string{"description", "condition", "conditionVersion", "delegatedManagedIdentityResourceId"}
+
+// Words allowed outside of quotes and attribute brackets in a condition expression
+var abacKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "true": true, "false": true,
+	"actionmatches": true, "suboperationmatches": true, "exists": true, "notexists": true,
+	"stringequals": true, "stringnotequals": true, "stringequalsignorecase": true,
+	"stringnotequalsignorecase": true, "stringlike": true, "stringnotlike": true,
+	"stringstartswith": true, "stringnotstartswith": true,
+	"stringstartswithignorecase": true, "stringnotstartswithignorecase": true,
+	"numericequals": true, "numericnotequals": true, "numericlessthan": true,
+	"numericlessthanequals": true, "numericgreaterthan": true, "numericgreaterthanequals": true,
+	"boolequals": true, "boolnotequals": true, "guidequals": true, "guidnotequals": true,
+	"datetimeequals": true, "datetimenotequals": true, "datetimegreaterthan": true,
+	"datetimegreaterthanequals": true, "datetimelessthan": true, "datetimelessthanequals": true,
+	"timeofdayequals": true, "timeofdaynotequals": true, "timeofdaygreaterthan": true,
+	"timeofdaygreaterthanequals": true, "timeofdaylessthan": true, "timeofdaylessthanequals": true,
+	"timeofdayinrange": true, "ipmatch": true, "ipnotmatch": true, "ipinrange": true, "ipnotinrange": true,
+	"hassubset": true, "hassupersetof": true, "forallofanyvalues": true, "forallofallvalues": true,
+	"foranyofanyvalues": true, "foranyofallvalues": true, "listcontains": true, "listnotcontains": true,
+}
+
+// Attribute sources that may follow '@' in a condition expression
+var abacSources = []string{"Resource", "Request", "Principal", "Environment"}
+
+// Checks the syntax of an ABAC condition expression: balanced parentheses, braces and
+// brackets, terminated quotes, known attribute sources, and known operators. This does not
+// check that attribute names exist for the role's actions; Azure does that on create.
+func ValidateAbacCondition(condition string) error {
+	if strings.TrimSpace(condition) == "" {
+		return fmt.Errorf("condition is empty")
+	}
+	var stack []byte
+	closer := map[byte]byte{')': '(', '}': '{', ']': '['}
+	s := condition
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return fmt.Errorf("unterminated quote at position %d", i)
+			}
+			i += end + 1
+		case c == '(' || c == '{' || c == '[':
+			stack = append(stack, c)
+		case c == ')' || c == '}' || c == ']':
+			if len(stack) == 0 || stack[len(stack)-1] != closer[c] {
+				return fmt.Errorf("unbalanced '%c' at position %d", c, i)
+			}
+			stack = stack[:len(stack)-1]
+		case c == '@':
+			ok := false
+			for _, src := range abacSources {
+				if strings.HasPrefix(s[i+1:], src+"[") {
+					ok = true
+					// Skip over the attribute name, it can contain any characters
+					end := strings.IndexByte(s[i:], ']')
+					if end < 0 {
+						return fmt.Errorf("unterminated attribute at position %d", i)
+					}
+					i += end
+					break
+				}
+			}
+			if !ok {
+				return fmt.Errorf("unknown attribute source at position %d, expected one of @%s[...]",
+					i, strings.Join(abacSources, "[...], @"))
+			}
+		case isAbacWordChar(c) && len(stack) == 0:
+			return fmt.Errorf("condition must be wrapped in parentheses, unexpected '%c' at position %d", c, i)
+		case isAbacWordChar(c) && stack[len(stack)-1] != '{': // Values in {...} lists are not checked
+			start := i
+			for i+1 < len(s) && (isAbacWordChar(s[i+1]) || s[i+1] == ':' || s[i+1] == '-') {
+				i++
+			}
+			word := s[start : i+1]
+			known := abacKeywords[strings.ToLower(word)] || isAbacNumber(word) || utl.ValidUuid(word)
+			if prefix, op, found := strings.Cut(word, ":"); found {
+				// Cross product operators such as ForAnyOfAnyValues:StringEquals
+				known = abacKeywords[strings.ToLower(prefix)] && abacKeywords[strings.ToLower(op)]
+			}
+			if !known {
+				return fmt.Errorf("unknown operator or keyword '%s' at position %d", word, start)
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("missing closing delimiter for '%c'", stack[len(stack)-1])
+	}
+	return nil
+}
+
+func isAbacWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
+}
+
+func isAbacNumber(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if (word[i] < '0' || word[i] > '9') && word[i] != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// Validates the optional ABAC attributes of role assignment properties, and defaults
+// conditionVersion when a condition is given. Dies on invalid values.
+func validateRoleAssignmentCondition(xProp map[string]interface{}) {
+	condition := utl.Str(xProp["condition"])
+	version := utl.Str(xProp["conditionVersion"])
+	if condition == "" {
+		if version != "" {
+			utl.Die("Specfile has a conditionVersion but no condition.\n")
+		}
+		return
+	}
+	if err := ValidateAbacCondition(condition); err != nil {
+		utl.Die("Invalid condition: %s\n", err.Error())
+	}
+	if version == "" {
+		xProp["conditionVersion"] = ConstAbacConditionVersion
+	} else if version != ConstAbacConditionVersion {
+		utl.Die("Unsupported conditionVersion '%s'. Use '%s'.\n", version, ConstAbacConditionVersion)
+	}
+}
+
+// Prints the differences in optional ABAC attributes between specfile and Azure role assignments
+func DiffRoleAssignmentSpecfileVsAzure(a, b map[string]interface{}) {
+	aProp, _ := a["properties"].(map[string]interface{})
+	bProp, _ := b["properties"].(map[string]interface{})
+	same := true
+	for _, k := range roleAssignmentOptionalKeys {
+		aVal, bVal := utl.Str(aProp[k]), utl.Str(bProp[k])
+		if k == "conditionVersion" && aVal == "" && utl.Str(aProp["condition"]) != "" {
+			aVal = ConstAbacConditionVersion // Defaulted on create
+		}
+		if aVal == bVal {
+			continue
+		}
+		same = false
+		fmt.Printf("%s:\n", utl.Blu(k))
+		fmt.Printf("  %s %s\n", utl.Red("- azure:   "), utl.Red(bVal))
+		fmt.Printf("  %s %s\n", utl.Gre("+ specfile:"), utl.Gre(aVal))
+	}
+	if same {
+		fmt.Println("Specfile condition and description attributes match Azure.")
+	}
+}
@@ -0,0 +1,47 @@
+package maz
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAbacCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		wantErr   string // Substring of the expected error, "" for a valid condition
+	}{
+		{`((!(ActionMatches{'Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read'})) OR ` +
+			`(@Resource[Microsoft.Storage/storageAccounts/blobServices/containers:name] StringEquals 'logs'))`, ""},
+		{`(@Resource[Microsoft.Storage/storageAccounts/blobServices/containers/blobs/tags:Project<$key_case_sensitive$>] ` +
+			`StringEquals 'Cascade')`, ""},
+		{`(@Request[Microsoft.Storage/storageAccounts/blobServices/containers/blobs:snapshot] Exists)`, ""},
+		{`(@Principal[Microsoft.Directory/CustomSecurityAttributes/Id:Engineering_Project] ` +
+			`ForAnyOfAnyValues:StringEquals {'a', 'b'})`, ""},
+		{`(@Environment[UtcNow] DateTimeGreaterThan '2024-01-01T00:00:00.0Z')`, ""},
+		{`(@Resource[x] NumericLessThan 10.5)`, ""},
+		{`(@Request[x] GuidEquals 12345678-1234-1234-1234-123456789012)`, ""},
+		{`(@Request[x] stringequalsignorecase 'a' and not @Request[y] BoolEquals true)`, ""},
+		{``, "empty"},
+		{`   `, "empty"},
+		{`@Resource[x] StringEquals 'a'`, "wrapped in parentheses"},
+		{`(@Resource[x] StringEquals 'a'`, "missing closing"},
+		{`(@Resource[x] StringEquals 'a'))`, "unbalanced ')'"},
+		{`(@Resource[x} StringEquals 'a')`, "unterminated attribute"},
+		{`({'a')}`, "unbalanced ')'"},
+		{`(@Resource[x] StringEquals 'a)`, "unterminated quote"},
+		{`(@Tenant[x] StringEquals 'a')`, "unknown attribute source"},
+		{`(@Resource[x] StringMatches 'a')`, "unknown operator or keyword 'StringMatches'"},
+		{`(@Resource[x] ForAnyOfAnyValues:StringMatch {'a'})`, "unknown operator or keyword 'ForAnyOfAnyValues:StringMatch'"},
+	}
+	for _, tt := range tests {
+		err := ValidateAbacCondition(tt.condition)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("ValidateAbacCondition(%q) error: %v", tt.condition, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("ValidateAbacCondition(%q) = nil, want error containing %q", tt.condition, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("ValidateAbacCondition(%q) = %q, want error containing %q", tt.condition, err, tt.wantErr)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/queone/utl"
@@ -134,13 +134,13 @@ func UpsertAzRoleDefinition(force bool, x map[string]interface{}, z Bundle) {
 	if xProp["description"] == nil {
 		xProp["description"] = ""
 	}
-	xScopes := xProp["assignableScopes"].([]interface{})
-	xScope1 := utl.Str(xScopes[0]) // For deployment, we'll use 1st scope
-	var permSet []interface{} = nil
-	if xProp["permissions"] != nil {
-		permSet = xProp["permissions"].([]interface{})
+	xScopes, _ := xProp["assignableScopes"].([]interface{})
+	xScope1 := "" // For deployment, we'll use 1st scope
+	if len(xScopes) > 0 {
+		xScope1 = utl.Str(xScopes[0])
 	}
-	if xProp == nil || xScopes == nil || xRoleName == "" || xScope1 == "" ||
+	permSet, _ := xProp["permissions"].([]interface{})
+	if xScopes == nil || xRoleName == "" || xScope1 == "" ||
 		permSet == nil || len(permSet) < 1 {
 		utl.Die("Specfile is missing required attributes. The bare minimum is:\n\n" +
 			"properties:\n" +
@@ -170,23 +170,32 @@ func UpsertAzRoleDefinition(force bool, x map[string]interface{}, z Bundle) {
 		roleId = utl.Str(existing["name"])
 	}
 
-	payload := x                                             // Obviously using x object as the payload
+	if r := PutAzRoleDefinition(roleId, x, z); r != nil {
+		PrintRoleDefinition(r, z) // Print the newly updated object
+	}
+}
+
+// Creates or updates the role definition with given UUID as defined by x object, deploying it
+// at its first assignable scope. Returns the resulting object, or nil after printing the error.
+func PutAzRoleDefinition(roleId string, x map[string]interface{}, z Bundle) map[string]interface{} {
+	xProp := x["properties"].(map[string]interface{})
+	xScope1 := utl.Str(xProp["assignableScopes"].([]interface{})[0])
+	payload := map[string]interface{}{"properties": xProp}   // Only properties are accepted in the body
 	params := map[string]string{"api-version": "2022-04-01"} // roleDefinitions
 	url := ConstAzUrl + xScope1 + "/providers/Microsoft.Authorization/roleDefinitions/" + roleId
 	r, statusCode, _ := ApiPut(url, z, payload, params)
-	if statusCode == 201 {
-		PrintRoleDefinition(r, z) // Print the newly updated object
-	} else {
-		e := r["error"].(map[string]interface{})
-		fmt.Println(e["message"].(string))
+	if statusCode == 201 || statusCode == 200 {
+		return r
 	}
+	printApiError(r, statusCode)
+	return nil
 }
 
 // Deletes an RBAC role definition object by its fully qualified object Id
 // Example of a fully qualified Id string:
 //
 //	"/providers/Microsoft.Authorization/roleDefinitions/50a6ff7c-3ac5-4acc-b4f4-9a43aee0c80f"
-func DeleteAzRoleDefinitionByFqid(fqid string, z Bundle) map[string]interface{} {
+func DeleteAzRoleDefinitionByFqid(fqid string, z Bundle) error {
 	params := map[string]string{"api-version": "2022-04-01"} // roleDefinitions
 	url := ConstAzUrl + fqid
 	r, statusCode, _ := ApiDelete(url, z, params)
@@ -195,8 +204,7 @@ func DeleteAzRoleDefinitionByFqid(fqid string, z Bundle) map[string]interface{}
 		if statusCode == 204 {
 			fmt.Println("Role definition already deleted or does not exist. Give Azure a minute to flush it out.")
 		} else {
-			e := r["error"].(map[string]interface{})
-			fmt.Println(e["message"].(string))
+			return printApiError(r, statusCode)
 		}
 	}
 	return nil
@@ -223,11 +231,10 @@ func GetIdMapRoleDefs(z Bundle) (nameMap map[string]string) {
 func RoleDefinitionCountLocal(z Bundle) (builtin, custom int64) {
 	var customList []interface{} = nil
 	var builtinList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_roleDefinitions."+ConstCacheFileExtension)
+	cacheFile := CacheFile("d", z)
 	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			definitions := rawList.([]interface{})
+		definitions := GetCachedObjects(cacheFile)
+		if definitions != nil {
 			for _, i := range definitions {
 				x := i.(map[string]interface{}) // Assert as JSON object type
 				xProp := x["properties"].(map[string]interface{})
@@ -262,10 +269,11 @@ func RoleDefinitionCountAzure(z Bundle) (builtin, custom int64) {
 
 // Gets all role definitions matching on 'filter'. Returns entire list if filter is empty ""
 func GetMatchingRoleDefinitions(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_roleDefinitions."+ConstCacheFileExtension)
+	MigrateCache("d", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("d", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstAzCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzRoleDefinitions(z, true)
@@ -295,9 +303,10 @@ func GetMatchingRoleDefinitions(filter string, force bool, z Bundle) (list []int
 //	https://learn.microsoft.com/en-us/azure/role-based-access-control/role-definitions-list
 //	https://learn.microsoft.com/en-us/rest/api/authorization/role-definitions/list
 func GetAzRoleDefinitions(z Bundle, verbose bool) (list []interface{}) {
-	list = nil             // We have to zero it out
-	var uniqueIds []string // Keep track of assignment objects
-	k := 1                 // Track number of API calls to provide progress
+	list = nil                // We have to zero it out
+	uniqueIds := NewSyncSet() // Keep track of definition objects, across all parallel workers
+	var mu sync.Mutex         // Guards list, k, and the progress output
+	k := 1                    // Track number of API calls to provide progress
 
 	var mgGroupNameMap, subNameMap map[string]string
 	if verbose {
@@ -307,36 +316,37 @@ func GetAzRoleDefinitions(z Bundle, verbose bool) (list []interface{}) {
 
 	scopes := GetAzRbacScopes(z)                             // Get all scopes
 	params := map[string]string{"api-version": "2022-04-01"} // roleDefinitions
-	for _, scope := range scopes {
+	RunParallel(len(scopes), ConcurrencyLimit(z), func(n int) bool {
+		scope := scopes[n]
 		url := ConstAzUrl + scope + "/providers/Microsoft.Authorization/roleDefinitions"
 		r, _, _ := ApiGet(url, z, params)
+		var objects []interface{} = nil
 		if r != nil && r["value"] != nil {
 			objectsUnderThisScope := r["value"].([]interface{})
-			count := 0
 			for _, i := range objectsUnderThisScope {
 				x := i.(map[string]interface{})
-				uuid := utl.Str(x["name"])
-				if utl.ItemInList(uuid, uniqueIds) {
+				if !uniqueIds.Add(utl.Str(x["name"])) {
 					continue // Skip this repeated one. This can happen due to inherited nesting
 				}
-				uniqueIds = append(uniqueIds, uuid) // Keep track of the UUIDs we are seeing
-				list = append(list, x)
-				count++
+				objects = append(objects, x)
 			}
-			if verbose && count > 0 {
-				scopeName := scope
-				if strings.HasPrefix(scope, "/providers") {
-					scopeName = mgGroupNameMap[scope]
-				} else if strings.HasPrefix(scope, "/subscriptions") {
-					scopeName = subNameMap[utl.LastElem(scope, "/")]
-				}
-				fmt.Printf("API call %4d: %5d objects under %s\n", k, count, scopeName)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		list = append(list, objects...)
+		if verbose && len(objects) > 0 {
+			scopeName := scope
+			if strings.HasPrefix(scope, "/providers") {
+				scopeName = mgGroupNameMap[scope]
+			} else if strings.HasPrefix(scope, "/subscriptions") {
+				scopeName = subNameMap[utl.LastElem(scope, "/")]
 			}
+			fmt.Printf("API call %4d: %5d objects under %s\n", k, len(objects), scopeName)
 		}
 		k++
-	}
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_roleDefinitions."+ConstCacheFileExtension)
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+		return false
+	})
+	SaveCachedObjects("d", list, z) // Update the local cache
 	return list
 }
 
@@ -372,13 +382,13 @@ func GetAzRoleDefinitionByObject(x map[string]interface{}, z Bundle) (y map[stri
 	if x == nil { // Don't look for empty objects
 		return nil
 	}
-	xProp := x["properties"].(map[string]interface{})
+	xProp, _ := x["properties"].(map[string]interface{})
 	if xProp == nil {
 		return nil
 	}
 
-	xScopes := xProp["assignableScopes"].([]interface{})
-	if utl.GetType(xScopes)[0] != '[' || len(xScopes) < 1 {
+	xScopes, _ := xProp["assignableScopes"].([]interface{})
+	if len(xScopes) < 1 {
 		return nil // Return nil if assignableScopes not an array, or it's empty
 	}
 	xRoleName := utl.Str(xProp["roleName"])
@@ -427,3 +437,13 @@ func GetAzRoleDefinitionByUuid(uuid string, z Bundle) map[string]interface{} {
 	}
 	return nil
 }
+
+// Returns typed role definitions matching on filter. See GetMatchingRoleDefinitions.
+func ListRoleDefinitions(filter string, force bool, z Bundle) ([]RoleDefinition, error) {
+	return ToModelList[RoleDefinition](GetMatchingRoleDefinitions(filter, force, z))
+}
+
+// Returns typed role definition with given UUID from Azure, or nil if not found
+func GetRoleDefinition(uuid string, z Bundle) (*RoleDefinition, error) {
+	return toModelPtr[RoleDefinition](GetAzRoleDefinitionByUuid(uuid, z))
+}
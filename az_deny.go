@@ -0,0 +1,242 @@
+package maz
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/queone/utl"
+)
+
+// Azure deny assignments, maz type "dn", and classic subscription administrators, maz type "ca".
+// References:
+//
+//	https://learn.microsoft.com/en-us/rest/api/authorization/deny-assignments/list
+//	https://learn.microsoft.com/en-us/rest/api/authorization/classic-administrators/list
+
+const (
+	ConstDenyApiVersion    = "2022-04-01" // denyAssignments
+	ConstClassicApiVersion = "2015-07-01" // classicAdministrators
+)
+
+// Prints deny assignment object in YAML-like format
+func PrintDenyAssignment(x map[string]interface{}, z Bundle) {
+	if x == nil {
+		return
+	}
+	fmt.Printf("%s: %s\n", utl.Blu("id"), utl.Gre(utl.Str(x["name"])))
+	xProp, ok := x["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	fmt.Println(utl.Blu("properties") + ":")
+	for _, k := range []string{"denyAssignmentName", "description", "scope", "doNotApplyToChildScopes", "isSystemProtected"} {
+		if v := utl.Str(xProp[k]); v != "" {
+			fmt.Printf("  %s: %s\n", utl.Blu(k), utl.Gre(v))
+		}
+	}
+	if perms, ok := xProp["permissions"].([]interface{}); ok && len(perms) > 0 {
+		fmt.Printf("  %s:\n", utl.Blu("permissions"))
+		for _, i := range perms {
+			perm := i.(map[string]interface{})
+			for _, k := range []string{"actions", "notActions", "dataActions", "notDataActions"} {
+				list, _ := perm[k].([]interface{})
+				if len(list) < 1 {
+					continue
+				}
+				fmt.Printf("    %s:\n", utl.Blu(k))
+				for _, a := range list {
+					fmt.Printf("      - %s\n", utl.Gre(utl.StrSingleQuote(a)))
+				}
+			}
+		}
+	}
+
+	// Principals are returned with ids and types only, so annotate them with names
+	nameMaps := map[string]map[string]string{}
+	principalName := func(id, pType string) string {
+		if nameMaps[pType] == nil {
+			switch pType {
+			case "User":
+				nameMaps[pType] = GetIdMapUsers(z)
+			case "Group":
+				nameMaps[pType] = GetIdMapGroups(z)
+			case "ServicePrincipal":
+				nameMaps[pType] = GetIdMapSps(z)
+			default:
+				nameMaps[pType] = map[string]string{}
+			}
+		}
+		return nameMaps[pType][id]
+	}
+	for _, k := range []string{"principals", "excludePrincipals"} {
+		list, _ := xProp[k].([]interface{})
+		if len(list) < 1 {
+			continue
+		}
+		fmt.Printf("  %s:\n", utl.Blu(k))
+		for _, i := range list {
+			p := i.(map[string]interface{})
+			id, pType := utl.Str(p["id"]), utl.Str(p["type"])
+			comment := pType
+			if id == "00000000-0000-0000-0000-000000000000" {
+				comment = "Everyone"
+			} else if name := principalName(id, pType); name != "" {
+				comment += " \"" + name + "\""
+			}
+			fmt.Printf("    - %s  # %s\n", utl.Gre(id), comment)
+		}
+	}
+}
+
+// Prints classic subscription administrator object in YAML-like format
+func PrintClassicAdmin(x map[string]interface{}, z Bundle) {
+	if x == nil {
+		return
+	}
+	fmt.Printf("%s: %s\n", utl.Blu("id"), utl.Gre(utl.Str(x["name"])))
+	xProp, _ := x["properties"].(map[string]interface{})
+	fmt.Printf("%s: %s\n", utl.Blu("emailAddress"), utl.Gre(utl.Str(xProp["emailAddress"])))
+	fmt.Printf("%s: %s\n", utl.Blu("role"), utl.Gre(utl.Str(xProp["role"])))
+	subId := classicAdminSubId(x)
+	fmt.Printf("%s: %s  # %s\n", utl.Blu("subscription"), utl.Gre(subId), GetIdMapSubs(z)[subId])
+}
+
+// Returns the subscription UUID from a classic administrator's fully qualified id
+func classicAdminSubId(x map[string]interface{}) string {
+	split := strings.Split(utl.Str(x["id"]), "/")
+	if len(split) > 2 && strings.EqualFold(split[1], "subscriptions") {
+		return split[2]
+	}
+	return ""
+}
+
+// Returns count of deny assignment objects in local cache file
+func DenyAssignmentsCountLocal(z Bundle) int64 {
+	return int64(len(GetCachedObjects(CacheFile("dn", z))))
+}
+
+// Gets all deny assignments across all RBAC scopes, and saves them to local cache file
+func GetAzDenyAssignments(z Bundle, verbose bool) (list []interface{}) {
+	list = nil
+	uniqueIds := NewSyncSet()
+	var mu sync.Mutex
+	k := 1
+	scopes := append(GetAzRbacScopes(z), GetAzDeepRbacScopes(z)...)
+	params := map[string]string{"api-version": ConstDenyApiVersion}
+	RunParallel(len(scopes), ConcurrencyLimit(z), func(n int) bool {
+		url := ConstAzUrl + scopes[n] + "/providers/Microsoft.Authorization/denyAssignments"
+		var objects []interface{} = nil
+		for _, i := range getAzArmAllPages(url, params, z) {
+			x := i.(map[string]interface{})
+			if uniqueIds.Add(utl.Str(x["name"])) { // Skip repeats inherited from parent scopes
+				objects = append(objects, x)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		list = append(list, objects...)
+		if verbose && len(objects) > 0 {
+			fmt.Printf("API call %4d: %5d objects under %s\n", k, len(objects), scopes[n])
+		}
+		k++
+		return false
+	})
+	SaveCachedObjects("dn", list, z) // Update the local cache
+	return list
+}
+
+// Gets all deny assignments matching on 'filter'. Returns entire list if filter is empty ""
+func GetMatchingDenyAssignments(filter string, force bool, z Bundle) (list []interface{}) {
+	MigrateCache("dn", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("dn", z)
+	cacheFileAge := utl.FileAge(cacheFile)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
+		list = GetAzDenyAssignments(z, true)
+	} else {
+		list = GetCachedObjects(cacheFile)
+	}
+
+	if filter == "" {
+		return list
+	}
+	var matchingList []interface{} = nil
+	for _, i := range list {
+		x := i.(map[string]interface{})
+		if utl.StringInJson(x, filter) {
+			matchingList = append(matchingList, x)
+		}
+	}
+	return matchingList
+}
+
+// Gets deny assignment by its UUID, searching all scopes in parallel
+func GetAzDenyAssignmentByUuid(uuid string, z Bundle) (y map[string]interface{}) {
+	var mu sync.Mutex
+	scopes := append(GetAzRbacScopes(z), GetAzDeepRbacScopes(z)...)
+	params := map[string]string{"api-version": ConstDenyApiVersion}
+	RunParallel(len(scopes), ConcurrencyLimit(z), func(n int) bool {
+		url := ConstAzUrl + scopes[n] + "/providers/Microsoft.Authorization/denyAssignments/" + uuid
+		r, statusCode, _ := ApiGet(url, z, params)
+		if statusCode == 200 && r != nil && utl.Str(r["name"]) == uuid {
+			mu.Lock()
+			y = r
+			mu.Unlock()
+			return true // Stop as soon as we find a match
+		}
+		return false
+	})
+	return y
+}
+
+// Gets classic administrators of given subscription UUID
+func GetAzClassicAdminsBySub(subId string, z Bundle) (list []interface{}) {
+	url := ConstAzUrl + "/subscriptions/" + subId + "/providers/Microsoft.Authorization/classicAdministrators"
+	params := map[string]string{"api-version": ConstClassicApiVersion}
+	return getAzArmAllPages(url, params, z)
+}
+
+// Gets classic administrators of all subscriptions, and saves them to local cache file
+func GetAzClassicAdmins(z Bundle) (list []interface{}) {
+	list = getAzObjectsUnderSubs("/providers/Microsoft.Authorization/classicAdministrators", ConstClassicApiVersion, z)
+	SaveCachedObjects("ca", list, z) // Update the local cache
+	return list
+}
+
+// Gets all classic administrators matching on 'filter'. Returns entire list if filter is empty ""
+func GetMatchingClassicAdmins(filter string, force bool, z Bundle) (list []interface{}) {
+	return getMatchingScopeObjects("ca", GetAzClassicAdmins, filter, force, z)
+}
+
+// Gets classic administrator by its UUID name, from the cached list of all subscriptions
+func GetAzClassicAdminByUuid(uuid string, z Bundle) (y map[string]interface{}) {
+	for _, i := range GetMatchingClassicAdmins("", false, z) {
+		x := i.(map[string]interface{})
+		if strings.EqualFold(utl.Str(x["name"]), uuid) {
+			return x
+		}
+	}
+	return nil
+}
+
+// Prints the classic administrators of every subscription, grouped by subscription
+func PrintClassicAdmins(z Bundle) {
+	subNameMap := GetIdMapSubs(z)
+	bySub := map[string][]map[string]interface{}{}
+	var subIds []string
+	for _, i := range GetMatchingClassicAdmins("", false, z) {
+		x := i.(map[string]interface{})
+		subId := classicAdminSubId(x)
+		if bySub[subId] == nil {
+			subIds = append(subIds, subId)
+		}
+		bySub[subId] = append(bySub[subId], x)
+	}
+	for _, subId := range subIds {
+		fmt.Printf("%s  %s\n", utl.Blu(subId), utl.Blu(subNameMap[subId]))
+		for _, x := range bySub[subId] {
+			xProp, _ := x["properties"].(map[string]interface{})
+			fmt.Printf("  %-50s  %s\n", utl.Gre(utl.Str(xProp["emailAddress"])), utl.Gre(utl.Str(xProp["role"])))
+		}
+	}
+}
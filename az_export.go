@@ -0,0 +1,310 @@
+package maz
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/queone/utl"
+)
+
+// Exports existing custom role definitions and role assignments as specfiles that UpsertAzObject
+// and the reconcile functions accept. The directory layout is:
+//
+//	<dir>/definitions/<roleName>.yaml
+//	<dir>/assignments/<scope name>/<role name>--<principal name>.yaml
+
+// Names used in export comments and file names
+type exportNames struct {
+	roles, principals, principalTypes, subs, mgs map[string]string
+}
+
+func getExportNames(z Bundle) (n exportNames) {
+	n.roles = GetIdMapRoleDefs(z)
+	n.subs = GetIdMapSubs(z)
+	n.mgs = map[string]string{}
+	for _, i := range GetMatchingMgGroups("", false, z) {
+		x := i.(map[string]interface{})
+		name := utl.Str(x["displayName"])
+		if xProp, ok := x["properties"].(map[string]interface{}); ok && name == "" {
+			name = utl.Str(xProp["displayName"])
+		}
+		if name == "" {
+			name = utl.Str(x["name"])
+		}
+		n.mgs[strings.ToLower(utl.Str(x["id"]))] = name
+	}
+	n.principals = map[string]string{}
+	n.principalTypes = map[string]string{}
+	for pType, m := range map[string]map[string]string{
+		"User": GetIdMapUsers(z), "Group": GetIdMapGroups(z), "ServicePrincipal": GetIdMapSps(z),
+	} {
+		for id, name := range m {
+			n.principals[id] = name
+			n.principalTypes[id] = pType
+		}
+	}
+	return n
+}
+
+// Returns a short human-readable name for a scope, such as "Sub = Prod" or "MG = Platform"
+func (n exportNames) scopeComment(scope string) string {
+	split := strings.Split(scope, "/")
+	switch {
+	case scope == "/":
+		return "Entire tenant"
+	case strings.HasPrefix(strings.ToLower(scope), "/subscriptions/") && len(split) > 2:
+		return "Sub = " + n.subs[split[2]]
+	case strings.HasPrefix(strings.ToLower(scope), "/providers/microsoft.management/managementgroups/"):
+		return "MG = " + n.mgs[strings.ToLower(scope)]
+	}
+	return ""
+}
+
+// Returns the directory name for assignments at given scope
+func (n exportNames) scopeDirName(scope string) string {
+	split := strings.Split(strings.TrimPrefix(scope, "/"), "/")
+	switch {
+	case scope == "/":
+		return "tenant"
+	case strings.EqualFold(split[0], "subscriptions") && len(split) > 1:
+		name := "sub-" + orDefault(n.subs[split[1]], split[1])
+		if len(split) > 3 && strings.EqualFold(split[2], "resourceGroups") {
+			name += "/rg-" + split[3]
+			if len(split) > 4 {
+				name += "/" + strings.Join(split[4:], "-")
+			}
+		}
+		return exportFileName(name)
+	case strings.HasPrefix(strings.ToLower(scope), "/providers/microsoft.management/managementgroups/"):
+		return exportFileName("mg-" + orDefault(n.mgs[strings.ToLower(scope)], utl.LastElem(scope, "/")))
+	}
+	return exportFileName(strings.Join(split, "-"))
+}
+
+// Returns name with every character that is unsafe in a file name replaced by '-'. Slashes
+// are kept so that callers can build nested paths.
+func exportFileName(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-/", c) {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// Returns s as a YAML scalar, double-quoted only when needed. JSON strings are valid YAML.
+func yamlScalar(s string) string {
+	plain := s != "" && !strings.ContainsAny(s, ":#'\"{}[],&*!|>%@`\n\t") &&
+		!strings.HasPrefix(s, " ") && !strings.HasSuffix(s, " ") && !strings.HasPrefix(s, "-") &&
+		!strings.HasPrefix(s, "?")
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		plain = false
+	}
+	if plain && isAbacNumber(s) {
+		plain = false
+	}
+	if plain {
+		return s
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func yamlLine(b *strings.Builder, indent, key, value, comment string) {
+	b.WriteString(indent + key + ": " + yamlScalar(value))
+	if comment != "" {
+		b.WriteString("  # " + comment)
+	}
+	b.WriteString("\n")
+}
+
+// Returns the specfile object for an existing role definition, keeping its UUID in 'name' so
+// that renaming the role in the specfile renames it in Azure
+func roleDefinitionSpec(x map[string]interface{}) map[string]interface{} {
+	xProp := x["properties"].(map[string]interface{})
+	prop := map[string]interface{}{
+		"roleName":         xProp["roleName"],
+		"description":      utl.Str(xProp["description"]),
+		"assignableScopes": xProp["assignableScopes"],
+		"permissions":      xProp["permissions"],
+	}
+	return map[string]interface{}{"name": x["name"], "properties": prop}
+}
+
+// Returns the specfile object for an existing role assignment
+func roleAssignmentSpec(x map[string]interface{}) map[string]interface{} {
+	xProp := x["properties"].(map[string]interface{})
+	prop := map[string]interface{}{
+		"roleDefinitionId": utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/"),
+		"principalId":      xProp["principalId"],
+		"scope":            xProp["scope"],
+	}
+	for _, k := range roleAssignmentOptionalKeys {
+		if v := utl.Str(xProp[k]); v != "" {
+			prop[k] = v
+		}
+	}
+	return map[string]interface{}{"properties": prop}
+}
+
+// Renders role definition specfile object as YAML, with scope names in comments
+func roleDefinitionYaml(spec map[string]interface{}, n exportNames) string {
+	var b strings.Builder
+	prop := spec["properties"].(map[string]interface{})
+	yamlLine(&b, "", "name", utl.Str(spec["name"]), "Stable id, keep it to allow renames")
+	b.WriteString("properties:\n")
+	yamlLine(&b, "  ", "roleName", utl.Str(prop["roleName"]), "")
+	yamlLine(&b, "  ", "description", utl.Str(prop["description"]), "")
+	b.WriteString("  assignableScopes:\n")
+	scopes, _ := prop["assignableScopes"].([]interface{})
+	for _, i := range scopes {
+		b.WriteString("    - " + yamlScalar(utl.Str(i)))
+		if c := n.scopeComment(utl.Str(i)); c != "" {
+			b.WriteString("  # " + c)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("  permissions:\n")
+	perms, _ := prop["permissions"].([]interface{})
+	for _, i := range perms {
+		perm, _ := i.(map[string]interface{})
+		first := true
+		for _, k := range []string{"actions", "notActions", "dataActions", "notDataActions"} {
+			list, _ := perm[k].([]interface{})
+			prefix := "      "
+			if first {
+				prefix = "    - "
+				first = false
+			}
+			if len(list) < 1 {
+				b.WriteString(prefix + k + ": []\n")
+				continue
+			}
+			b.WriteString(prefix + k + ":\n")
+			for _, a := range list {
+				b.WriteString("        - " + yamlScalar(utl.Str(a)) + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// Renders role assignment specfile object as YAML, with role, principal and scope names in
+// comments, the same way PrintRoleAssignment annotates them
+func roleAssignmentYaml(spec map[string]interface{}, n exportNames) string {
+	var b strings.Builder
+	prop := spec["properties"].(map[string]interface{})
+	b.WriteString("properties:\n")
+	roleId := utl.Str(prop["roleDefinitionId"])
+	yamlLine(&b, "  ", "roleDefinitionId", roleId, "Role \""+n.roles[roleId]+"\"")
+	principalId := utl.Str(prop["principalId"])
+	pType := orDefault(n.principalTypes[principalId], "SomeObject")
+	pName := orDefault(n.principals[principalId], "???")
+	yamlLine(&b, "  ", "principalId", principalId, pType+" \""+pName+"\"")
+	scope := utl.Str(prop["scope"])
+	yamlLine(&b, "  ", "scope", scope, n.scopeComment(scope))
+	for _, k := range roleAssignmentOptionalKeys {
+		if v := utl.Str(prop[k]); v != "" {
+			yamlLine(&b, "  ", k, v, "")
+		}
+	}
+	return b.String()
+}
+
+// Writes content to path, creating its directory. If path was already written in this run,
+// as recorded in written, a numeric suffix is added so objects with identical names don't
+// overwrite each other. Files left by earlier runs are overwritten, so exporting again into
+// the same directory updates it in place. Returns the path written.
+func writeExportFile(path, content string, written map[string]bool) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for k := 2; written[strings.ToLower(path)]; k++ {
+		path = fmt.Sprintf("%s-%d%s", base, k, ext)
+	}
+	written[strings.ToLower(path)] = true // Lowercased, for case-insensitive file systems
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		utl.Die("Error creating %s: %s\n", filepath.Dir(path), err.Error())
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		utl.Die("Error writing %s: %s\n", path, err.Error())
+	}
+	return path
+}
+
+// Exports every custom role definition and role assignment to specfiles under dir, in "yaml" or
+// "json" format. JSON can't hold comments, so names are only annotated in YAML. If scope is
+// given, only objects at or below it are exported, and if principal is given, only assignments
+// for the principal with that UUID or name. Returns the number of files written.
+func ExportRbacSpecfiles(dir, format, scope, principal string, force bool, z Bundle) (count int) {
+	format = strings.ToLower(format)
+	if format != "yaml" && format != "json" {
+		utl.Die("Unsupported export format '%s'. Use 'yaml' or 'json'.\n", format)
+	}
+	n := getExportNames(z)
+	inScope := func(s string) bool {
+		return scope == "" || scopeIsManaged(s, []string{scope})
+	}
+	render := func(spec map[string]interface{}, toYaml func(map[string]interface{}, exportNames) string) string {
+		if format == "yaml" {
+			return toYaml(spec, n)
+		}
+		b, _ := json.MarshalIndent(spec, "", "  ")
+		return string(b) + "\n"
+	}
+	written := map[string]bool{}
+
+	if principal == "" {
+		for _, i := range GetMatchingRoleDefinitions("", force, z) {
+			x := i.(map[string]interface{})
+			xProp := x["properties"].(map[string]interface{})
+			if utl.Str(xProp["type"]) != "CustomRole" {
+				continue
+			}
+			exported := scope == ""
+			for _, s := range xProp["assignableScopes"].([]interface{}) {
+				exported = exported || inScope(utl.Str(s))
+			}
+			if !exported {
+				continue
+			}
+			path := filepath.Join(dir, "definitions", exportFileName(utl.Str(xProp["roleName"]))+"."+format)
+			fmt.Println(writeExportFile(path, render(roleDefinitionSpec(x), roleDefinitionYaml), written))
+			count++
+		}
+	}
+
+	for _, i := range GetMatchingRoleAssignments("", force, z) {
+		x := i.(map[string]interface{})
+		xProp := x["properties"].(map[string]interface{})
+		s := utl.Str(xProp["scope"])
+		principalId := utl.Str(xProp["principalId"])
+		if !inScope(s) {
+			continue
+		}
+		if principal != "" && !strings.EqualFold(principal, principalId) && !strings.EqualFold(principal, n.principals[principalId]) {
+			continue
+		}
+		roleId := utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/")
+		name := orDefault(n.roles[roleId], roleId) + "--" + orDefault(n.principals[principalId], principalId)
+		path := filepath.Join(dir, "assignments", n.scopeDirName(s), exportFileName(strings.ReplaceAll(name, "/", "-"))+"."+format)
+		fmt.Println(writeExportFile(path, render(roleAssignmentSpec(x), roleAssignmentYaml), written))
+		count++
+	}
+	fmt.Printf("Exported %d specfiles to %s\n", count, dir)
+	return count
+}
+
+// Returns s, or def if s is empty
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -2,7 +2,7 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
+	"strings"
 
 	"github.com/queone/utl"
 )
@@ -32,16 +32,8 @@ func MgType(typeIn string) string {
 
 // Returns count of management group objects in local cache file
 func MgGroupCountLocal(z Bundle) int64 {
-	var cachedList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_managementGroups."+ConstCacheFileExtension)
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-			return int64(len(cachedList))
-		}
-	}
-	return 0
+	cachedList := GetCachedObjects(CacheFile("m", z))
+	return int64(len(cachedList))
 }
 
 // Returns count of management groups in Azure
@@ -64,10 +56,11 @@ func GetIdMapMgGroups(z Bundle) (nameMap map[string]string) {
 
 // Gets all Azure management groups matching on 'filter'. Returns entire list if filter is empty ""
 func GetMatchingMgGroups(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_managementGroups."+ConstCacheFileExtension)
+	MigrateCache("m", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("m", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstAzCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzMgGroups(z)
@@ -101,8 +94,7 @@ func GetAzMgGroups(z Bundle) (list []interface{}) {
 		objects := r["value"].([]interface{})
 		list = append(list, objects...)
 	}
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_managementGroups."+ConstCacheFileExtension)
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+	SaveCachedObjects("m", list, z) // Update the local cache
 	return list
 }
 
@@ -154,3 +146,52 @@ func PrintMgTree(z Bundle) {
 		}
 	}
 }
+
+// Returns typed management groups matching on filter. See GetMatchingMgGroups.
+func ListManagementGroups(filter string, force bool, z Bundle) ([]ManagementGroup, error) {
+	return ToModelList[ManagementGroup](GetMatchingMgGroups(filter, force, z))
+}
+
+// Returns typed management group with given name (its ID) from the local cache, or nil if
+// not found. Management groups have no UUID based lookup.
+func GetManagementGroup(name string, z Bundle) (*ManagementGroup, error) {
+	for _, i := range GetMatchingMgGroups("", false, z) {
+		x := i.(map[string]interface{})
+		if strings.EqualFold(utl.Str(x["name"]), name) {
+			return toModelPtr[ManagementGroup](x)
+		}
+	}
+	return nil, nil
+}
+
+// Returns a map of every management group and subscription ID in the tenant hierarchy to
+// the ID of its parent management group. All IDs are lowercased.
+func GetAzMgParentMap(z Bundle) (parents map[string]string) {
+	parents = make(map[string]string)
+	url := ConstAzUrl + "/providers/Microsoft.Management/managementGroups/" + z.TenantId
+	params := map[string]string{
+		"api-version": "2020-05-01", // managementGroups
+		"$expand":     "children",
+		"$recurse":    "true",
+	}
+	r, statusCode, _ := ApiGet(url, z, params)
+	if statusCode != 200 || r == nil || r["properties"] == nil {
+		return parents // Caller may not be able to read the hierarchy
+	}
+	var walk func(parentId string, children []interface{})
+	walk = func(parentId string, children []interface{}) {
+		for _, i := range children {
+			child := i.(map[string]interface{})
+			childId := strings.ToLower(utl.Str(child["id"]))
+			parents[childId] = parentId
+			if child["children"] != nil {
+				walk(childId, child["children"].([]interface{}))
+			}
+		}
+	}
+	rootProp := r["properties"].(map[string]interface{})
+	if rootProp["children"] != nil {
+		walk(strings.ToLower(utl.Str(r["id"])), rootProp["children"].([]interface{}))
+	}
+	return parents
+}
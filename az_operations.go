@@ -0,0 +1,325 @@
+package maz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/queone/utl"
+)
+
+// ARM provider operations catalog, maz type "po". This is the list of every action and data
+// action that resource providers publish, which is what role definition actions, notActions,
+// dataActions and notDataActions patterns are matched against. The catalog is cached flattened,
+// as one object per operation. References:
+//
+//	https://learn.microsoft.com/en-us/rest/api/authorization/provider-operations-metadata/list
+//	https://learn.microsoft.com/en-us/azure/role-based-access-control/resource-provider-operations
+
+const ConstProviderOpsApiVersion = "2022-04-01" // providerOperations
+
+// Prints provider operation object in YAML-like format
+func PrintProviderOperation(x map[string]interface{}) {
+	if x == nil {
+		return
+	}
+	for _, k := range []string{"name", "displayName", "description", "provider", "resourceType", "origin", "isDataAction"} {
+		if v := utl.Str(x[k]); v != "" {
+			fmt.Printf("%s: %s\n", utl.Blu(k), utl.Gre(v))
+		}
+	}
+}
+
+// Returns count of provider operations in local cache file
+func ProviderOpsCountLocal(z Bundle) int64 {
+	return int64(len(GetCachedObjects(CacheFile("po", z))))
+}
+
+// Gets the provider operations catalog, flattens it to one object per operation, and saves it
+// to local cache file
+func GetAzProviderOperations(z Bundle) (list []interface{}) {
+	url := ConstAzUrl + "/providers/Microsoft.Authorization/providerOperations"
+	params := map[string]string{"api-version": ConstProviderOpsApiVersion, "$expand": "resourceTypes"}
+	addOps := func(provider, resourceType string, ops interface{}) {
+		opList, _ := ops.([]interface{})
+		for _, i := range opList {
+			op, ok := i.(map[string]interface{})
+			if !ok || utl.Str(op["name"]) == "" {
+				continue
+			}
+			list = append(list, map[string]interface{}{
+				"name":         utl.Str(op["name"]),
+				"displayName":  utl.Str(op["displayName"]),
+				"description":  utl.Str(op["description"]),
+				"origin":       utl.Str(op["origin"]),
+				"isDataAction": op["isDataAction"] == true,
+				"provider":     provider,
+				"resourceType": resourceType,
+			})
+		}
+	}
+	for _, i := range getAzArmAllPages(url, params, z) {
+		x := i.(map[string]interface{})
+		provider := utl.Str(x["name"])
+		addOps(provider, "", x["operations"])
+		resourceTypes, _ := x["resourceTypes"].([]interface{})
+		for _, j := range resourceTypes {
+			if rt, ok := j.(map[string]interface{}); ok {
+				addOps(provider, utl.Str(rt["name"]), rt["operations"])
+			}
+		}
+	}
+	if len(list) > 0 {
+		SaveCachedObjects("po", list, z) // Update the local cache, but never with an empty catalog
+	}
+	return list
+}
+
+// Gets all provider operations matching on 'filter'. Returns entire list if filter is empty ""
+func GetMatchingProviderOperations(filter string, force bool, z Bundle) (list []interface{}) {
+	return getMatchingScopeObjects("po", GetAzProviderOperations, filter, force, z)
+}
+
+// The provider operations catalog, indexed for matching action patterns
+type OperationCatalog struct {
+	ops       []catalogOp
+	providers map[string]string // Lowercased provider namespace to its proper case
+}
+
+type catalogOp struct {
+	name         string
+	lower        string // Lowercased name, for matching
+	isDataAction bool
+}
+
+// Returns the operation catalog built from given provider operation objects
+func newOperationCatalog(list []interface{}) *OperationCatalog {
+	c := &OperationCatalog{providers: map[string]string{}}
+	seen := map[string]bool{}
+	for _, i := range list {
+		x, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := utl.Str(x["name"])
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		c.ops = append(c.ops, catalogOp{name: name, lower: strings.ToLower(name), isDataAction: x["isDataAction"] == true})
+		provider, _, _ := strings.Cut(name, "/")
+		c.providers[strings.ToLower(provider)] = provider
+	}
+	sort.Slice(c.ops, func(i, j int) bool { return c.ops[i].lower < c.ops[j].lower })
+	return c
+}
+
+// Returns the operation catalog, from cache or Azure as per the usual cache rules
+func GetOperationCatalog(force bool, z Bundle) *OperationCatalog {
+	return newOperationCatalog(GetMatchingProviderOperations("", force, z))
+}
+
+// Returns the operation catalog from the local cache only, or nil if there is no cache
+func getCachedOperationCatalog(z Bundle) *OperationCatalog {
+	list := GetCachedObjects(CacheFile("po", z))
+	if len(list) == 0 {
+		return nil
+	}
+	return newOperationCatalog(list)
+}
+
+// Returns the number of operations in the catalog
+func (c *OperationCatalog) Len() int {
+	return len(c.ops)
+}
+
+// Returns the operations that given action pattern grants, sorted. Data actions are only
+// matched when dataAction is true, and control plane actions only when it is false, the
+// same way Azure applies them.
+func (c *OperationCatalog) Expand(pattern string, dataAction bool) (ops []string) {
+	parts := strings.Split(strings.ToLower(pattern), "*")
+	for _, op := range c.ops {
+		if op.isDataAction == dataAction && actionPartsMatch(parts, op.lower) {
+			ops = append(ops, op.name)
+		}
+	}
+	return ops
+}
+
+// Returns true if action matches some data actions and no control plane actions
+func (c *OperationCatalog) IsDataAction(action string) bool {
+	return len(c.Expand(action, true)) > 0 && len(c.Expand(action, false)) == 0
+}
+
+// Returns nil if given action pattern grants at least one operation of the right kind,
+// otherwise an error that says what is wrong with it
+func (c *OperationCatalog) CheckAction(pattern string, dataAction bool) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("empty action")
+	}
+	if len(c.Expand(pattern, dataAction)) > 0 {
+		return nil
+	}
+	if len(c.Expand(pattern, !dataAction)) > 0 {
+		if dataAction {
+			return fmt.Errorf("'%s' only matches control plane actions, it belongs in actions or notActions", pattern)
+		}
+		return fmt.Errorf("'%s' only matches data actions, it belongs in dataActions or notDataActions", pattern)
+	}
+	provider, _, _ := strings.Cut(pattern, "/")
+	if !strings.Contains(provider, "*") && c.providers[strings.ToLower(provider)] == "" {
+		if guess := c.closestProvider(provider); guess != "" {
+			return fmt.Errorf("'%s' has unknown provider '%s', did you mean '%s'?", pattern, provider, guess)
+		}
+		return fmt.Errorf("'%s' has unknown provider '%s'", pattern, provider)
+	}
+	return fmt.Errorf("'%s' does not match any known operation", pattern)
+}
+
+// Returns the known provider namespace closest to given misspelled one, or "" if none is close
+func (c *OperationCatalog) closestProvider(name string) (closest string) {
+	best := len(name)/4 + 1 // Allow about one edit for every four characters
+	for lower, provider := range c.providers {
+		if d := editDistance(strings.ToLower(name), lower); d < best || (d == best && closest != "" && provider < closest) {
+			best, closest = d, provider
+		}
+	}
+	return closest
+}
+
+// Returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// Result of checking one actions, notActions, dataActions or notDataActions pattern of a role
+type ActionCheck struct {
+	Field    string   // actions, notActions, dataActions or notDataActions
+	Pattern  string   // The pattern as written in the role
+	Err      error    // Why the pattern is invalid, nil if it is valid
+	Ops      []string // Operations the pattern matches
+	Excluded []string // For actions and dataActions, matched operations that the not* patterns take away
+}
+
+// Names of the permission fields that hold data actions
+var dataActionFields = map[string]bool{"dataActions": true, "notDataActions": true}
+
+// Checks every permission pattern of role definition x against the catalog
+func (c *OperationCatalog) CheckRoleActions(x map[string]interface{}) (checks []ActionCheck) {
+	xProp, _ := x["properties"].(map[string]interface{})
+	perms, _ := xProp["permissions"].([]interface{})
+	for _, i := range perms {
+		perm, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"actions", "notActions", "dataActions", "notDataActions"} {
+			patterns, _ := perm[field].([]interface{})
+			for _, p := range patterns {
+				pattern := utl.Str(p)
+				check := ActionCheck{Field: field, Pattern: pattern, Err: c.CheckAction(pattern, dataActionFields[field])}
+				check.Ops = c.Expand(pattern, dataActionFields[field])
+				if field == "actions" || field == "dataActions" {
+					notPatterns, _ := perm["not"+strings.ToUpper(field[:1])+field[1:]].([]interface{})
+					for _, op := range check.Ops {
+						for _, np := range notPatterns {
+							if ActionMatches(utl.Str(np), op) {
+								check.Excluded = append(check.Excluded, op)
+								break
+							}
+						}
+					}
+				}
+				checks = append(checks, check)
+			}
+		}
+	}
+	return checks
+}
+
+// Prints the action checks of one role, and returns the number of invalid patterns. With
+// expand, lists the operations that each wildcard pattern grants, marking the ones that
+// notActions or notDataActions take away.
+func PrintActionChecks(roleName string, checks []ActionCheck, expand bool) (errors int) {
+	fmt.Printf("%s:\n", utl.Blu(roleName))
+	for _, check := range checks {
+		if check.Err != nil {
+			errors++
+			fmt.Printf("  %-15s %s\n", check.Field+":", utl.Red(check.Err.Error()))
+			continue
+		}
+		count := fmt.Sprintf("%d operations", len(check.Ops))
+		if len(check.Excluded) > 0 {
+			count += fmt.Sprintf(", %d excluded", len(check.Excluded))
+		}
+		fmt.Printf("  %-15s %s  %s\n", check.Field+":", utl.Gre(utl.StrSingleQuote(check.Pattern)), count)
+		if !expand || !strings.Contains(check.Pattern, "*") {
+			continue
+		}
+		for _, op := range check.Ops {
+			if utl.ItemInList(op, check.Excluded) {
+				fmt.Printf("      %s  %s\n", utl.Yel(op), utl.Yel("# excluded"))
+			} else {
+				fmt.Printf("      %s\n", op)
+			}
+		}
+	}
+	return errors
+}
+
+// Checks the actions of the role definitions in given specfile against the provider operations
+// catalog, and prints the results. Returns the number of invalid patterns.
+func CheckSpecfileActions(filePath string, expand bool, z Bundle) (errors int) {
+	catalog := GetOperationCatalog(false, z)
+	if catalog.Len() == 0 {
+		utl.Die("Provider operations catalog is not available\n")
+	}
+	objects, _ := GetObjectsFromSpecfile(filePath, z)
+	found := false
+	for _, o := range objects {
+		if o.MazType != "d" {
+			continue
+		}
+		found = true
+		xProp := o.Object["properties"].(map[string]interface{})
+		errors += PrintActionChecks(utl.Str(xProp["roleName"]), catalog.CheckRoleActions(o.Object), expand)
+	}
+	if !found {
+		utl.Die("File has no role definitions\n")
+	}
+	return errors
+}
+
+// Checks the actions of existing custom role definitions matching on 'filter' against the
+// provider operations catalog, and prints the results. Returns the number of invalid patterns.
+func CheckCustomRoleActions(filter string, expand bool, z Bundle) (errors int) {
+	catalog := GetOperationCatalog(false, z)
+	if catalog.Len() == 0 {
+		utl.Die("Provider operations catalog is not available\n")
+	}
+	for _, i := range GetMatchingRoleDefinitions(filter, false, z) {
+		x := i.(map[string]interface{})
+		xProp, ok := x["properties"].(map[string]interface{})
+		if !ok || utl.Str(xProp["type"]) != "CustomRole" {
+			continue
+		}
+		errors += PrintActionChecks(utl.Str(xProp["roleName"]), catalog.CheckRoleActions(x), expand)
+	}
+	return errors
+}
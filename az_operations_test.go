@@ -0,0 +1,188 @@
+package maz
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns a small operations catalog for tests, with the operations given as name or
+// "data:"+name for data actions
+func testCatalog(ops ...string) *OperationCatalog {
+	if len(ops) == 0 {
+		ops = []string{
+			"Microsoft.Compute/virtualMachines/read",
+			"Microsoft.Compute/virtualMachines/write",
+			"Microsoft.Compute/virtualMachines/delete",
+			"Microsoft.Compute/virtualMachines/start/action",
+			"Microsoft.Compute/disks/read",
+			"Microsoft.Compute/disks/write",
+			"Microsoft.Storage/storageAccounts/read",
+			"Microsoft.Storage/storageAccounts/write",
+			"Microsoft.Storage/storageAccounts/listKeys/action",
+			"data:Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read",
+			"data:Microsoft.Storage/storageAccounts/blobServices/containers/blobs/write",
+			"data:Microsoft.Storage/storageAccounts/blobServices/containers/blobs/delete",
+		}
+	}
+	var list []interface{}
+	for _, op := range ops {
+		name, data := strings.CutPrefix(op, "data:")
+		list = append(list, map[string]interface{}{"name": name, "isDataAction": data})
+	}
+	return newOperationCatalog(list)
+}
+
+func TestNewOperationCatalog(t *testing.T) {
+	c := newOperationCatalog([]interface{}{
+		map[string]interface{}{"name": "Microsoft.Compute/disks/read"},
+		map[string]interface{}{"name": "microsoft.compute/DISKS/read"}, // Duplicate
+		map[string]interface{}{"name": ""},
+		"not an object",
+		map[string]interface{}{"name": "Microsoft.Storage/storageAccounts/read", "isDataAction": false},
+	})
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestOperationCatalogExpand(t *testing.T) {
+	c := testCatalog()
+	tests := []struct {
+		pattern    string
+		dataAction bool
+		want       []string
+	}{
+		{"Microsoft.Compute/disks/*", false, []string{"Microsoft.Compute/disks/read", "Microsoft.Compute/disks/write"}},
+		{"microsoft.compute/*/read", false, []string{"Microsoft.Compute/disks/read", "Microsoft.Compute/virtualMachines/read"}},
+		{"*/action", false, []string{
+			"Microsoft.Compute/virtualMachines/start/action",
+			"Microsoft.Storage/storageAccounts/listKeys/action",
+		}},
+		{"Microsoft.Storage/*/read", false, []string{"Microsoft.Storage/storageAccounts/read"}},
+		{"Microsoft.Storage/*/read", true, []string{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read"}},
+		{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/*", false, nil},
+		{"Microsoft.Compute/virtualMachines/read", false, []string{"Microsoft.Compute/virtualMachines/read"}},
+		{"Microsoft.Compute/virtualMachines/read", true, nil},
+		{"Microsoft.Network/*", false, nil},
+	}
+	for _, tt := range tests {
+		if got := c.Expand(tt.pattern, tt.dataAction); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expand(%q, %v) = %v, want %v", tt.pattern, tt.dataAction, got, tt.want)
+		}
+	}
+	if n := len(c.Expand("*", false)) + len(c.Expand("*", true)); n != c.Len() {
+		t.Errorf("Expand(\"*\") of both kinds = %d operations, want %d", n, c.Len())
+	}
+}
+
+func TestOperationCatalogIsDataAction(t *testing.T) {
+	c := testCatalog()
+	tests := []struct {
+		action string
+		want   bool
+	}{
+		{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read", true},
+		{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/*", true},
+		{"Microsoft.Storage/storageAccounts/read", false},
+		{"Microsoft.Storage/*", false}, // Matches both kinds
+		{"Microsoft.Unknown/things/read", false},
+	}
+	for _, tt := range tests {
+		if got := c.IsDataAction(tt.action); got != tt.want {
+			t.Errorf("IsDataAction(%q) = %v, want %v", tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestOperationCatalogCheckAction(t *testing.T) {
+	c := testCatalog()
+	tests := []struct {
+		pattern    string
+		dataAction bool
+		wantErr    string // Substring of the expected error, "" for a valid pattern
+	}{
+		{"Microsoft.Compute/*", false, ""},
+		{"*/read", false, ""},
+		{"*/read", true, ""},
+		{"microsoft.compute/virtualmachines/READ", false, ""},
+		{"", false, "empty action"},
+		{"  ", true, "empty action"},
+		{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read", false, "belongs in dataActions"},
+		{"Microsoft.Compute/disks/read", true, "belongs in actions"},
+		{"Microsoft.Compte/disks/read", false, "did you mean 'Microsoft.Compute'"},
+		{"microsoft.storag/storageAccounts/read", false, "did you mean 'Microsoft.Storage'"},
+		{"Contoso.Widgets/read", false, "unknown provider 'Contoso.Widgets'"},
+		{"Microsoft.Compute/disks/delete", false, "does not match any known operation"},
+		{"Microsoft.*/gadgets/read", false, "does not match any known operation"},
+	}
+	for _, tt := range tests {
+		err := c.CheckAction(tt.pattern, tt.dataAction)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("CheckAction(%q, %v) error: %v", tt.pattern, tt.dataAction, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("CheckAction(%q, %v) = nil, want error containing %q", tt.pattern, tt.dataAction, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("CheckAction(%q, %v) = %q, want error containing %q", tt.pattern, tt.dataAction, err, tt.wantErr)
+		}
+	}
+	if err := c.CheckAction("Contoso.Widgets/read", false); strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("CheckAction() suggested a provider for a name that is not close: %v", err)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"microsoft.compute", "microsoft.compte", 1},
+		{"same", "same", 0},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRoleActions(t *testing.T) {
+	c := testCatalog()
+	role := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"permissions": []interface{}{
+				map[string]interface{}{
+					"actions":     []interface{}{"Microsoft.Compute/virtualMachines/*", "Microsoft.Nope/x/read"},
+					"notActions":  []interface{}{"Microsoft.Compute/virtualMachines/delete"},
+					"dataActions": []interface{}{"Microsoft.Compute/disks/read"},
+				},
+			},
+		},
+	}
+	checks := c.CheckRoleActions(role)
+	if len(checks) != 4 {
+		t.Fatalf("CheckRoleActions() returned %d checks, want 4", len(checks))
+	}
+	want := []struct {
+		field    string
+		ok       bool
+		ops      int
+		excluded []string
+	}{
+		{"actions", true, 4, []string{"Microsoft.Compute/virtualMachines/delete"}},
+		{"actions", false, 0, nil},
+		{"notActions", true, 1, nil},
+		{"dataActions", false, 0, nil},
+	}
+	for n, w := range want {
+		got := checks[n]
+		if got.Field != w.field || (got.Err == nil) != w.ok || len(got.Ops) != w.ops || !reflect.DeepEqual(got.Excluded, w.excluded) {
+			t.Errorf("check %d = %+v, want field %s, valid %v, %d ops, excluded %v", n, got, w.field, w.ok, w.ops, w.excluded)
+		}
+	}
+}
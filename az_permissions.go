@@ -0,0 +1,431 @@
+package maz
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/queone/utl"
+)
+
+// One role assignment that grants an action, as part of the proof for a permission check
+type PermissionGrant struct {
+	AssignmentId  string // Fully qualified role assignment ID
+	RoleId        string // Role definition UUID
+	RoleName      string
+	Scope         string // Scope of the assignment, the target scope or one of its ancestors
+	PrincipalId   string // Principal the assignment is for, either the one checked or a group
+	ViaGroup      bool   // True if inherited through membership of PrincipalId group
+	MatchedAction string // The actions or dataActions pattern that matched
+	DataAction    bool   // True if matched via dataActions instead of actions
+	Condition     string // ABAC condition the grant depends on, if any
+}
+
+// Result of an effective permission check, with the grants that prove it
+type PermissionResult struct {
+	PrincipalId string
+	Action      string
+	Scope       string
+	Allowed     bool
+	Grants      []PermissionGrant // Assignments that grant the action
+	Excluded    []PermissionGrant // Assignments whose roles match the action, but exclude it via notActions
+}
+
+// Returns true if action matches given Azure RBAC action pattern, where '*' stands for any
+// sequence of characters. Matching is case-insensitive, as it is in Azure.
+func ActionMatches(pattern, action string) bool {
+	return actionMatchesLower(strings.ToLower(pattern), strings.ToLower(action))
+}
+
+// Same as ActionMatches, for a pattern and action that are already lowercased
+func actionMatchesLower(p, a string) bool {
+	return actionPartsMatch(strings.Split(p, "*"), a)
+}
+
+// Returns true if lowercased action matches the lowercased pattern split on its '*' characters.
+// Lets callers that match one pattern against many actions split it only once.
+func actionPartsMatch(parts []string, a string) bool {
+	if len(parts) == 1 {
+		return parts[0] == a
+	}
+	if !strings.HasPrefix(a, parts[0]) {
+		return false
+	}
+	a = a[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(a, part)
+		if i < 0 {
+			return false
+		}
+		a = a[i+len(part):]
+	}
+	return strings.HasSuffix(a, parts[len(parts)-1])
+}
+
+// Returns true if the two action patterns have any actions in common, that is, if some action
+// matches both. With a concrete action for q, this is the same as ActionMatches(p, q).
+func actionsOverlap(p, q string) bool {
+	p, q = strings.ToLower(p), strings.ToLower(q)
+	memo := map[[2]int]bool{}
+	// Returns true if p[i:] and q[j:] can match the same string
+	var overlap func(i, j int) bool
+	overlap = func(i, j int) bool {
+		if result, ok := memo[[2]int{i, j}]; ok {
+			return result
+		}
+		var result bool
+		switch {
+		case i == len(p) && j == len(q):
+			result = true
+		case i < len(p) && p[i] == '*':
+			// The '*' matches nothing, or also swallows the next character or '*' of q
+			result = overlap(i+1, j) || (j < len(q) && overlap(i, j+1))
+		case j < len(q) && q[j] == '*':
+			result = overlap(i, j+1) || (i < len(p) && overlap(i+1, j))
+		case i < len(p) && j < len(q) && p[i] == q[j]:
+			result = overlap(i+1, j+1)
+		}
+		memo[[2]int{i, j}] = result
+		return result
+	}
+	return overlap(0, 0)
+}
+
+// Returns the first pattern in list that grants some of the actions matched by action, or ""
+// if none do
+func grantingActionPattern(patterns []string, action string) string {
+	for _, p := range patterns {
+		if actionsOverlap(p, action) {
+			return p
+		}
+	}
+	return ""
+}
+
+// Returns true if any pattern in list excludes all the actions matched by action
+func actionExcluded(patterns []string, action string) bool {
+	for _, p := range patterns {
+		if ActionMatches(p, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluates whether role definition permissions allow action. Each permission block grants
+// what its actions list matches, minus what its notActions list matches, or for a data action,
+// what its dataActions list matches minus its notDataActions list. As in Azure, actions never
+// grant data actions, even with a "*" wildcard. Returns the matching pattern, and whether the
+// action was matched but then excluded. The action itself may also be a wildcard pattern, in
+// which case roles granting any part of it count as allowing it.
+func RoleAllowsAction(role RoleDefinition, action string, dataAction bool) (pattern string, excluded bool) {
+	for _, perm := range role.Properties.Permissions {
+		grants, nots := perm.Actions, perm.NotActions
+		if dataAction {
+			grants, nots = perm.DataActions, perm.NotDataActions
+		}
+		if p := grantingActionPattern(grants, action); p != "" {
+			if !actionExcluded(nots, action) {
+				return p, false
+			}
+			excluded = true
+		}
+	}
+	return "", excluded
+}
+
+// Returns true if action is a data action according to the provider operations catalog, that
+// is, if it matches some data actions and no control plane actions. Actions the catalog does
+// not know are taken to be control plane actions. Dies if the catalog is not available, since
+// checking a data action against actions would report access that Azure does not grant.
+func IsDataAction(action string, z Bundle) bool {
+	catalog := GetOperationCatalog(false, z)
+	if catalog.Len() == 0 {
+		utl.Die("Provider operations catalog is not available, cannot tell if '%s' is a data action\n", action)
+	}
+	return catalog.IsDataAction(action)
+}
+
+// Returns scope lowercased and without any trailing slash, except for the root "/" itself
+func normalizeScope(scope string) string {
+	scope = strings.ToLower(scope)
+	if scope != "/" {
+		scope = strings.TrimSuffix(scope, "/")
+	}
+	return scope
+}
+
+// Returns given scope and all the scopes it inherits assignments from, lowercased: every
+// parent path, the management groups above it according to mgParents (as returned by
+// GetAzMgParentMap), and the tenant root "/".
+func ScopeAncestors(scope string, mgParents map[string]string) (scopes []string) {
+	scope = normalizeScope(scope)
+	seen := map[string]bool{}
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	segments := strings.Split(strings.TrimPrefix(scope, "/"), "/")
+	for n := len(segments); n > 0; n-- {
+		add("/" + strings.Join(segments[:n], "/"))
+	}
+	// Walk up the management group hierarchy from the subscription or management group
+	var start string
+	if len(segments) >= 2 && segments[0] == "subscriptions" {
+		start = "/subscriptions/" + segments[1]
+	} else if len(segments) >= 4 && segments[2] == "managementgroups" {
+		start = "/" + strings.Join(segments[:4], "/")
+	}
+	for parent := mgParents[start]; parent != "" && !seen[parent]; parent = mgParents[parent] {
+		add(parent)
+	}
+	add("/")
+	return scopes
+}
+
+// Evaluates whether action is allowed at scope, given the assignments and role definitions.
+// The dataAction flag says whether action is a data action, see IsDataAction.
+// The principals map holds every principal ID to consider, mapped to true for groups the
+// checked principal inherits from, and false for the principal itself. This does not make
+// any API calls, so it can be run repeatedly over cached data.
+func EvaluatePermission(principalId, action string, dataAction bool, scope string, principals map[string]bool,
+	assignments []RoleAssignment, roles map[string]RoleDefinition,
+	mgParents map[string]string) (result PermissionResult) {
+	result = PermissionResult{PrincipalId: principalId, Action: action, Scope: scope}
+	inScope := map[string]bool{}
+	for _, s := range ScopeAncestors(scope, mgParents) {
+		inScope[s] = true
+	}
+	for _, a := range assignments {
+		viaGroup, ok := principals[strings.ToLower(a.Properties.PrincipalId)]
+		if !ok || !inScope[normalizeScope(a.Properties.Scope)] {
+			continue
+		}
+		roleId := strings.ToLower(utl.LastElem(a.Properties.RoleDefinitionId, "/"))
+		role, ok := roles[roleId]
+		if !ok {
+			continue
+		}
+		pattern, excluded := RoleAllowsAction(role, action, dataAction)
+		grant := PermissionGrant{
+			AssignmentId:  a.Id,
+			RoleId:        roleId,
+			RoleName:      role.Properties.RoleName,
+			Scope:         a.Properties.Scope,
+			PrincipalId:   a.Properties.PrincipalId,
+			ViaGroup:      viaGroup,
+			MatchedAction: pattern,
+			DataAction:    dataAction,
+			Condition:     a.Properties.Condition,
+		}
+		if pattern != "" {
+			result.Grants = append(result.Grants, grant)
+		} else if excluded {
+			result.Excluded = append(result.Excluded, grant)
+		}
+	}
+	result.Allowed = len(result.Grants) > 0
+	return result
+}
+
+// Returns the cached role definitions, keyed by lowercased UUID
+func getRoleDefinitionMap(z Bundle) map[string]RoleDefinition {
+	roleList, err := ListRoleDefinitions("", false, z)
+	if err != nil {
+		utl.Die("Error reading role definitions: %s\n", err.Error())
+	}
+	roles := make(map[string]RoleDefinition)
+	for _, r := range roleList {
+		roles[strings.ToLower(r.Name)] = r
+	}
+	return roles
+}
+
+// Checks whether principal is allowed to perform action at scope, based on its direct and
+// group-inherited role assignments in the local cache. Deny assignments are not considered.
+func CheckPermission(principalId, action, scope string, z Bundle) PermissionResult {
+	principals := map[string]bool{strings.ToLower(principalId): false}
+	for _, groupId := range GetAzPrincipalGroupIds(principalId, z) {
+		principals[strings.ToLower(groupId)] = true
+	}
+	assignments, err := ListRoleAssignments("", false, z)
+	if err != nil {
+		utl.Die("Error reading role assignments: %s\n", err.Error())
+	}
+	return EvaluatePermission(principalId, action, IsDataAction(action, z), scope, principals, assignments,
+		getRoleDefinitionMap(z), GetAzMgParentMap(z))
+}
+
+// Prints the result of an effective permission check, with the proof chain of grants
+func PrintPermissionCheck(principalId, action, scope string, z Bundle) {
+	if !utl.ValidUuid(principalId) {
+		utl.Die("Principal '%s' is not a valid UUID.\n", principalId)
+	}
+	result := CheckPermission(principalId, action, scope, z)
+	groupNameMap := GetIdMapGroups(z)
+	verdict := utl.Red("DENIED")
+	if result.Allowed {
+		verdict = utl.Gre("ALLOWED")
+	}
+	fmt.Printf("%s: %s\n", utl.Blu("principal"), utl.Gre(principalId))
+	fmt.Printf("%s: %s\n", utl.Blu("action"), utl.Gre(action))
+	fmt.Printf("%s: %s\n", utl.Blu("scope"), utl.Gre(scope))
+	fmt.Printf("%s: %s\n", utl.Blu("result"), verdict)
+	printGrants := func(label string, grants []PermissionGrant) {
+		if len(grants) < 1 {
+			return
+		}
+		fmt.Printf("%s:\n", utl.Blu(label))
+		for _, g := range grants {
+			via := "direct assignment"
+			if g.ViaGroup {
+				via = "via group \"" + groupNameMap[g.PrincipalId] + "\" " + g.PrincipalId
+			}
+			fmt.Printf("  - %s: %s\n", utl.Blu("assignment"), utl.Gre(g.AssignmentId))
+			fmt.Printf("    %s: %s  # %s\n", utl.Blu("role"), utl.Gre(g.RoleId), g.RoleName)
+			fmt.Printf("    %s: %s\n", utl.Blu("scope"), utl.Gre(g.Scope))
+			fmt.Printf("    %s: %s\n", utl.Blu("principal"), utl.Gre(via))
+			if g.MatchedAction != "" {
+				kind := "actions"
+				if g.DataAction {
+					kind = "dataActions"
+				}
+				fmt.Printf("    %s: %s\n", utl.Blu(kind), utl.Gre(utl.StrSingleQuote(g.MatchedAction)))
+			}
+			if g.Condition != "" {
+				fmt.Printf("    %s: %s\n", utl.Blu("condition"), utl.Yel(g.Condition))
+			}
+		}
+	}
+	printGrants("grants", result.Grants)
+	printGrants("excludedBy_notActions", result.Excluded)
+}
+
+// One principal with effective access found by a reverse permission lookup
+type PrincipalAccess struct {
+	PrincipalId   string
+	PrincipalType string // User, Group, ServicePrincipal, etc
+	PrincipalName string
+	Grant         PermissionGrant // The assignment granting it, to the principal or one of its groups
+	BelowScope    bool            // True if only granted on a scope below the requested one
+}
+
+// Returns every principal with effective access to action at scope, or below it, along with
+// the assignment granting it. The action may be a wildcard pattern. Principals are either
+// assigned directly, or are members of an assigned group, in which case the group itself is
+// also listed. Deny assignments are not considered.
+func WhoCan(action, scope string, z Bundle) (list []PrincipalAccess) {
+	assignments, err := ListRoleAssignments("", false, z)
+	if err != nil {
+		utl.Die("Error reading role assignments: %s\n", err.Error())
+	}
+	roles := getRoleDefinitionMap(z)
+	mgParents := GetAzMgParentMap(z)
+	dataAction := IsDataAction(action, z)
+	inScope := map[string]bool{}
+	for _, s := range ScopeAncestors(scope, mgParents) {
+		inScope[s] = true
+	}
+	target := normalizeScope(scope)
+	names := map[string]map[string]string{
+		"User":             GetIdMapUsers(z),
+		"Group":            GetIdMapGroups(z),
+		"ServicePrincipal": GetIdMapSps(z),
+	}
+	groupMembers := map[string][]interface{}{} // Expand each group only once
+	for _, a := range assignments {
+		assignScope := normalizeScope(a.Properties.Scope)
+		belowScope := false
+		if !inScope[assignScope] {
+			belowScope = target != assignScope && utl.ItemInList(target, ScopeAncestors(assignScope, mgParents))
+			if !belowScope {
+				continue
+			}
+		}
+		roleId := strings.ToLower(utl.LastElem(a.Properties.RoleDefinitionId, "/"))
+		role, ok := roles[roleId]
+		if !ok {
+			continue
+		}
+		pattern, _ := RoleAllowsAction(role, action, dataAction)
+		if pattern == "" {
+			continue
+		}
+		grant := PermissionGrant{
+			AssignmentId:  a.Id,
+			RoleId:        roleId,
+			RoleName:      role.Properties.RoleName,
+			Scope:         a.Properties.Scope,
+			PrincipalId:   a.Properties.PrincipalId,
+			MatchedAction: pattern,
+			DataAction:    dataAction,
+			Condition:     a.Properties.Condition,
+		}
+		pType := a.Properties.PrincipalType
+		list = append(list, PrincipalAccess{
+			PrincipalId:   a.Properties.PrincipalId,
+			PrincipalType: pType,
+			PrincipalName: names[pType][a.Properties.PrincipalId],
+			Grant:         grant,
+			BelowScope:    belowScope,
+		})
+		if pType != "Group" {
+			continue
+		}
+		members, ok := groupMembers[grant.PrincipalId]
+		if !ok {
+			members = GetAzGroupTransitiveMembers(grant.PrincipalId, z)
+			groupMembers[grant.PrincipalId] = members
+		}
+		grant.ViaGroup = true
+		for _, i := range members {
+			m := i.(map[string]interface{})
+			mType := utl.LastElem(utl.Str(m["@odata.type"]), ".") // e.g. "#microsoft.graph.user"
+			if mType != "" {
+				mType = strings.ToUpper(mType[:1]) + mType[1:]
+			}
+			mName := utl.Str(m["userPrincipalName"])
+			if mName == "" {
+				mName = utl.Str(m["displayName"])
+			}
+			list = append(list, PrincipalAccess{
+				PrincipalId:   utl.Str(m["id"]),
+				PrincipalType: mType,
+				PrincipalName: mName,
+				Grant:         grant,
+				BelowScope:    belowScope,
+			})
+		}
+	}
+	return list
+}
+
+// Prints every principal with effective access to action at scope, and how they get it. The
+// scope can also be given as a subscription display name.
+func PrintWhoCan(action, scope string, z Bundle) {
+	if !strings.HasPrefix(scope, "/") {
+		for id, name := range GetIdMapSubs(z) {
+			if strings.EqualFold(name, scope) {
+				scope = "/subscriptions/" + id
+				break
+			}
+		}
+		if !strings.HasPrefix(scope, "/") {
+			utl.Die("Scope '%s' is neither a scope path nor a subscription name.\n", scope)
+		}
+	}
+	for _, p := range WhoCan(action, scope, z) {
+		g := p.Grant
+		path := "direct"
+		if g.ViaGroup {
+			path = "group " + g.PrincipalId
+		}
+		where := g.Scope
+		if p.BelowScope {
+			where += " (below)"
+		}
+		fmt.Printf("%-40s  %-16s  %s  %-32s  %-12s  %s\n", utl.Gre(p.PrincipalName), utl.Gre(p.PrincipalType),
+			utl.Gre(p.PrincipalId), utl.Gre(g.RoleName), utl.Gre(path), utl.Gre(where))
+	}
+}
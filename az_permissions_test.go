@@ -0,0 +1,157 @@
+package maz
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestActionMatches(t *testing.T) {
+	tests := []struct {
+		pattern, action string
+		want            bool
+	}{
+		{"*", "Microsoft.Compute/virtualMachines/read", true},
+		{"*/read", "Microsoft.Compute/virtualMachines/read", true},
+		{"*/read", "Microsoft.Compute/virtualMachines/write", false},
+		{"Microsoft.Compute/*", "Microsoft.Compute/virtualMachines/read", true},
+		{"Microsoft.Compute/*", "Microsoft.Storage/storageAccounts/read", false},
+		{"microsoft.compute/virtualmachines/READ", "Microsoft.Compute/virtualMachines/read", true},
+		{"Microsoft.Compute/*/read", "Microsoft.Compute/virtualMachines/extensions/read", true},
+		{"Microsoft.Compute/*/read", "Microsoft.Compute/virtualMachines/write", false},
+		{"Microsoft.*/virtualMachines/*", "Microsoft.Compute/virtualMachines/start/action", true},
+		{"Microsoft.Compute/virtualMachines/read", "Microsoft.Compute/virtualMachines/read", true},
+		{"Microsoft.Compute/virtualMachines/read", "Microsoft.Compute/virtualMachines/readx", false},
+		{"Microsoft.Compute/virtualMachines", "Microsoft.Compute/virtualMachines/read", false},
+		{"a*a", "a", false},
+		{"a*a", "aa", true},
+	}
+	for _, tt := range tests {
+		if got := ActionMatches(tt.pattern, tt.action); got != tt.want {
+			t.Errorf("ActionMatches(%q, %q) = %v, want %v", tt.pattern, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestActionsOverlap(t *testing.T) {
+	tests := []struct {
+		p, q string
+		want bool
+	}{
+		{"*/read", "Microsoft.KeyVault/*", true},
+		{"Microsoft.KeyVault/*", "*/read", true},
+		{"*/read", "*/write", false},
+		{"*/read", "*", true},
+		{"Microsoft.Compute/*/read", "*/virtualMachines/*", true},
+		{"Microsoft.Compute/*", "Microsoft.Storage/*", false},
+		{"Microsoft.*/vaults/*", "*.KeyVault/vaults/secrets/*", true},
+		{"Microsoft.*/vaults/read", "*.KeyVault/vaults/write", false},
+		{"a*b", "*c", false},
+		{"a*b", "*b", true},
+		{"a*", "*a", true},
+		{"ab", "a*b", true},
+		{"ab", "a*c", false},
+		{"MICROSOFT.COMPUTE/*", "microsoft.compute/disks/read", true},
+		{"Microsoft.Compute/disks/read", "Microsoft.Compute/disks/read", true},
+		{"Microsoft.Compute/disks/read", "Microsoft.Compute/disks/write", false},
+	}
+	for _, tt := range tests {
+		if got := actionsOverlap(tt.p, tt.q); got != tt.want {
+			t.Errorf("actionsOverlap(%q, %q) = %v, want %v", tt.p, tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestRoleAllowsAction(t *testing.T) {
+	owner := RoleDefinition{Properties: RoleDefinitionProperties{Permissions: []RolePermission{
+		{Actions: []string{"*"}},
+	}}}
+	contributor := RoleDefinition{Properties: RoleDefinitionProperties{Permissions: []RolePermission{
+		{Actions: []string{"*"}, NotActions: []string{"Microsoft.Authorization/*/Write", "Microsoft.Authorization/*/Delete"}},
+	}}}
+	blobReader := RoleDefinition{Properties: RoleDefinitionProperties{Permissions: []RolePermission{
+		{
+			Actions:     []string{"Microsoft.Storage/storageAccounts/blobServices/containers/read"},
+			DataActions: []string{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/*"},
+			NotDataActions: []string{
+				"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/write",
+			},
+		},
+	}}}
+	vaultReader := RoleDefinition{Properties: RoleDefinitionProperties{Permissions: []RolePermission{
+		{Actions: []string{"*/read"}},
+	}}}
+	blobRead := "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read"
+	blobWrite := "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/write"
+	tests := []struct {
+		name         string
+		role         RoleDefinition
+		action       string
+		dataAction   bool
+		wantPattern  string
+		wantExcluded bool
+	}{
+		{"owner action", owner, "Microsoft.Compute/virtualMachines/read", false, "*", false},
+		{"owner data action", owner, blobRead, true, "", false},
+		{"contributor action", contributor, "Microsoft.Compute/virtualMachines/write", false, "*", false},
+		{"contributor excluded", contributor, "Microsoft.Authorization/roleAssignments/write", false, "", true},
+		{"contributor wildcard action", contributor, "Microsoft.Authorization/*", false, "*", false},
+		{"reader data action", blobReader, blobRead, true, "Microsoft.Storage/storageAccounts/blobServices/containers/blobs/*", false},
+		{"reader excluded data action", blobReader, blobWrite, true, "", true},
+		{"reader data action as action", blobReader, blobRead, false, "", false},
+		{"reader action", blobReader, "Microsoft.Storage/storageAccounts/blobServices/containers/read", false,
+			"Microsoft.Storage/storageAccounts/blobServices/containers/read", false},
+		{"wildcard role and wildcard action", vaultReader, "Microsoft.KeyVault/*", false, "*/read", false},
+		{"wildcard role and disjoint wildcard action", vaultReader, "Microsoft.KeyVault/*/write", false, "", false},
+		{"excluded wildcard action", contributor, "Microsoft.Authorization/*/Delete", false, "", true},
+		{"reader action as data action", blobReader, "Microsoft.Storage/storageAccounts/blobServices/containers/read", true, "", false},
+	}
+	for _, tt := range tests {
+		pattern, excluded := RoleAllowsAction(tt.role, tt.action, tt.dataAction)
+		if pattern != tt.wantPattern || excluded != tt.wantExcluded {
+			t.Errorf("%s: RoleAllowsAction() = %q, %v, want %q, %v", tt.name, pattern, excluded, tt.wantPattern, tt.wantExcluded)
+		}
+	}
+}
+
+func TestScopeAncestors(t *testing.T) {
+	mgParents := map[string]string{
+		"/subscriptions/sub1": "/providers/microsoft.management/managementgroups/mg1",
+		"/providers/microsoft.management/managementgroups/mg1": "/providers/microsoft.management/managementgroups/root",
+	}
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{"/", []string{"/"}},
+		{"/subscriptions/SUB1/", []string{
+			"/subscriptions/sub1",
+			"/subscriptions",
+			"/providers/microsoft.management/managementgroups/mg1",
+			"/providers/microsoft.management/managementgroups/root",
+			"/",
+		}},
+		{"/subscriptions/sub1/resourceGroups/rg1", []string{
+			"/subscriptions/sub1/resourcegroups/rg1",
+			"/subscriptions/sub1/resourcegroups",
+			"/subscriptions/sub1",
+			"/subscriptions",
+			"/providers/microsoft.management/managementgroups/mg1",
+			"/providers/microsoft.management/managementgroups/root",
+			"/",
+		}},
+		{"/providers/Microsoft.Management/managementGroups/mg1", []string{
+			"/providers/microsoft.management/managementgroups/mg1",
+			"/providers/microsoft.management/managementgroups",
+			"/providers/microsoft.management",
+			"/providers",
+			"/providers/microsoft.management/managementgroups/root",
+			"/",
+		}},
+		{"/subscriptions/sub2", []string{"/subscriptions/sub2", "/subscriptions", "/"}},
+	}
+	for _, tt := range tests {
+		if got := ScopeAncestors(tt.scope, mgParents); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ScopeAncestors(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
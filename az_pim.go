@@ -0,0 +1,233 @@
+package maz
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/queone/utl"
+)
+
+// Azure RBAC Privileged Identity Management (PIM) support. Eligible assignments are cached as
+// maz type "ae" (roleEligibilityScheduleInstances), and active assignments, including the
+// time-bound ones, as maz type "as" (roleAssignmentScheduleInstances).
+// References:
+//
+//	https://learn.microsoft.com/en-us/rest/api/authorization/privileged-role-eligibility-rest-sample
+//	https://learn.microsoft.com/en-us/rest/api/authorization/role-eligibility-schedule-requests
+
+const ConstPimApiVersion = "2020-10-01" // roleEligibilitySchedule* and roleAssignmentSchedule*
+
+var pimResources = map[string]string{
+	"ae": "roleEligibilityScheduleInstances",
+	"as": "roleAssignmentScheduleInstances",
+}
+
+// Prints PIM eligible or scheduled role assignment instance in YAML-like format
+func PrintPimAssignment(x map[string]interface{}, z Bundle) {
+	if x == nil {
+		return
+	}
+	if x["name"] != nil {
+		fmt.Printf("%s: %s\n", utl.Blu("id"), utl.Gre(utl.Str(x["name"])))
+	}
+	xProp, ok := x["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	fmt.Println(utl.Blu("properties") + ":")
+
+	// Expanded properties carry the display names, so there's no need for id:name maps
+	expanded, _ := xProp["expandedProperties"].(map[string]interface{})
+	expandedName := func(key string) string {
+		if m, ok := expanded[key].(map[string]interface{}); ok {
+			return utl.Str(m["displayName"])
+		}
+		return ""
+	}
+	roleId := utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/")
+	fmt.Printf("  %s: %s  # Role \"%s\"\n", utl.Blu("roleDefinitionId"), utl.Gre(roleId), expandedName("roleDefinition"))
+	fmt.Printf("  %s: %s  # %s \"%s\"\n", utl.Blu("principalId"), utl.Gre(utl.Str(xProp["principalId"])),
+		utl.Str(xProp["principalType"]), expandedName("principal"))
+	fmt.Printf("  %s: %s  # %s\n", utl.Blu("scope"), utl.Gre(utl.Str(xProp["scope"])), expandedName("scope"))
+	for _, k := range []string{"memberType", "status", "assignmentType", "startDateTime", "endDateTime"} {
+		if v := utl.Str(xProp[k]); v != "" {
+			fmt.Printf("  %s: %s\n", utl.Blu(k), utl.Gre(v))
+		}
+	}
+	if utl.Str(xProp["endDateTime"]) == "" {
+		fmt.Printf("  %s: %s\n", utl.Blu("endDateTime"), utl.Yel("permanent"))
+	}
+}
+
+// Returns count of PIM instances of maz type t in local cache file
+func PimCountLocal(t string, z Bundle) int64 {
+	cachedList := GetCachedObjects(CacheFile(t, z))
+	return int64(len(cachedList))
+}
+
+// Gets all PIM instances of maz type t ("ae" or "as") across all RBAC scopes, and saves them
+// to local cache file. Option to be verbose (true) or quiet (false), since it can take a while.
+func GetAzPimInstances(t string, z Bundle, verbose bool) (list []interface{}) {
+	list = nil
+	resource := pimResources[t]
+	uniqueIds := NewSyncSet()
+	var mu sync.Mutex
+	k := 1
+	scopes := append(GetAzRbacScopes(z), GetAzDeepRbacScopes(z)...)
+	params := map[string]string{"api-version": ConstPimApiVersion}
+	RunParallel(len(scopes), ConcurrencyLimit(z), func(n int) bool {
+		url := ConstAzUrl + scopes[n] + "/providers/Microsoft.Authorization/" + resource
+		var objects []interface{} = nil
+		for _, i := range getAzArmAllPages(url, params, z) {
+			x := i.(map[string]interface{})
+			if uniqueIds.Add(utl.Str(x["name"])) { // Skip instances already seen under a parent scope
+				objects = append(objects, x)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		list = append(list, objects...)
+		if verbose && len(objects) > 0 {
+			fmt.Printf("API call %4d: %5d objects under %s\n", k, len(objects), scopes[n])
+		}
+		k++
+		return false
+	})
+	SaveCachedObjects(t, list, z) // Update the local cache
+	return list
+}
+
+// Gets all PIM eligible role assignments matching on 'filter'. Returns entire list if filter is empty ""
+func GetMatchingRoleEligibilities(filter string, force bool, z Bundle) (list []interface{}) {
+	return getMatchingPimInstances("ae", filter, force, z)
+}
+
+// Gets all PIM active role assignment schedules matching on 'filter'. Returns entire list if filter is empty ""
+func GetMatchingRoleSchedules(filter string, force bool, z Bundle) (list []interface{}) {
+	return getMatchingPimInstances("as", filter, force, z)
+}
+
+// Common cache and filter logic for PIM instance types
+func getMatchingPimInstances(t, filter string, force bool, z Bundle) (list []interface{}) {
+	MigrateCache(t, z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile(t, z)
+	cacheFileAge := utl.FileAge(cacheFile)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
+		list = GetAzPimInstances(t, z, true)
+	} else {
+		list = GetCachedObjects(cacheFile)
+	}
+
+	if filter == "" {
+		return list
+	}
+	var matchingList []interface{} = nil
+	for _, i := range list {
+		x := i.(map[string]interface{})
+		// Expanded properties include role, principal and scope display names, so this matches on those too
+		if utl.StringInJson(x, filter) {
+			matchingList = append(matchingList, x)
+		}
+	}
+	return matchingList
+}
+
+// Returns the attributes that identify an eligibility specfile object: role definition UUID,
+// principalId and scope. Dies if any are missing.
+func pimSpecAttributes(x map[string]interface{}) (roleId, principalId, scope string) {
+	xProp, _ := x["properties"].(map[string]interface{})
+	roleId = utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/")
+	principalId = utl.Str(xProp["principalId"])
+	scope = utl.Str(xProp["scope"])
+	if roleId == "" || principalId == "" || scope == "" {
+		utl.Die("Specfile is missing required attributes. Need at least:\n\n" +
+			"properties:\n" +
+			"    roleDefinitionId: <UUID or fully_qualified_roleDefinitionId>\n" +
+			"    principalId: <UUID>\n" +
+			"    scope: <resource_path_scope>\n" +
+			"    scheduleInfo: {}\n\n" +
+			"See script '-k*' options to create properly formatted sample files.\n")
+	}
+	return roleId, principalId, scope
+}
+
+// Returns fully qualified role definition Id as PIM requests expect it at given scope
+func pimRoleDefinitionId(roleId, scope string) string {
+	prefix := ""
+	if split := strings.Split(scope, "/"); strings.HasPrefix(scope, "/subscriptions/") && len(split) > 2 {
+		prefix = "/subscriptions/" + split[2]
+	}
+	return prefix + "/providers/Microsoft.Authorization/roleDefinitions/" + roleId
+}
+
+// Submits a PIM eligibility schedule request of given type ("AdminAssign" or "AdminRemove")
+// for the eligibility defined by specfile object x
+func submitAzRoleEligibilityRequest(requestType string, x map[string]interface{}, z Bundle) error {
+	roleId, principalId, scope := pimSpecAttributes(x)
+	xProp := x["properties"].(map[string]interface{})
+	properties := map[string]interface{}{
+		"principalId":      principalId,
+		"roleDefinitionId": pimRoleDefinitionId(roleId, scope),
+		"requestType":      requestType,
+		"justification":    utl.Str(xProp["justification"]),
+	}
+	if requestType == "AdminAssign" {
+		scheduleInfo, _ := xProp["scheduleInfo"].(map[string]interface{})
+		if scheduleInfo == nil {
+			scheduleInfo = map[string]interface{}{}
+		}
+		if scheduleInfo["startDateTime"] == nil {
+			scheduleInfo["startDateTime"] = time.Now().UTC().Format(time.RFC3339)
+		}
+		if scheduleInfo["expiration"] == nil {
+			scheduleInfo["expiration"] = map[string]interface{}{"type": "NoExpiration"}
+		}
+		properties["scheduleInfo"] = scheduleInfo
+		if xProp["condition"] != nil {
+			properties["condition"] = xProp["condition"]
+			properties["conditionVersion"] = xProp["conditionVersion"]
+		}
+	}
+	payload := map[string]interface{}{"properties": properties}
+	params := map[string]string{"api-version": ConstPimApiVersion}
+	url := ConstAzUrl + scope + "/providers/Microsoft.Authorization/roleEligibilityScheduleRequests/" + uuid.New().String()
+	r, statusCode, _ := ApiPut(url, z, payload, params)
+	if statusCode == 200 || statusCode == 201 {
+		utl.PrintYaml(r)
+		return nil
+	}
+	return printApiError(r, statusCode)
+}
+
+// Creates a PIM eligible role assignment as defined by specfile object x
+func CreateAzRoleEligibilityRequest(x map[string]interface{}, z Bundle) error {
+	return submitAzRoleEligibilityRequest("AdminAssign", x, z)
+}
+
+// Removes the PIM eligible role assignment defined by specfile object x
+func RemoveAzRoleEligibility(x map[string]interface{}, z Bundle) error {
+	return submitAzRoleEligibilityRequest("AdminRemove", x, z)
+}
+
+// Gets the PIM eligible role assignment instance matching specfile object x on its role,
+// principalId and scope. Returns nil if there is no such eligibility.
+func GetAzRoleEligibilityByObject(x map[string]interface{}, z Bundle) map[string]interface{} {
+	roleId, principalId, scope := pimSpecAttributes(x)
+	params := map[string]string{
+		"api-version": ConstPimApiVersion,
+		"$filter":     "principalId eq '" + principalId + "'",
+	}
+	url := ConstAzUrl + scope + "/providers/Microsoft.Authorization/roleEligibilityScheduleInstances"
+	for _, i := range getAzArmAllPages(url, params, z) {
+		y := i.(map[string]interface{})
+		yProp, _ := y["properties"].(map[string]interface{})
+		yRoleId := utl.LastElem(utl.Str(yProp["roleDefinitionId"]), "/")
+		if strings.EqualFold(utl.Str(yProp["scope"]), scope) && strings.EqualFold(yRoleId, roleId) {
+			return y
+		}
+	}
+	return nil
+}
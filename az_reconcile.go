@@ -0,0 +1,624 @@
+package maz
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/queone/utl"
+)
+
+// One change in a role assignment reconcile plan
+type AssignmentChange struct {
+	RoleId      string                 // Role definition UUID
+	PrincipalId string                 // Principal UUID
+	Scope       string                 // Assignment scope
+	Condition   string                 // ABAC condition and its version, see assignmentCondition
+	File        string                 // Specfile declaring it, for adds, updates and keeps
+	Fqid        string                 // Fully qualified Id of the existing assignment, except for adds
+	Object      map[string]interface{} // Specfile object, for adds and updates
+}
+
+// Difference between the role assignments declared in a directory of specfiles and those
+// that exist in Azure, limited to a set of managed scopes
+type AssignmentPlan struct {
+	Scopes  []string // Managed scopes. Only assignments at or below these are ever removed
+	Adds    []AssignmentChange
+	Updates []AssignmentChange // Existing assignments whose ABAC condition differs from the specfile
+	Removes []AssignmentChange
+	Keeps   []AssignmentChange
+}
+
+// Returns the key that makes a role assignment unique: role, principal and scope
+func assignmentKey(roleId, principalId, scope string) string {
+	return strings.ToLower(utl.LastElem(roleId, "/")) + "|" + strings.ToLower(principalId) + "|" + normalizeScope(scope)
+}
+
+// Returns the ABAC condition of role assignment properties, with its version, for comparing.
+// Returns "" if there is no condition.
+func assignmentCondition(xProp map[string]interface{}) string {
+	condition := strings.TrimSpace(utl.Str(xProp["condition"]))
+	if condition == "" {
+		return ""
+	}
+	return orDefault(utl.Str(xProp["conditionVersion"]), ConstAbacConditionVersion) + "|" + condition
+}
+
+// Returns true if scope is at or below one of the managed scopes
+func scopeIsManaged(scope string, managed []string) bool {
+	s := normalizeScope(scope)
+	for _, m := range managed {
+		m = normalizeScope(m)
+		if m == "/" || s == m || strings.HasPrefix(s, m+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Calls fn for every object in every specfile (*.yaml, *.yml and *.json) under given directory,
+// in file order. Objects are labeled with their file path, with a '#<index>' suffix when the
+// file holds several. Dies on unresolvable name-based references.
+func walkSpecfiles(dir string, z Bundle, fn func(label string, o SpecObject)) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if d.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			return nil
+		}
+		objects, errs := GetObjectsFromSpecfile(path, z)
+		for _, o := range objects {
+			label := path
+			if len(objects) > 1 {
+				label = fmt.Sprintf("%s#%d", path, o.Index)
+			}
+			if err := errs[o.Index]; err != nil {
+				utl.Die("Specfile %s: %s\n", label, err.Error())
+			}
+			fn(label, o)
+		}
+		return nil
+	})
+	if err != nil {
+		utl.Die("Error reading %s: %s\n", dir, err.Error())
+	}
+}
+
+// Loads every role assignment specfile object under given directory, resolving any name-based
+// references. Other specfile types are skipped. Dies on unresolvable or incomplete assignments.
+func LoadAssignmentSpecfiles(dir string, z Bundle) (specs []AssignmentChange) {
+	walkSpecfiles(dir, z, func(label string, o SpecObject) {
+		if o.MazType != "a" {
+			fmt.Printf("Skipping %s, not a role assignment specfile\n", utl.Yel(label))
+			return
+		}
+		xProp := o.Object["properties"].(map[string]interface{})
+		spec := AssignmentChange{
+			RoleId:      utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/"),
+			PrincipalId: utl.Str(xProp["principalId"]),
+			Scope:       utl.Str(xProp["scope"]),
+			Condition:   assignmentCondition(xProp),
+			File:        label,
+			Object:      o.Object,
+		}
+		if spec.RoleId == "" || spec.PrincipalId == "" || spec.Scope == "" {
+			utl.Die("Specfile %s is missing roleDefinitionId, principalId or scope.\n", label)
+		}
+		specs = append(specs, spec)
+	})
+	return specs
+}
+
+// Computes the plan to make the role assignments under the managed scopes match those declared
+// in the specfiles under dir. If no scopes are given, the scopes declared in the specfiles
+// are managed. Existing assignments are read fresh from Azure.
+func PlanRoleAssignments(dir string, scopes []string, z Bundle) (plan AssignmentPlan) {
+	specs := LoadAssignmentSpecfiles(dir, z)
+	if len(scopes) == 0 {
+		seen := map[string]bool{}
+		for _, spec := range specs {
+			if s := normalizeScope(spec.Scope); !seen[s] {
+				seen[s] = true
+				scopes = append(scopes, spec.Scope)
+			}
+		}
+	}
+	plan.Scopes = scopes
+
+	desired := map[string]AssignmentChange{}
+	for _, spec := range specs {
+		if !scopeIsManaged(spec.Scope, scopes) {
+			utl.Die("Specfile %s has scope %s, which is outside of the managed scopes.\n", spec.File, spec.Scope)
+		}
+		key := assignmentKey(spec.RoleId, spec.PrincipalId, spec.Scope)
+		if prev, ok := desired[key]; ok {
+			utl.Die("Specfiles %s and %s declare the same role assignment.\n", prev.File, spec.File)
+		}
+		desired[key] = spec
+	}
+
+	existing := map[string]bool{}
+	for _, i := range GetAzRoleAssignments(z, false) {
+		x := i.(map[string]interface{})
+		xProp := x["properties"].(map[string]interface{})
+		scope := utl.Str(xProp["scope"])
+		if !scopeIsManaged(scope, scopes) {
+			continue // Inherited from above, or outside of what this plan manages
+		}
+		change := AssignmentChange{
+			RoleId:      utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/"),
+			PrincipalId: utl.Str(xProp["principalId"]),
+			Scope:       scope,
+			Condition:   assignmentCondition(xProp),
+			Fqid:        utl.Str(x["id"]),
+		}
+		key := assignmentKey(change.RoleId, change.PrincipalId, change.Scope)
+		existing[key] = true
+		if spec, ok := desired[key]; ok {
+			change.File = spec.File
+			if spec.Condition != change.Condition {
+				change.Condition, change.Object = spec.Condition, spec.Object
+				plan.Updates = append(plan.Updates, change)
+			} else {
+				plan.Keeps = append(plan.Keeps, change)
+			}
+		} else {
+			plan.Removes = append(plan.Removes, change)
+		}
+	}
+	for key, spec := range desired {
+		if !existing[key] {
+			plan.Adds = append(plan.Adds, spec)
+		}
+	}
+	sort.Slice(plan.Adds, func(i, j int) bool { return plan.Adds[i].File < plan.Adds[j].File })
+	sort.Slice(plan.Updates, func(i, j int) bool { return plan.Updates[i].File < plan.Updates[j].File })
+	return plan
+}
+
+// Prints the plan, with role and principal names, in a diff-like format
+func PrintAssignmentPlan(plan AssignmentPlan, prune bool, z Bundle) {
+	roleNameMap := GetIdMapRoleDefs(z)
+	principalNames := map[string]string{}
+	for _, m := range []map[string]string{GetIdMapUsers(z), GetIdMapGroups(z), GetIdMapSps(z)} {
+		for id, name := range m {
+			principalNames[id] = name
+		}
+	}
+	describe := func(c AssignmentChange) string {
+		return fmt.Sprintf("%-40s  %-40s  %s", roleNameMap[c.RoleId], principalNames[c.PrincipalId], c.Scope)
+	}
+	fmt.Printf("%s: %s\n", utl.Blu("managed_scopes"), utl.Gre(strings.Join(plan.Scopes, ", ")))
+	for _, c := range plan.Adds {
+		fmt.Printf("%s %s  # %s\n", utl.Gre("+ add   "), describe(c), c.File)
+	}
+	for _, c := range plan.Updates {
+		fmt.Printf("%s %s  # %s, condition changed\n", utl.Yel("~ update"), describe(c), c.File)
+	}
+	for _, c := range plan.Removes {
+		if prune {
+			fmt.Printf("%s %s\n", utl.Red("- remove"), describe(c))
+		} else {
+			fmt.Printf("%s %s\n", utl.Yel("? unmanaged"), describe(c))
+		}
+	}
+	removes := len(plan.Removes)
+	if !prune {
+		removes = 0
+	}
+	fmt.Printf("Plan: %d to add, %d to update, %d to remove, %d unchanged", len(plan.Adds), len(plan.Updates), removes, len(plan.Keeps))
+	if !prune && len(plan.Removes) > 0 {
+		fmt.Printf(", %d not in specfiles (use prune to remove)", len(plan.Removes))
+	}
+	fmt.Println()
+}
+
+// Applies the plan: creates all adds, updates the conditions of all updates, and, only if
+// prune is true, deletes all removes. Carries on past errors, and prints a summary at the end.
+// Returns the number of changes that failed.
+func ApplyAssignmentPlan(plan AssignmentPlan, prune bool, z Bundle) (failed int) {
+	created, updated, deleted := 0, 0, 0
+	for _, c := range plan.Adds {
+		fmt.Printf("Creating assignment from %s\n", c.File)
+		if err := CreateAzRoleAssignment(c.Object, z); err != nil {
+			failed++
+		} else {
+			created++
+		}
+	}
+	for _, c := range plan.Updates {
+		fmt.Printf("Updating condition of assignment %s from %s\n", c.Fqid, c.File)
+		xProp := c.Object["properties"].(map[string]interface{})
+		validateRoleAssignmentCondition(xProp) // Dies if the ABAC condition is invalid
+		r, statusCode := putAzRoleAssignment(utl.LastElem(c.Fqid, "/"), c.RoleId, c.PrincipalId, c.Scope, xProp, z)
+		if statusCode == 200 || statusCode == 201 {
+			updated++
+		} else {
+			printApiError(r, statusCode)
+			failed++
+		}
+	}
+	if prune {
+		for _, c := range plan.Removes {
+			fmt.Printf("Deleting assignment %s\n", c.Fqid)
+			if err := DeleteAzRoleAssignmentByFqid(c.Fqid, z); err != nil {
+				failed++
+			} else {
+				deleted++
+			}
+		}
+	}
+	summary := fmt.Sprintf("%d failed", failed)
+	if failed > 0 {
+		summary = utl.Red(summary)
+	}
+	fmt.Printf("Applied: %d created, %d updated, %d deleted, %s\n", created, updated, deleted, summary)
+	return failed
+}
+
+// Reconciles role assignments under the managed scopes with the specfiles under dir. Always
+// prints the plan. If apply is true, it then applies it, after prompting unless force is
+// true. Existing assignments missing from the specfiles are only removed if prune is true.
+// Exits with an error status if any change failed to apply.
+func ReconcileRoleAssignments(dir string, scopes []string, apply, prune, force bool, z Bundle) {
+	plan := PlanRoleAssignments(dir, scopes, z)
+	PrintAssignmentPlan(plan, prune, z)
+	if !apply || (len(plan.Adds) == 0 && len(plan.Updates) == 0 && (!prune || len(plan.Removes) == 0)) {
+		return
+	}
+	if !force {
+		if utl.PromptMsg("APPLY above plan? y/n ") != 'y' {
+			utl.Die("Aborted.\n")
+		}
+	}
+	failed := ApplyAssignmentPlan(plan, prune, z)
+	GetAzRoleAssignments(z, false) // Refresh the local cache with the result
+	if failed > 0 {
+		utl.Die("%d role assignment changes failed\n", failed)
+	}
+}
+
+// Kinds of change in a role definition reconcile plan, in the order they are applied. Renames
+// go first so that a name they free up can be taken by a new role, and deletions go last.
+const (
+	RoleChangeRename = "rename"
+	RoleChangeUpdate = "update"
+	RoleChangeCreate = "create"
+	RoleChangeDelete = "delete"
+)
+
+// One change in a role definition reconcile plan
+type RoleDefinitionChange struct {
+	Kind       string                 // One of the RoleChange* constants
+	RoleId     string                 // Role definition UUID, new or existing
+	RoleName   string                 // Desired role name, or existing name for deletions
+	OldName    string                 // Existing role name, for renames
+	File       string                 // Specfile declaring it, except for deletions
+	Object     map[string]interface{} // Specfile object
+	Existing   map[string]interface{} // Azure object, except for creates
+	References int                    // Number of role assignments using the role, for deletions
+}
+
+// Difference between the custom role definitions declared in a directory of specfiles and
+// those that exist in Azure, limited to a set of managed scopes
+type RoleDefinitionPlan struct {
+	Scopes    []string // Managed scopes. Only custom roles assignable at these are deleted
+	Changes   []RoleDefinitionChange
+	Unchanged int
+}
+
+// Returns the stable UUID of a role definition specfile object, taken from either its 'name'
+// or its fully qualified 'id' attribute. Returns "" if neither is set, and an error if the one
+// that is set is not a UUID.
+func roleSpecId(x map[string]interface{}) (string, error) {
+	id := utl.Str(x["name"])
+	if id == "" {
+		id = utl.LastElem(utl.Str(x["id"]), "/")
+	}
+	if id != "" && !utl.ValidUuid(id) {
+		return "", fmt.Errorf("role id '%s' is not a UUID", id)
+	}
+	return strings.ToLower(id), nil
+}
+
+// Returns true if given string lists hold the same items, regardless of order
+func sameItems(a, b interface{}) bool {
+	aList, _ := a.([]interface{})
+	bList, _ := b.([]interface{})
+	added, removed, _ := DiffLists(aList, bList)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// Returns true if the specfile role definition a differs from Azure role definition b in its
+// description, assignable scopes or permissions. Role names are compared separately.
+func roleDefinitionChanged(a, b map[string]interface{}) bool {
+	aProp, _ := a["properties"].(map[string]interface{})
+	bProp, _ := b["properties"].(map[string]interface{})
+	if utl.Str(aProp["description"]) != utl.Str(bProp["description"]) ||
+		!sameItems(aProp["assignableScopes"], bProp["assignableScopes"]) {
+		return true
+	}
+	aPerms, _ := aProp["permissions"].([]interface{})
+	bPerms, _ := bProp["permissions"].([]interface{})
+	if len(aPerms) != len(bPerms) {
+		return true
+	}
+	for n := range aPerms {
+		aSet, _ := aPerms[n].(map[string]interface{})
+		bSet, _ := bPerms[n].(map[string]interface{})
+		for _, k := range []string{"actions", "notActions", "dataActions", "notDataActions"} {
+			if !sameItems(aSet[k], bSet[k]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Loads every role definition specfile object under given directory. Other specfile types
+// are skipped.
+func loadRoleDefinitionSpecfiles(dir string, z Bundle) (files []string, objects []map[string]interface{}) {
+	walkSpecfiles(dir, z, func(label string, o SpecObject) {
+		if o.MazType != "d" {
+			fmt.Printf("Skipping %s, not a role definition specfile\n", utl.Yel(label))
+			return
+		}
+		if err := validateSpecObject(o.MazType, o.Object); err != nil {
+			utl.Die("Specfile %s: %s\n", label, err.Error())
+		}
+		xProp := o.Object["properties"].(map[string]interface{})
+		if xProp["type"] == nil {
+			xProp["type"] = "CustomRole"
+		}
+		files = append(files, label)
+		objects = append(objects, o.Object)
+	})
+	return files, objects
+}
+
+// Computes the plan to make the custom role definitions assignable at the managed scopes match
+// those declared in the specfiles under dir. Specfiles carrying the role's UUID in 'name' or 'id'
+// are matched on it, so changing their roleName renames the role; all others are matched on
+// roleName. If no scopes are given, the assignable scopes declared in the specfiles are managed.
+func PlanRoleDefinitions(dir string, scopes []string, z Bundle) (plan RoleDefinitionPlan) {
+	files, objects := loadRoleDefinitionSpecfiles(dir, z)
+	if len(scopes) == 0 {
+		seen := map[string]bool{}
+		for _, x := range objects {
+			for _, s := range x["properties"].(map[string]interface{})["assignableScopes"].([]interface{}) {
+				if !seen[normalizeScope(utl.Str(s))] {
+					seen[normalizeScope(utl.Str(s))] = true
+					scopes = append(scopes, utl.Str(s))
+				}
+			}
+		}
+	}
+	plan.Scopes = scopes
+
+	byId := map[string]map[string]interface{}{}
+	byName := map[string]map[string]interface{}{}
+	for _, i := range GetAzRoleDefinitions(z, false) {
+		x := i.(map[string]interface{})
+		xProp := x["properties"].(map[string]interface{})
+		if utl.Str(xProp["type"]) != "CustomRole" {
+			continue
+		}
+		byId[strings.ToLower(utl.Str(x["name"]))] = x
+		byName[strings.ToLower(utl.Str(xProp["roleName"]))] = x
+	}
+
+	roleNameMap := GetIdMapRoleDefs(z)
+	claimed := map[string]string{}  // Azure role UUIDs already matched, to the specfile that matched them
+	declared := map[string]string{} // Desired role names, to the specfile declaring them
+	for n, x := range objects {
+		file := files[n]
+		roleName := utl.Str(x["properties"].(map[string]interface{})["roleName"])
+		if prev, ok := declared[strings.ToLower(roleName)]; ok {
+			utl.Die("Specfiles %s and %s both declare role '%s'.\n", prev, file, roleName)
+		}
+		declared[strings.ToLower(roleName)] = file
+
+		change := RoleDefinitionChange{RoleName: roleName, File: file, Object: x}
+		id, err := roleSpecId(x)
+		if err != nil {
+			utl.Die("Specfile %s: %s\n", file, err.Error())
+		}
+		if id != "" {
+			change.Existing = byId[id]
+			if change.Existing == nil {
+				if roleNameMap[id] != "" {
+					utl.Die("Specfile %s has the id of built-in role %s.\n", file, id)
+				}
+				change.RoleId = id // A new role with a pre-chosen UUID
+			}
+		} else {
+			change.Existing = byName[strings.ToLower(roleName)]
+		}
+		if change.Existing == nil {
+			change.Kind = RoleChangeCreate
+			if change.RoleId == "" {
+				change.RoleId = uuid.New().String()
+			}
+		} else {
+			change.RoleId = strings.ToLower(utl.Str(change.Existing["name"]))
+			change.OldName = utl.Str(change.Existing["properties"].(map[string]interface{})["roleName"])
+			if change.OldName != roleName {
+				change.Kind = RoleChangeRename
+			} else if roleDefinitionChanged(x, change.Existing) {
+				change.Kind = RoleChangeUpdate
+			}
+		}
+		if prev, ok := claimed[change.RoleId]; ok {
+			utl.Die("Specfiles %s and %s both declare role %s.\n", prev, file, change.RoleId)
+		}
+		claimed[change.RoleId] = file
+		if change.Kind == "" {
+			plan.Unchanged++
+			continue
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	// A new or renamed role can't take the name of an existing role that is not itself renamed away
+	for _, c := range plan.Changes {
+		if c.Kind != RoleChangeCreate && c.Kind != RoleChangeRename {
+			continue
+		}
+		if other := byName[strings.ToLower(c.RoleName)]; other != nil {
+			otherId := strings.ToLower(utl.Str(other["name"]))
+			if otherId != c.RoleId && claimed[otherId] == "" {
+				utl.Die("Specfile %s declares role '%s', but role %s already has that name and is not in the specfiles.\n",
+					c.File, c.RoleName, otherId)
+			}
+		}
+	}
+
+	// Custom roles that no specfile claims are orphaned if all of their assignable scopes are
+	// managed. Roles also assignable elsewhere are shared with others, and are left alone.
+	var orphans []RoleDefinitionChange
+	for id, x := range byId {
+		if claimed[id] != "" {
+			continue
+		}
+		xProp := x["properties"].(map[string]interface{})
+		assignableScopes, _ := xProp["assignableScopes"].([]interface{})
+		managed := len(assignableScopes) > 0
+		for _, s := range assignableScopes {
+			if !scopeIsManaged(utl.Str(s), scopes) {
+				managed = false
+				break
+			}
+		}
+		if managed {
+			orphans = append(orphans, RoleDefinitionChange{
+				Kind:     RoleChangeDelete,
+				RoleId:   id,
+				RoleName: utl.Str(xProp["roleName"]),
+				Existing: x,
+			})
+		}
+	}
+	if len(orphans) > 0 {
+		refs := map[string]int{}
+		for _, i := range GetAzRoleAssignments(z, false) {
+			xProp := i.(map[string]interface{})["properties"].(map[string]interface{})
+			refs[strings.ToLower(utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/"))]++
+		}
+		for n := range orphans {
+			orphans[n].References = refs[orphans[n].RoleId]
+		}
+		plan.Changes = append(plan.Changes, orphans...)
+	}
+
+	order := map[string]int{RoleChangeRename: 0, RoleChangeUpdate: 1, RoleChangeCreate: 2, RoleChangeDelete: 3}
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		a, b := plan.Changes[i], plan.Changes[j]
+		if order[a.Kind] != order[b.Kind] {
+			return order[a.Kind] < order[b.Kind]
+		}
+		return a.RoleName < b.RoleName
+	})
+	return plan
+}
+
+// Prints the plan, showing the definition diff of every updated or renamed role
+func PrintRoleDefinitionPlan(plan RoleDefinitionPlan, prune bool, z Bundle) {
+	fmt.Printf("%s: %s\n", utl.Blu("managed_scopes"), utl.Gre(strings.Join(plan.Scopes, ", ")))
+	counts := map[string]int{}
+	blocked := 0
+	for _, c := range plan.Changes {
+		switch c.Kind {
+		case RoleChangeCreate:
+			fmt.Printf("%s %s  %s  # %s\n", utl.Gre("+ create"), c.RoleId, utl.Gre(c.RoleName), c.File)
+		case RoleChangeRename:
+			fmt.Printf("%s %s  %s -> %s  # %s\n", utl.Yel("~ rename"), c.RoleId, utl.Red(c.OldName), utl.Gre(c.RoleName), c.File)
+			if roleDefinitionChanged(c.Object, c.Existing) {
+				DiffRoleDefinitionSpecfileVsAzure(c.Object, c.Existing, z)
+			}
+		case RoleChangeUpdate:
+			fmt.Printf("%s %s  %s  # %s\n", utl.Yel("~ update"), c.RoleId, utl.Gre(c.RoleName), c.File)
+			DiffRoleDefinitionSpecfileVsAzure(c.Object, c.Existing, z)
+		case RoleChangeDelete:
+			switch {
+			case !prune:
+				fmt.Printf("%s %s  %s\n", utl.Yel("? unmanaged"), c.RoleId, c.RoleName)
+				continue
+			case c.References > 0:
+				fmt.Printf("%s %s  %s  # Still referenced by %d assignments, will NOT be deleted\n",
+					utl.Red("! delete"), c.RoleId, c.RoleName, c.References)
+				blocked++
+				continue
+			default:
+				fmt.Printf("%s %s  %s\n", utl.Red("- delete"), c.RoleId, c.RoleName)
+			}
+		}
+		counts[c.Kind]++
+	}
+	fmt.Printf("Plan: %d to create, %d to rename, %d to update, %d to delete, %d unchanged",
+		counts[RoleChangeCreate], counts[RoleChangeRename], counts[RoleChangeUpdate], counts[RoleChangeDelete], plan.Unchanged)
+	if blocked > 0 {
+		fmt.Printf(", %d deletions blocked by existing assignments", blocked)
+	}
+	fmt.Println()
+}
+
+// Applies the plan in order: renames, updates, creates, and then, only if prune is true,
+// deletions of orphaned roles that no assignment references. Carries on past errors, and prints
+// a summary at the end. Returns the number of changes that failed.
+func ApplyRoleDefinitionPlan(plan RoleDefinitionPlan, prune bool, z Bundle) (failed int) {
+	applied := map[string]int{}
+	for _, c := range plan.Changes {
+		switch c.Kind {
+		case RoleChangeCreate, RoleChangeRename, RoleChangeUpdate:
+			fmt.Printf("Applying %s of role '%s' from %s\n", c.Kind, c.RoleName, c.File)
+			if PutAzRoleDefinition(c.RoleId, c.Object, z) == nil {
+				failed++
+				continue
+			}
+		case RoleChangeDelete:
+			if !prune || c.References > 0 {
+				continue
+			}
+			fmt.Printf("Deleting role '%s'\n", c.RoleName)
+			if err := DeleteAzRoleDefinitionByFqid(utl.Str(c.Existing["id"]), z); err != nil {
+				failed++
+				continue
+			}
+		}
+		applied[c.Kind]++
+	}
+	summary := fmt.Sprintf("%d failed", failed)
+	if failed > 0 {
+		summary = utl.Red(summary)
+	}
+	fmt.Printf("Applied: %d created, %d renamed, %d updated, %d deleted, %s\n", applied[RoleChangeCreate],
+		applied[RoleChangeRename], applied[RoleChangeUpdate], applied[RoleChangeDelete], summary)
+	return failed
+}
+
+// Reconciles custom role definitions assignable at the managed scopes with the specfiles under
+// dir. Always prints the plan. If apply is true, it then applies it, after prompting unless
+// force is true. Orphaned roles are only deleted if prune is true and nothing references them.
+// Exits with an error status if any change failed to apply.
+func ReconcileRoleDefinitions(dir string, scopes []string, apply, prune, force bool, z Bundle) {
+	plan := PlanRoleDefinitions(dir, scopes, z)
+	PrintRoleDefinitionPlan(plan, prune, z)
+	if !apply || len(plan.Changes) == 0 {
+		return
+	}
+	if !force {
+		if utl.PromptMsg("APPLY above plan? y/n ") != 'y' {
+			utl.Die("Aborted.\n")
+		}
+	}
+	failed := ApplyRoleDefinitionPlan(plan, prune, z)
+	GetAzRoleDefinitions(z, false) // Refresh the local cache with the result
+	if failed > 0 {
+		utl.Die("%d role definition changes failed\n", failed)
+	}
+}
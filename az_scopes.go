@@ -0,0 +1,125 @@
+package maz
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/queone/utl"
+)
+
+// RBAC scope discovery depths, see Bundle.ScopeDepth
+const (
+	ConstScopeDepthSubscriptions  = 0 // Management groups and subscriptions only, the default
+	ConstScopeDepthResourceGroups = 1 // Also every resource group
+	ConstScopeDepthResources      = 2 // Also every individual resource
+)
+
+// Prints resource group or resource object in YAML-like format
+func PrintResource(x map[string]interface{}) {
+	if x == nil {
+		return
+	}
+	list := []string{"id", "name", "type", "location"}
+	for _, i := range list {
+		if v := utl.Str(x[i]); v != "" {
+			fmt.Printf("%s: %s\n", utl.Blu(i), utl.Gre(v))
+		}
+	}
+}
+
+// Gets all objects from a paged ARM list API, following nextLink until the last page
+func getAzArmAllPages(url string, params map[string]string, z Bundle) (list []interface{}) {
+	r, _, _ := ApiGet(url, z, params)
+	for r != nil {
+		if r["value"] != nil {
+			list = append(list, r["value"].([]interface{})...)
+		}
+		nextLink := utl.Str(r["nextLink"])
+		if nextLink == "" {
+			break
+		}
+		r, _, _ = ApiGet(nextLink, z, nil) // The nextLink already carries the api-version
+	}
+	return list
+}
+
+// Gets objects under every enabled subscription from given ARM sub-path, such as "/resourcegroups",
+// with the subscriptions queried in parallel
+func getAzObjectsUnderSubs(subPath, apiVersion string, z Bundle) (list []interface{}) {
+	var mu sync.Mutex
+	subIds := GetAzSubscriptionsIds(z)
+	params := map[string]string{"api-version": apiVersion}
+	RunParallel(len(subIds), ConcurrencyLimit(z), func(n int) bool {
+		objects := getAzArmAllPages(ConstAzUrl+subIds[n]+subPath, params, z)
+		mu.Lock()
+		list = append(list, objects...)
+		mu.Unlock()
+		return false
+	})
+	return list
+}
+
+// Gets all resource groups in all subscriptions, and saves them to local cache file
+func GetAzResourceGroups(z Bundle) (list []interface{}) {
+	list = getAzObjectsUnderSubs("/resourcegroups", "2021-04-01", z) // resourceGroups
+	SaveCachedObjects("rg", list, z)                                 // Update the local cache
+	return list
+}
+
+// Gets all resources in all subscriptions, and saves them to local cache file
+func GetAzResources(z Bundle) (list []interface{}) {
+	list = getAzObjectsUnderSubs("/resources", "2021-04-01", z) // resources
+	SaveCachedObjects("rs", list, z)                            // Update the local cache
+	return list
+}
+
+// Gets all resource groups matching on 'filter'. Returns entire list if filter is empty ""
+func GetMatchingResourceGroups(filter string, force bool, z Bundle) (list []interface{}) {
+	return getMatchingScopeObjects("rg", GetAzResourceGroups, filter, force, z)
+}
+
+// Gets all resources matching on 'filter'. Returns entire list if filter is empty ""
+func GetMatchingResources(filter string, force bool, z Bundle) (list []interface{}) {
+	return getMatchingScopeObjects("rs", GetAzResources, filter, force, z)
+}
+
+// Common cache logic for resource groups and resources, which refresh on the same schedule
+// as the other ARM objects
+func getMatchingScopeObjects(t string, getAz func(Bundle) []interface{}, filter string, force bool, z Bundle) (list []interface{}) {
+	MigrateCache(t, z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile(t, z)
+	cacheFileAge := utl.FileAge(cacheFile)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
+		list = getAz(z)
+	} else {
+		list = GetCachedObjects(cacheFile)
+	}
+
+	if filter == "" {
+		return list
+	}
+	var matchingList []interface{} = nil
+	for _, i := range list {
+		x := i.(map[string]interface{})
+		if utl.StringInJson(x, filter) {
+			matchingList = append(matchingList, x)
+		}
+	}
+	return matchingList
+}
+
+// Returns the resource group and resource scopes to search for RBAC objects, down to the
+// Bundle's ScopeDepth. Returns nothing at the default depth.
+func GetAzDeepRbacScopes(z Bundle) (scopes []string) {
+	if z.ScopeDepth >= ConstScopeDepthResourceGroups {
+		for _, i := range GetMatchingResourceGroups("", false, z) {
+			scopes = append(scopes, utl.Str(i.(map[string]interface{})["id"]))
+		}
+	}
+	if z.ScopeDepth >= ConstScopeDepthResources {
+		for _, i := range GetMatchingResources("", false, z) {
+			scopes = append(scopes, utl.Str(i.(map[string]interface{})["id"]))
+		}
+	}
+	return scopes
+}
@@ -2,7 +2,6 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
 
 	"github.com/queone/utl"
 )
@@ -23,16 +22,8 @@ func PrintSubscription(x map[string]interface{}) {
 
 // Returns count of all subscriptions in local cache file
 func SubsCountLocal(z Bundle) int64 {
-	var cachedList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_subscriptions."+ConstCacheFileExtension)
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-			return int64(len(cachedList))
-		}
-	}
-	return 0
+	cachedList := GetCachedObjects(CacheFile("s", z))
+	return int64(len(cachedList))
 }
 
 // Returns count of all subscriptions in current Azure tenant
@@ -76,10 +67,11 @@ func GetIdMapSubs(z Bundle) (nameMap map[string]string) {
 
 // Gets all Azure subscriptions matching on 'filter'. Returns entire list if filter is empty ""
 func GetMatchingSubscriptions(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_subscriptions."+ConstCacheFileExtension)
+	MigrateCache("s", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("s", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstAzCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstAzCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzSubscriptions(z)
@@ -113,8 +105,7 @@ func GetAzSubscriptions(z Bundle) (list []interface{}) {
 		objects := r["value"].([]interface{})
 		list = append(list, objects...)
 	}
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_subscriptions."+ConstCacheFileExtension)
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+	SaveCachedObjects("s", list, z) // Update the local cache
 	return list
 }
 
@@ -126,3 +117,13 @@ func GetAzSubscriptionByUuid(uuid string, z Bundle) map[string]interface{} {
 	//ApiErrorCheck("GET", url, utl.Trace(), r) // Commented out to do this quietly. Use for DEBUGging
 	return r
 }
+
+// Returns typed subscriptions matching on filter. See GetMatchingSubscriptions.
+func ListSubscriptions(filter string, force bool, z Bundle) ([]Subscription, error) {
+	return ToModelList[Subscription](GetMatchingSubscriptions(filter, force, z))
+}
+
+// Returns typed subscription with given UUID from Azure, or nil if not found
+func GetSubscription(uuid string, z Bundle) (*Subscription, error) {
+	return toModelPtr[Subscription](GetAzSubscriptionByUuid(uuid, z))
+}
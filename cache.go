@@ -0,0 +1,225 @@
+package maz
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/queone/utl"
+)
+
+// Describes how objects of a given maz type are kept in the local cache. Bump Version
+// whenever the shape of the cached objects changes in a way that Select does not capture.
+type CacheDescriptor struct {
+	Name    string   // Azure object type name, as used in the cache file name
+	Version int      // Schema version of the cached objects
+	Select  []string // MS Graph $select attributes, only for types synced via delta queries
+}
+
+// Header stored at the top of every cache file
+type CacheHeader struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	MazVersion    string   `json:"mazVersion"`
+	TenantId      string   `json:"tenantId"`
+	ObjectType    string   `json:"objectType"`
+	Select        []string `json:"select"`
+	LastSync      string   `json:"lastSync"`
+}
+
+// On-disk layout of a cache file. Header comes first so it can be read without
+// having to decode the whole object list.
+type cacheFileT struct {
+	Header  CacheHeader   `json:"header"`
+	Objects []interface{} `json:"objects"`
+}
+
+var cacheDescriptors = map[string]CacheDescriptor{
+	"d": {Name: "roleDefinitions", Version: 1},
+	"a": {Name: "roleAssignments", Version: 1},
+	"s": {Name: "subscriptions", Version: 1},
+	"m": {Name: "managementGroups", Version: 1},
+	"u": {Name: "users", Version: 1,
+		Select: []string{"displayName", "userPrincipalName", "onPremisesSamAccountName"}},
+	"g": {Name: "groups", Version: 1,
+		Select: []string{"displayName", "description", "isAssignableToRole"}},
+	"sp": {Name: "servicePrincipals", Version: 1,
+		Select: []string{"displayName", "appId", "accountEnabled", "appOwnerOrganizationId", "passwordCredentials"}},
+	"ap": {Name: "applications", Version: 1,
+		Select: []string{"displayName", "appId", "requiredResourceAccess", "passwordCredentials"}},
+	"ad": {Name: "directoryRoles", Version: 1},
+	"rg": {Name: "resourceGroups", Version: 1},
+	"rs": {Name: "resources", Version: 1},
+	"ae": {Name: "roleEligibilityScheduleInstances", Version: 1},
+	"as": {Name: "roleAssignmentScheduleInstances", Version: 1},
+	"dn": {Name: "denyAssignments", Version: 1},
+	"ca": {Name: "classicAdministrators", Version: 1},
+	"po": {Name: "providerOperations", Version: 1},
+}
+
+var cacheFileLocks sync.Map // Per cache file mutexes, since parallel calls may sync the same type
+
+// Locks given cache file against concurrent reads and writes. Returns the unlock function.
+func lockCacheFile(cacheFile string) (unlock func()) {
+	m, _ := cacheFileLocks.LoadOrStore(cacheFile, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Returns the cache descriptor for maz type t
+func GetCacheDescriptor(t string) (desc CacheDescriptor, ok bool) {
+	desc, ok = cacheDescriptors[t]
+	return desc, ok
+}
+
+// Returns the local cache file path for objects of maz type t
+func CacheFile(t string, z Bundle) string {
+	dir := z.ConfDir
+	if z.CacheDir != "" {
+		dir = z.CacheDir
+	}
+	return filepath.Join(dir, z.TenantId+"_"+cacheDescriptors[t].Name+"."+ConstCacheFileExtension)
+}
+
+// Returns the local deltaLink file path for objects of maz type t
+func DeltaLinkFile(t string, z Bundle) string {
+	return filepath.Join(z.ConfDir, z.TenantId+"_"+cacheDescriptors[t].Name+"_deltaLink."+ConstCacheFileExtension)
+}
+
+// Retrieves locally cached list of objects in given cache file. Handles both the current
+// header format and legacy header-less caches, which are just a plain list of objects.
+func GetCachedObjects(cacheFile string) (cachedList []interface{}) {
+	cachedList = nil
+	defer lockCacheFile(cacheFile)()
+	if utl.FileUsable(cacheFile) {
+		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
+		switch raw := rawList.(type) {
+		case []interface{}:
+			cachedList = raw // Legacy cache without a header
+		case map[string]interface{}:
+			if objects, ok := raw["objects"].([]interface{}); ok {
+				cachedList = objects
+			} // Anything else is a corrupt cache, treated as a cache miss
+		}
+	}
+	return cachedList
+}
+
+// Reads only the header of given cache file. Returns nil if the file does not exist,
+// or if it is a legacy cache without a header.
+func GetCacheHeader(cacheFile string) *CacheHeader {
+	defer lockCacheFile(cacheFile)()
+	if !utl.FileUsable(cacheFile) {
+		return nil
+	}
+	f, err := os.Open(cacheFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		return nil
+	}
+	defer gzipReader.Close()
+
+	// Stream just the first key of the JSON object, so large caches are not fully decoded
+	decoder := json.NewDecoder(gzipReader)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil // Legacy caches start with a '[' list
+	}
+	if key, err := decoder.Token(); err != nil || key != "header" {
+		return nil
+	}
+	var header CacheHeader
+	if err := decoder.Decode(&header); err != nil {
+		return nil
+	}
+	return &header
+}
+
+// Saves list of objects of maz type t to its local cache file, with a fresh header
+func SaveCachedObjects(t string, list []interface{}, z Bundle) {
+	saveCacheFile(t, list, time.Now(), z)
+}
+
+// Writes cache file for type t, stamping lastSync with given time
+func saveCacheFile(t string, list []interface{}, lastSync time.Time, z Bundle) {
+	if z.CacheReadOnly {
+		return // Never overwrite read-only caches, such as snapshots
+	}
+	desc := cacheDescriptors[t]
+	cache := cacheFileT{
+		Header: CacheHeader{
+			SchemaVersion: desc.Version,
+			MazVersion:    ConstMazVersion,
+			TenantId:      z.TenantId,
+			ObjectType:    desc.Name,
+			Select:        desc.Select,
+			LastSync:      lastSync.UTC().Format(time.RFC3339),
+		},
+		Objects: list,
+	}
+	cacheFile := CacheFile(t, z)
+	defer lockCacheFile(cacheFile)()
+	utl.SaveFileJsonGzip(cache, cacheFile)
+}
+
+// Returns true if given header was written with the current schema of maz type t
+func cacheHeaderCurrent(t string, header *CacheHeader, z Bundle) bool {
+	desc := cacheDescriptors[t]
+	if header == nil || header.SchemaVersion != desc.Version || header.TenantId != z.TenantId ||
+		header.ObjectType != desc.Name || len(header.Select) != len(desc.Select) {
+		return false
+	}
+	for i := range desc.Select {
+		if header.Select[i] != desc.Select[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks that the local cache for maz type t matches its current descriptor, and fixes it
+// if it does not. Caches whose $select list or schema version no longer match are removed,
+// along with any deltaLink, so that the next sync rebuilds them with a full query. Legacy
+// header-less caches of types that don't use $select are migrated in place, keeping their
+// original modification time so the usual cache age checks still apply.
+func MigrateCache(t string, z Bundle) {
+	desc, ok := cacheDescriptors[t]
+	if !ok || z.CacheReadOnly {
+		return
+	}
+	cacheFile := CacheFile(t, z)
+	if !utl.FileUsable(cacheFile) {
+		return
+	}
+	header := GetCacheHeader(cacheFile)
+	if cacheHeaderCurrent(t, header, z) {
+		if header.MazVersion != ConstMazVersion {
+			// Same schema, only the maz version is different, so just restamp the header
+			rewriteCacheFile(t, cacheFile, z)
+		}
+		return
+	}
+	if header == nil && len(desc.Select) == 0 {
+		rewriteCacheFile(t, cacheFile, z) // Migrate legacy cache, objects are unchanged
+		return
+	}
+	utl.RemoveFile(cacheFile) // Schema mismatch, force a full rebuild
+	utl.RemoveFile(DeltaLinkFile(t, z))
+}
+
+// Rewrites cache file with a current header, preserving its objects and modification time
+func rewriteCacheFile(t, cacheFile string, z Bundle) {
+	info, err := os.Stat(cacheFile)
+	if err != nil {
+		return
+	}
+	list := GetCachedObjects(cacheFile)
+	saveCacheFile(t, list, info.ModTime(), z)
+	os.Chtimes(cacheFile, info.ModTime(), info.ModTime())
+}
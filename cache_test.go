@@ -0,0 +1,37 @@
+package maz
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/queone/utl"
+)
+
+func TestGetCachedObjects(t *testing.T) {
+	dir := t.TempDir()
+	list := []interface{}{map[string]interface{}{"id": "1"}}
+	tests := []struct {
+		name    string
+		content interface{} // Saved as the cache file, nil for no file
+		want    []interface{}
+	}{
+		{"current format", map[string]interface{}{"header": map[string]interface{}{}, "objects": list}, list},
+		{"legacy format", list, list},
+		{"no file", nil, nil},
+		{"no objects", map[string]interface{}{"header": map[string]interface{}{}}, nil},
+		{"objects is not a list", map[string]interface{}{"objects": map[string]interface{}{"id": "1"}}, nil},
+		{"objects is a string", map[string]interface{}{"objects": "1"}, nil},
+		{"not an object", "objects", nil},
+	}
+	for n, tt := range tests {
+		cacheFile := filepath.Join(dir, fmt.Sprintf("cache%d.gz", n))
+		if tt.content != nil {
+			utl.SaveFileJsonGzip(tt.content, cacheFile)
+		}
+		if got := GetCachedObjects(cacheFile); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: GetCachedObjects() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
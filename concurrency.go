@@ -0,0 +1,114 @@
+package maz
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Thread-safe set of strings, used to deduplicate objects gathered by parallel workers
+type SyncSet struct {
+	mu    sync.Mutex
+	items map[string]struct{}
+}
+
+// Returns a new empty thread-safe set
+func NewSyncSet() *SyncSet {
+	return &SyncSet{items: make(map[string]struct{})}
+}
+
+// Adds item to the set. Returns true if it was not already there.
+func (s *SyncSet) Add(item string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[item]; ok {
+		return false
+	}
+	s.items[item] = struct{}{}
+	return true
+}
+
+// Returns true if item is in the set
+func (s *SyncSet) Has(item string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.items[item]
+	return ok
+}
+
+// Returns number of items in the set
+func (s *SyncSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// Returns the maximum number of concurrent API calls to use with this Bundle
+func ConcurrencyLimit(z Bundle) int {
+	if z.MaxConcurrency > 0 {
+		return z.MaxConcurrency
+	}
+	return ConstMaxConcurrency
+}
+
+// Request slots shared by every ApiCall, one set per concurrency limit. RunParallel only
+// bounds its own workers, so nested calls, such as a search over types that searches every
+// scope for some of them, would otherwise multiply the number of requests in flight.
+var (
+	apiSlotsMu sync.Mutex
+	apiSlots   = map[int]chan struct{}{}
+)
+
+// Waits for one of the ConcurrencyLimit(z) request slots to be free and takes it. Returns the
+// function that gives it back.
+func acquireApiSlot(z Bundle) (release func()) {
+	limit := ConcurrencyLimit(z)
+	apiSlotsMu.Lock()
+	slots, ok := apiSlots[limit]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		apiSlots[limit] = slots
+	}
+	apiSlotsMu.Unlock()
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// Calls fn(i) for every i in [0, n) using a pool of at most limit workers, and waits for
+// all of them to finish. If any call returns true, no further items are started, which
+// allows searches to stop as soon as they find what they're looking for.
+func RunParallel(n, limit int, fn func(i int) (stop bool)) {
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > n {
+		limit = n
+	}
+	var next int64 = -1
+	var stopped atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < limit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stopped.Load() {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				if fn(i) {
+					stopped.Store(true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Runs given independent tasks in parallel, at most ConcurrencyLimit(z) at a time, and
+// waits for all of them to finish. Tasks must only write to their own result variables.
+func RunConcurrently(z Bundle, tasks ...func()) {
+	RunParallel(len(tasks), ConcurrencyLimit(z), func(i int) bool {
+		tasks[i]()
+		return false
+	})
+}
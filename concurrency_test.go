@@ -0,0 +1,38 @@
+package maz
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireApiSlotNested(t *testing.T) {
+	z := Bundle{MaxConcurrency: 3}
+	var mu sync.Mutex
+	inFlight, most, calls := 0, 0, 0
+	call := func() { // Stands in for one ApiCall
+		defer acquireApiSlot(z)()
+		mu.Lock()
+		inFlight++
+		calls++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}
+	RunParallel(6, ConcurrencyLimit(z), func(i int) bool {
+		call()
+		RunParallel(6, ConcurrencyLimit(z), func(j int) bool {
+			call()
+			return false
+		})
+		return false
+	})
+	if calls != 42 || most > 3 {
+		t.Errorf("%d calls with at most %d in flight, want 42 with at most 3", calls, most)
+	}
+}
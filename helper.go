@@ -2,49 +2,52 @@ package maz
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/queone/utl"
 )
 
-// Creates or updates a role definition or assignment based on given specfile
+// Creates or updates a role definition or assignment based on given specfile. The specfile is
+// validated first, and nothing is changed if it has errors. Each object in the specfile is
+// processed in order, followed by a per-object result report. Exits with an error status if
+// any object failed, however many objects the specfile holds.
 func UpsertAzObject(force bool, filePath string, z Bundle) {
 	if utl.FileNotExist(filePath) || utl.FileSize(filePath) < 1 {
 		utl.Die("File does not exist, or it is zero size\n")
 	}
-	formatType, t, x := GetObjectFromFile(filePath)
-	if formatType != "JSON" && formatType != "YAML" {
-		utl.Die("File is not in JSON nor YAML format\n")
-	}
-	if t != "d" && t != "a" {
-		utl.Die("File is not a role definition nor an assignment specfile\n")
+	if PrintSpecDiagnostics(ValidateSpecfile(filePath, z)) > 0 {
+		utl.Die("Specfile has errors, nothing was changed\n")
 	}
-	switch t {
-	case "d":
-		UpsertAzRoleDefinition(force, x, z)
-	case "a":
-		CreateAzRoleAssignment(x, z)
+	objects, errs := GetObjectsFromSpecfile(filePath, z)
+	if len(objects) < 1 || (objects[0].FormatType != "JSON" && objects[0].FormatType != "YAML") {
+		utl.Die("File is not in JSON nor YAML format\n")
 	}
-	os.Exit(0)
+	exitWithSpecResults(processSpecObjects(objects, errs, func(o SpecObject) (string, error) {
+		return upsertSpecObject(force, o, z)
+	}))
 }
 
-// Deletes object based on string specifier (currently only supports roleDefinitions or Assignments)
+// Deletes object based on string specifier (currently only supports roleDefinitions, Assignments,
+// PIM eligibilities, or directory role assignments)
 // String specifier can be either of 3: UUID, specfile, or displaName (only for roleDefinition)
 // 1) Search Azure by given identifier; 2) Grab object's Fully Qualified Id string;
 // 3) Print and prompt for confirmation; 4) Delete or abort
 func DeleteAzObject(force bool, specifier string, z Bundle) {
 	if utl.ValidUuid(specifier) {
-		list := FindAzObjectsByUuid(specifier, z) // Get all objects that may match this UUID, hopefully just one
-		if len(list) > 1 {
+		matches := FindAzObjectMatchesByUuid(specifier, z) // Get all objects that may match this UUID, hopefully just one
+		if len(matches) > 1 {
 			utl.Die(utl.Red("UUID collision? Run utility with UUID argument to see the list.\n"))
 		}
-		if len(list) < 1 {
+		if len(matches) < 1 {
 			utl.Die("Object does not exist.\n")
 		}
-		y := list[0].(map[string]interface{}) // Single out the only object
-		if y != nil && y["mazType"] != nil {
-			t := utl.Str(y["mazType"])
+		y := matches[0].Object // Single out the only object
+		if y != nil {
+			t := matches[0].MazType
+			if t != "d" && t != "a" {
+				utl.Die("Cannot delete %s, unsupported type.\n", mazTypesLong[t])
+			}
 			fqid := utl.Str(y["id"]) // Grab fully qualified object Id
 			PrintObject(t, y, z)
 			if !force {
@@ -60,44 +63,14 @@ func DeleteAzObject(force bool, specifier string, z Bundle) {
 			}
 		}
 	} else if utl.FileExist(specifier) {
-		// Delete object defined in specfile
-		formatType, t, x := GetObjectFromFile(specifier) // x is for the object in Specfile
-		if formatType != "JSON" && formatType != "YAML" {
+		// Delete object(s) defined in specfile, followed by a per-object result report
+		objects, errs := GetObjectsFromSpecfile(specifier, z)
+		if len(objects) < 1 || (objects[0].FormatType != "JSON" && objects[0].FormatType != "YAML") {
 			utl.Die("File is not in JSON nor YAML format\n")
 		}
-		var y map[string]interface{} = nil
-		switch t {
-		case "d":
-			y = GetAzRoleDefinitionByObject(x, z) // y is for the object from Azure
-			fqid := utl.Str(y["id"])              // Grab fully qualified object Id
-			if y == nil {
-				utl.Die("Role definition does not exist.\n")
-			} else {
-				PrintRoleDefinition(y, z) // Use specific role def print function
-				if !force {
-					if utl.PromptMsg("DELETE above? y/n ") != 'y' {
-						utl.Die("Aborted.\n")
-					}
-				}
-				DeleteAzRoleDefinitionByFqid(fqid, z)
-			}
-		case "a":
-			y = GetAzRoleAssignmentByObject(x, z)
-			fqid := utl.Str(y["id"]) // Grab fully qualified object Id
-			if y == nil {
-				utl.Die("Role assignment does not exist.\n")
-			} else {
-				PrintRoleAssignment(y, z) // Use specific role assgmnt print function
-				if !force {
-					if utl.PromptMsg("DELETE above? y/n ") != 'y' {
-						utl.Die("Aborted.\n")
-					}
-				}
-				DeleteAzRoleAssignmentByFqid(fqid, z)
-			}
-		default:
-			utl.Die("File " + formatType + " is not a role definition or assignment.\n")
-		}
+		exitWithSpecResults(processSpecObjects(objects, errs, func(o SpecObject) (string, error) {
+			return deleteSpecObject(force, o, z)
+		}))
 	} else {
 		// Delete role definition by its displayName, if it exists. This only applies to definitions
 		// since assignments do not have a displayName attribute. Also, other objects are not supported.
@@ -116,20 +89,55 @@ func DeleteAzObject(force bool, specifier string, z Bundle) {
 	}
 }
 
+// An Azure object found by UUID, along with its maz type
+type UuidMatch struct {
+	MazType string
+	Object  map[string]interface{}
+}
+
 // Returns list of Azure objects with this UUID. We are saying a list because 1)
 // the UUID could be an appId shared by an app and an SP, or 2) there could be
 // UUID collisions with multiple objects potentially sharing the same UUID. Only
-// checks for the maz package limited set of Azure object types.
-func FindAzObjectsByUuid(uuid string, z Bundle) (list []interface{}) {
-	list = nil
-	for _, t := range mazTypes {
-		x := GetAzObjectByUuid(t, uuid, z)
+// checks for the maz package limited set of Azure object types, all of them
+// concurrently. Deny assignments and classic administrators are only looked up in
+// their local cache files, since looking them up in Azure means searching every
+// scope or subscription. Matches are returned in mazTypes order.
+func FindAzObjectMatchesByUuid(uuid string, z Bundle) (matches []UuidMatch) {
+	found := make([]map[string]interface{}, len(mazTypes)) // One slot per type, so no locking needed
+	RunParallel(len(mazTypes), ConcurrencyLimit(z), func(i int) bool {
+		if t := mazTypes[i]; t == "dn" || t == "ca" {
+			found[i] = getCachedObjectByUuid(t, uuid, z)
+		} else {
+			found[i] = GetAzObjectByUuid(t, uuid, z)
+		}
+		return false
+	})
+	for i, x := range found {
 		if x != nil && x["id"] != nil { // Valid objects have an 'id' attribute
-			// Found one of these types with this UUID
-			x["mazType"] = t // Extend object with mazType as an ADDITIONAL field
-			list = append(list, x)
+			matches = append(matches, UuidMatch{MazType: mazTypes[i], Object: x})
 		}
 	}
+	return matches
+}
+
+// Returns the object of type t whose name is given UUID from the local cache file only, or nil
+func getCachedObjectByUuid(t, uuid string, z Bundle) map[string]interface{} {
+	for _, i := range GetCachedObjects(CacheFile(t, z)) {
+		if x, ok := i.(map[string]interface{}); ok && strings.EqualFold(utl.Str(x["name"]), uuid) {
+			return x
+		}
+	}
+	return nil
+}
+
+// Untyped version of FindAzObjectMatchesByUuid. Each object is extended with its
+// mazType as an ADDITIONAL field.
+func FindAzObjectsByUuid(uuid string, z Bundle) (list []interface{}) {
+	list = nil
+	for _, m := range FindAzObjectMatchesByUuid(uuid, z) {
+		m.Object["mazType"] = m.MazType
+		list = append(list, m.Object)
+	}
 	return list
 }
 
@@ -152,6 +160,10 @@ func GetAzObjectByUuid(t, uuid string, z Bundle) (x map[string]interface{}) {
 		return GetAzAppByUuid(uuid, z)
 	case "ad":
 		return GetAzAdRoleByUuid(uuid, z)
+	case "dn":
+		return GetAzDenyAssignmentByUuid(uuid, z)
+	case "ca":
+		return GetAzClassicAdminByUuid(uuid, z)
 	}
 	return nil
 }
@@ -168,41 +180,13 @@ func GetAzRbacScopes(z Bundle) (scopes []string) {
 	subIds := GetAzSubscriptionsIds(z) // Now add all the subscription scopes
 	scopes = append(scopes, subIds...)
 
-	// SCOPES below subscriptions do not appear to be REALLY NEEDED. Most list
-	// search functions pull all objects in lower scopes. If there is a future
-	// need to keep drilling down, next level being Resource Group scopes, then
-	// they can be acquired with something like below:
-
-	// params := map[string]string{"api-version": "2021-04-01"} // resourceGroups
-	// for subId := range subIds {
-	// 	url := ConstAzUrl + subId + "/resourcegroups"
-	// 	r, _, _ := ApiGet(url, z, params)
-	// 	if r != nil && r["value"] != nil {
-	// 		resourceGroups := r["value"].([]interface{})
-	// 		for _, j := range resourceGroups {
-	// 			y := j.(map[string]interface{})
-	// 			rgId := utl.Str(y["id"])
-	// 			scopes = append(scopes, rgId)
-	// 		}
-	// 	}
-	// }
-	// // Then repeat for next leval scope ...
+	// SCOPES below subscriptions are not needed for most list search functions, since
+	// they pull all objects in lower scopes. Role assignment searches that need to drill
+	// down to resource groups or resources add GetAzDeepRbacScopes() to these.
 
 	return scopes
 }
 
-// Retrieves locally cached list of objects in given cache file
-func GetCachedObjects(cacheFile string) (cachedList []interface{}) {
-	cachedList = nil
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-		}
-	}
-	return cachedList
-}
-
 // Generic function to get objects of type t whose attributes match on filter.
 // If filter is the "" empty string return ALL of the objects of this type.
 func GetObjects(t, filter string, force bool, z Bundle) (list []interface{}) {
@@ -225,6 +209,20 @@ func GetObjects(t, filter string, force bool, z Bundle) (list []interface{}) {
 		return GetMatchingSps(filter, force, z)
 	case "u":
 		return GetMatchingUsers(filter, force, z)
+	case "rg":
+		return GetMatchingResourceGroups(filter, force, z)
+	case "rs":
+		return GetMatchingResources(filter, force, z)
+	case "ae":
+		return GetMatchingRoleEligibilities(filter, force, z)
+	case "as":
+		return GetMatchingRoleSchedules(filter, force, z)
+	case "dn":
+		return GetMatchingDenyAssignments(filter, force, z)
+	case "ca":
+		return GetMatchingClassicAdmins(filter, force, z)
+	case "po":
+		return GetMatchingProviderOperations(filter, force, z)
 	}
 	return nil
 }
@@ -316,6 +314,8 @@ func RemoveCacheFile(t string, z Bundle) {
 	case "ad":
 		utl.RemoveFile(filepath.Join(z.ConfDir, z.TenantId+"_directoryRoles."+ConstCacheFileExtension))
 		utl.RemoveFile(filepath.Join(z.ConfDir, z.TenantId+"_directoryRoles_deltaLink."+ConstCacheFileExtension))
+	case "rg", "rs", "ae", "as", "dn", "ca", "po":
+		utl.RemoveFile(CacheFile(t, z))
 	case "all":
 		// See https://stackoverflow.com/questions/48072236/remove-files-with-wildcard
 		fileList, err := filepath.Glob(filepath.Join(z.ConfDir, z.TenantId+"_*."+ConstCacheFileExtension))
@@ -341,7 +341,35 @@ func GetObjectFromFile(filePath string) (formatType, t string, obj map[string]in
 		}
 		formatType = "YAML" // It is YAML
 	}
-	obj = objRaw.(map[string]interface{})
+	return classifySpecObject(formatType, objRaw)
+}
+
+// Returns the same 3 values as GetObjectFromFile, for specfile content already in memory
+func GetObjectFromBytes(content []byte) (formatType, t string, obj map[string]interface{}) {
+	formatType = "JSON"
+	objRaw, _ := utl.JsonBytesToJsonObj(content)
+	if objRaw == nil {
+		objRaw, _ = utl.BytesToYamlObject(content)
+		if objRaw == nil {
+			return "", "", nil
+		}
+		formatType = "YAML"
+	}
+	return classifySpecObject(formatType, objRaw)
+}
+
+// Works out which maz type a parsed specfile object is
+func classifySpecObject(formatType string, objRaw interface{}) (string, string, map[string]interface{}) {
+	obj, ok := objRaw.(map[string]interface{})
+	if !ok {
+		return formatType, "", nil // Not an object, so it can't be a specfile
+	}
+
+	// Directory role assignments use the flat MS Graph layout, without a properties attribute.
+	// Their directoryScopeId is optional, and defaults to "/".
+	if obj["properties"] == nil && obj["roleDefinitionId"] != nil && (obj["principalId"] != nil || obj["principalName"] != nil) {
+		return formatType, "ada", obj
+	}
 
 	// Continue unpacking the object to see what it is
 	xProp, err := obj["properties"].(map[string]interface{})
@@ -350,9 +378,16 @@ func GetObjectFromFile(filePath string) (formatType, t string, obj map[string]in
 	}
 	roleName := utl.Str(xProp["roleName"])       // Assert and assume it's a definition
 	roleId := utl.Str(xProp["roleDefinitionId"]) // assert and assume it's an assignment
+	// Assignments may name their role with roleName instead, so a principal is what tells them apart
+	hasPrincipal := xProp["principalId"] != nil || xProp["principalName"] != nil
+	if roleId == "" && hasPrincipal {
+		roleId = roleName
+	}
 
-	if roleName != "" {
+	if roleName != "" && !hasPrincipal {
 		return formatType, "d", obj // Role definition
+	} else if roleId != "" && xProp["scheduleInfo"] != nil {
+		return formatType, "ae", obj // PIM eligible role assignment
 	} else if roleId != "" {
 		return formatType, "a", obj // Role assignment
 	} else {
@@ -360,34 +395,18 @@ func GetObjectFromFile(filePath string) (formatType, t string, obj map[string]in
 	}
 }
 
-// Compares specification file to what is in Azure
+// Compares specification file to what is in Azure. Each object in the specfile is compared in
+// order, followed by a per-object result report. Exits with an error status if any object
+// failed.
 func CompareSpecfileToAzure(filePath string, z Bundle) {
 	if utl.FileNotExist(filePath) || utl.FileSize(filePath) < 1 {
 		utl.Die("File does not exist, or is zero size\n")
 	}
-	formatType, t, fileDef := GetObjectFromFile(filePath)
-	if (formatType != "JSON" && formatType != "YAML" && t != "d" && t != "a") || t == "" {
+	objects, errs := GetObjectsFromSpecfile(filePath, z)
+	if len(objects) < 1 {
 		utl.Die("File is not a properly defined role definition or assignment.\n")
 	}
-
-	if t == "d" {
-		azureDef := GetAzRoleDefinitionByObject(fileDef, z)
-		if azureDef == nil {
-			fileProp := fileDef["properties"].(map[string]interface{})
-			fileRoleName := utl.Str(fileProp["roleName"])
-			fmt.Printf("Role " + utl.Mag(fileRoleName) + " as defined in specfile does " + utl.Red("not") + " exist in Azure.\n")
-		} else {
-			fmt.Printf("Role definition in specfile " + utl.Gre("already") + " exist in Azure. See details below:\n")
-			DiffRoleDefinitionSpecfileVsAzure(fileDef, azureDef, z)
-		}
-	} else {
-		azureDef := GetAzRoleAssignmentByObject(fileDef, z)
-		if azureDef == nil {
-			fmt.Printf("Role assignment in specfile does " + utl.Red("not") + " exist in Azure.\n")
-		} else {
-			fmt.Printf("Role assignment in specfile " + utl.Gre("already") + " exist in Azure. See details below:\n")
-			PrintRoleAssignment(azureDef, z)
-		}
-	}
-	os.Exit(0)
+	exitWithSpecResults(processSpecObjects(objects, errs, func(o SpecObject) (string, error) {
+		return compareSpecObject(o, z)
+	}))
 }
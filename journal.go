@@ -0,0 +1,216 @@
+package maz
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/queone/utl"
+)
+
+// A single entry in the per-tenant change journal
+type ChangeEntry struct {
+	Time       string   `json:"time"`       // When the delta sync saw the change, RFC3339 UTC
+	ObjectType string   `json:"objectType"` // Azure object type name, e.g. "servicePrincipals"
+	Id         string   `json:"id"`
+	Change     string   `json:"change"` // One of "added", "updated" or "removed"
+	Fields     []string `json:"fields,omitempty"`
+}
+
+// Returns the path of the append-only change journal for the current tenant
+func ChangeJournalFile(z Bundle) string {
+	return filepath.Join(z.ConfDir, z.TenantId+"_changeJournal.jsonl")
+}
+
+// Records every object in a delta set as a change entry in the journal. Objects marked
+// '@removed' are recorded as removals, objects already in baseSet as updates, and all
+// others as additions. Only sets returned by a query that started from a stored deltaLink
+// are recorded, as fromDeltaLink says. Full queries, whether for an initial sync or because
+// the deltaLink was missing or expired, return every object in the tenant, which would
+// otherwise all show up as added or updated. Updates with no changed fields are skipped.
+func RecordDeltaChanges(t string, baseSet, deltaSet []interface{}, fromDeltaLink bool, z Bundle) {
+	if !fromDeltaLink || len(baseSet) < 1 || len(deltaSet) < 1 || z.CacheReadOnly {
+		return
+	}
+	baseObjs := make(map[string]map[string]interface{})
+	for _, i := range baseSet {
+		x := i.(map[string]interface{})
+		baseObjs[utl.Str(x["id"])] = x
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	objectType := cacheDescriptors[t].Name
+	var entries []ChangeEntry
+	for _, i := range deltaSet {
+		x := i.(map[string]interface{})
+		entry := ChangeEntry{Time: now, ObjectType: objectType, Id: utl.Str(x["id"])}
+		base, exists := baseObjs[entry.Id]
+		switch {
+		case x["@removed"] != nil:
+			entry.Change = "removed"
+		case exists:
+			entry.Change = "updated"
+			entry.Fields = changedFields(base, x)
+			if len(entry.Fields) < 1 {
+				continue // Nothing actually changed
+			}
+		default:
+			entry.Change = "added"
+			entry.Fields = changedFields(nil, x)
+		}
+		entries = append(entries, entry)
+	}
+	AppendChangeEntries(entries, z)
+}
+
+// Returns sorted list of attributes in delta object y whose values differ from base object x
+func changedFields(x, y map[string]interface{}) (fields []string) {
+	for k, v := range y {
+		if k == "id" || strings.HasPrefix(k, "@odata.") {
+			continue
+		}
+		if x != nil {
+			a, _ := json.Marshal(x[k])
+			b, _ := json.Marshal(v)
+			if string(a) == string(b) {
+				continue
+			}
+		}
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Appends given entries to the change journal, one JSON object per line
+func AppendChangeEntries(entries []ChangeEntry, z Bundle) {
+	if len(entries) < 1 {
+		return
+	}
+	f, err := os.OpenFile(ChangeJournalFile(z), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		utl.Die(utl.Trace() + err.Error() + "\n")
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			utl.Die(utl.Trace() + err.Error() + "\n")
+		}
+	}
+}
+
+// Returns all journal entries for maz type t recorded at or after 'since'. If t is the ""
+// empty string, entries for all types are returned.
+func GetChanges(t string, since time.Time, z Bundle) (list []ChangeEntry) {
+	f, err := os.Open(ChangeJournalFile(z))
+	if err != nil {
+		return nil // No journal yet
+	}
+	defer f.Close()
+	objectType := ""
+	if t != "" {
+		objectType = cacheDescriptors[t].Name
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry ChangeEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Skip corrupted lines
+		}
+		if objectType != "" && entry.ObjectType != objectType {
+			continue
+		}
+		when, err := time.Parse(time.RFC3339, entry.Time)
+		if err != nil || when.Before(since) {
+			continue
+		}
+		list = append(list, entry)
+	}
+	return list
+}
+
+// Parses a loose 'since' specifier into a point in time. Accepts RFC3339 timestamps,
+// yyyy-mm-dd dates, "today", "yesterday", weekday names such as "Tuesday" (meaning the
+// start of the most recent such day), and relative ages such as "12h" or "3d".
+func ParseSince(since string, now time.Time) (time.Time, error) {
+	s := strings.ToLower(strings.TrimSpace(since))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if when, err := time.Parse(time.RFC3339, since); err == nil {
+		return when, nil
+	}
+	if when, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return when, nil
+	}
+	switch s {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			daysBack := (int(now.Weekday()) - int(d) + 7) % 7
+			return today.AddDate(0, 0, -daysBack), nil
+		}
+	}
+	if len(s) > 1 {
+		if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n >= 0 {
+			switch s[len(s)-1] {
+			case 'h':
+				return now.Add(-time.Duration(n) * time.Hour), nil
+			case 'd':
+				return now.AddDate(0, 0, -n), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*n), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse '%s' as a point in time", since)
+}
+
+// Prints all journal changes for maz type t since given loose time specifier
+func PrintChanges(t, since string, z Bundle) {
+	when, err := ParseSince(since, time.Now())
+	if err != nil {
+		utl.Die("Error: %s\n", err.Error())
+	}
+	nameMaps := map[string]map[string]string{} // Lazily loaded id:name maps per object type
+	for _, entry := range GetChanges(t, when, z) {
+		if nameMaps[entry.ObjectType] == nil {
+			nameMaps[entry.ObjectType] = changeNameMap(entry.ObjectType, z)
+		}
+		name := nameMaps[entry.ObjectType][entry.Id]
+		change := utl.Gre(entry.Change)
+		switch entry.Change {
+		case "removed":
+			change = utl.Red(entry.Change)
+		case "added":
+			change = utl.Mag(entry.Change)
+		}
+		fmt.Printf("%s  %-18s  %s  %-8s  %-40s  %s\n", entry.Time, entry.ObjectType, entry.Id,
+			change, name, strings.Join(entry.Fields, ","))
+	}
+}
+
+// Returns id:name map for given object type name, to annotate journal entries
+func changeNameMap(objectType string, z Bundle) map[string]string {
+	switch objectType {
+	case "users":
+		return GetIdMapUsers(z)
+	case "groups":
+		return GetIdMapGroups(z)
+	case "servicePrincipals":
+		return GetIdMapSps(z)
+	case "applications":
+		return GetIdMapApps(z)
+	}
+	return map[string]string{}
+}
@@ -31,19 +31,32 @@ const (
 	ConstCacheFileExtension   = "gz"
 	ConstMgCacheFileAgePeriod = 1800  // Half hour
 	ConstAzCacheFileAgePeriod = 86400 // One day
+
+	ConstMazVersion = "1.1.0" // Recorded in cache file headers
+
+	ConstMaxConcurrency     = 8 // Default number of parallel API calls, see Bundle.MaxConcurrency
+	ConstMaxThrottleRetries = 5 // Times to retry an API call that Azure throttled
 )
 
 var (
-	mazTypes     = []string{"d", "a", "s", "u", "g", "sp", "ap", "ad"}
+	mazTypes     = []string{"d", "a", "s", "u", "g", "sp", "ap", "ad", "dn", "ca"}
 	mazTypesLong = map[string]string{
-		"d":  "RBAC Role Definition",
-		"a":  "RBAC Role Assignment",
-		"s":  "Azure Subscription",
-		"u":  "Azure AD User",
-		"g":  "Azure AD Group",
-		"sp": "Service Principal",
-		"ap": "Registered Application",
-		"ad": "Azure AD Role",
+		"d":   "RBAC Role Definition",
+		"a":   "RBAC Role Assignment",
+		"s":   "Azure Subscription",
+		"u":   "Azure AD User",
+		"g":   "Azure AD Group",
+		"sp":  "Service Principal",
+		"ap":  "Registered Application",
+		"ad":  "Azure AD Role",
+		"rg":  "Resource Group",
+		"rs":  "Azure Resource",
+		"ae":  "PIM Eligible Role Assignment",
+		"as":  "PIM Role Assignment Schedule",
+		"ada": "Azure AD Role Assignment",
+		"dn":  "RBAC Deny Assignment",
+		"ca":  "Classic Administrator",
+		"po":  "Provider Operation",
 	}
 	eVars = map[string]string{
 		"MAZ_TENANT_ID":     "",
@@ -57,19 +70,25 @@ var (
 )
 
 type Bundle struct {
-	ConfDir      string // Directory where utility will store all its file
-	CredsFile    string
-	TokenFile    string
-	TenantId     string
-	ClientId     string
-	ClientSecret string
-	Interactive  bool
-	Username     string
-	AuthorityUrl string
-	MgToken      string // This and below to support MS Graph API
-	MgHeaders    map[string]string
-	AzToken      string // This and below to support Azure Resource Management API
-	AzHeaders    map[string]string
+	ConfDir        string // Directory where utility will store all its file
+	CredsFile      string
+	TokenFile      string
+	TenantId       string
+	ClientId       string
+	ClientSecret   string
+	Interactive    bool
+	Username       string
+	AuthorityUrl   string
+	MgToken        string // This and below to support MS Graph API
+	MgHeaders      map[string]string
+	AzToken        string // This and below to support Azure Resource Management API
+	AzHeaders      map[string]string
+	CacheDir       string // Overrides ConfDir as the location of cache files, e.g. a snapshot
+	CacheReadOnly  bool   // When true, cache files are never refreshed from Azure nor rewritten
+	MaxConcurrency int    // Maximum number of parallel API calls. Zero means ConstMaxConcurrency
+	ScopeDepth     int    // How deep to search for RBAC assignments, see ConstScopeDepth* values
+	ValuesFile     string // Specfile template values file, overrides MAZ_VALUES_FILE
+	Overlay        string // Values file overlay to apply, overrides MAZ_OVERLAY. Defaults to TenantId
 	// To support other future APIs, those token/headers pairs can be added here
 }
 
@@ -92,6 +111,8 @@ func DumpLoginValues(z Bundle) {
 	fmt.Printf("  %s: %s\n", utl.Blu("MAZ_CLIENT_SECRET"), utl.Gre(os.Getenv("MAZ_CLIENT_SECRET")))
 	fmt.Printf("  %s: %s\n", utl.Blu("MAZ_MG_TOKEN"), utl.Gre(os.Getenv("MAZ_MG_TOKEN")))
 	fmt.Printf("  %s: %s\n", utl.Blu("MAZ_AZ_TOKEN"), utl.Gre(os.Getenv("MAZ_AZ_TOKEN")))
+	fmt.Printf("  %s: %s  # Specfile template values\n", utl.Blu("MAZ_VALUES_FILE"), utl.Gre(os.Getenv("MAZ_VALUES_FILE")))
+	fmt.Printf("  %s: %s  # Values file overlay, defaults to tenant id\n", utl.Blu("MAZ_OVERLAY"), utl.Gre(os.Getenv("MAZ_OVERLAY")))
 	fmt.Printf("%s:\n", utl.Blu("config_creds_file"))
 	filePath := filepath.Join(z.ConfDir, z.CredsFile)
 	fmt.Printf("  %s: %s\n", utl.Blu("file_path"), utl.Gre(filePath))
@@ -2,7 +2,7 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/queone/utl"
@@ -259,21 +259,13 @@ func RemoveAppSecret(uuid, keyId string, z Bundle) {
 
 // Retrieves count of all applications in local cache file
 func AppsCountLocal(z Bundle) int64 {
-	var cachedList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_applications."+ConstCacheFileExtension)
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-			return int64(len(cachedList))
-		}
-	}
-	return 0
+	cachedList := GetCachedObjects(CacheFile("ap", z))
+	return int64(len(cachedList))
 }
 
 // Retrieves count of all applications in Azure tenant
 func AppsCountAzure(z Bundle) int64 {
-	z.MgHeaders["ConsistencyLevel"] = "eventual"
+	z.MgHeaders = HeadersWith(z.MgHeaders, "ConsistencyLevel", "eventual")
 	//url := ConstMgUrl + "/v1.0/applications/$count"
 	url := ConstMgUrl + "/beta/applications/$count"
 	r, _, _ := ApiGet(url, z, nil)
@@ -300,10 +292,11 @@ func GetIdMapApps(z Bundle) (nameMap map[string]string) {
 
 // Gets all applications matching on 'filter'. Return entire list if filter is empty ""
 func GetMatchingApps(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_applications."+ConstCacheFileExtension)
+	MigrateCache("ap", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("ap", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstMgCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzApps(z, true)
@@ -331,18 +324,19 @@ func GetMatchingApps(filter string, force bool, z Bundle) (list []interface{}) {
 
 // Gets all applications from Azure and sync to local cache. Shows progress if verbose = true
 func GetAzApps(z Bundle, verbose bool) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_applications."+ConstCacheFileExtension)
-	deltaLinkFile := filepath.Join(z.ConfDir, z.TenantId+"_applications_deltaLink."+ConstCacheFileExtension)
+	MigrateCache("ap", z)
+	cacheFile := CacheFile("ap", z)
+	deltaLinkFile := DeltaLinkFile("ap", z)
 
 	baseUrl := ConstMgUrl + "/beta/applications"
 	// Get delta updates only if/when below attributes in $select are modified
-	selection := "?$select=displayName,appId,requiredResourceAccess,passwordCredentials"
+	selection := "?$select=" + strings.Join(cacheDescriptors["ap"].Select, ",")
 	url := baseUrl + "/delta" + selection + "&$top=999"
 	list = GetCachedObjects(cacheFile) // Get current cache
 	if len(list) < 1 {
 		// These are only needed on initial cache run
-		z.MgHeaders["Prefer"] = "return=minimal" // Tells API to focus only on $select attributes deltas
-		z.MgHeaders["deltaToken"] = "latest"
+		z.MgHeaders = HeadersWith(z.MgHeaders, "Prefer", "return=minimal") // Tells API to focus only on $select attributes deltas
+		z.MgHeaders = HeadersWith(z.MgHeaders, "deltaToken", "latest")
 		// https://graph.microsoft.com/v1.0/users/delta?$deltatoken=latest
 	}
 
@@ -357,13 +351,15 @@ func GetAzApps(z Bundle, verbose bool) (list []interface{}) {
 	}
 
 	// Now go get Azure objects using the updated URL (either a full or a delta query)
+	fromDeltaLink := deltaLinkMap != nil // Only a delta query returns just the changes
 	var deltaSet []interface{} = nil
 	deltaSet, deltaLinkMap = GetAzObjects(url, z, verbose) // Run generic deltaSet retriever function
 
 	// Save new deltaLink for future call, and merge newly acquired delta set with existing list
 	utl.SaveFileJsonGzip(deltaLinkMap, deltaLinkFile)
-	list = NormalizeCache(list, deltaSet) // Run our MERGE LOGIC with new delta set
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+	RecordDeltaChanges("ap", list, deltaSet, fromDeltaLink, z) // Journal the changes before merging them away
+	list = NormalizeCache(list, deltaSet)                      // Run our MERGE LOGIC with new delta set
+	SaveCachedObjects("ap", list, z)                           // Update the local cache
 	return list
 }
 
@@ -394,3 +390,13 @@ func GetAzAppByUuid(uuid string, z Bundle) map[string]interface{} {
 	}
 	return r
 }
+
+// Returns typed applications matching on filter. See GetMatchingApps.
+func ListApplications(filter string, force bool, z Bundle) ([]Application, error) {
+	return ToModelList[Application](GetMatchingApps(filter, force, z))
+}
+
+// Returns typed application with given UUID from Azure, or nil if not found
+func GetApplication(uuid string, z Bundle) (*Application, error) {
+	return toModelPtr[Application](GetAzAppByUuid(uuid, z))
+}
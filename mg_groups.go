@@ -2,7 +2,7 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
+	"strings"
 
 	"github.com/queone/utl"
 )
@@ -76,21 +76,13 @@ func PrintGroup(x map[string]interface{}, z Bundle) {
 
 // Returns number of group object entries in local cache file
 func GroupsCountLocal(z Bundle) int64 {
-	var cachedList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_groups."+ConstCacheFileExtension)
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-			return int64(len(cachedList))
-		}
-	}
-	return 0
+	cachedList := GetCachedObjects(CacheFile("g", z))
+	return int64(len(cachedList))
 }
 
 // Returns number of group object entries in Azure tenant
 func GroupsCountAzure(z Bundle) int64 {
-	z.MgHeaders["ConsistencyLevel"] = "eventual"
+	z.MgHeaders = HeadersWith(z.MgHeaders, "ConsistencyLevel", "eventual")
 	url := ConstMgUrl + "/v1.0/groups/$count"
 	r, _, _ := ApiGet(url, z, nil)
 	ApiErrorCheck("GET", url, utl.Trace(), r)
@@ -116,10 +108,11 @@ func GetIdMapGroups(z Bundle) (nameMap map[string]string) {
 
 // Gets all groups matching on 'filter'. Returns entire list if filter is empty ""
 func GetMatchingGroups(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_groups."+ConstCacheFileExtension)
+	MigrateCache("g", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("g", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstMgCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzGroups(z, true)
@@ -147,18 +140,19 @@ func GetMatchingGroups(filter string, force bool, z Bundle) (list []interface{})
 
 // Gets all groups from Azure and sync to local cache. Shows progress if verbose = true
 func GetAzGroups(z Bundle, verbose bool) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_groups."+ConstCacheFileExtension)
-	deltaLinkFile := filepath.Join(z.ConfDir, z.TenantId+"_groups_deltaLink."+ConstCacheFileExtension)
+	MigrateCache("g", z)
+	cacheFile := CacheFile("g", z)
+	deltaLinkFile := DeltaLinkFile("g", z)
 
 	baseUrl := ConstMgUrl + "/beta/groups"
 	// Get delta updates only if/when selection attributes are modified
-	selection := "?$select=displayName,description,isAssignableToRole"
+	selection := "?$select=" + strings.Join(cacheDescriptors["g"].Select, ",")
 	url := baseUrl + "/delta" + selection + "&$top=999"
 	list = GetCachedObjects(cacheFile) // Get current cache
 	if len(list) < 1 {
 		// These are only needed on initial cache run
-		z.MgHeaders["Prefer"] = "return=minimal" // Tells API to focus only on $select attributes deltas
-		z.MgHeaders["deltaToken"] = "latest"
+		z.MgHeaders = HeadersWith(z.MgHeaders, "Prefer", "return=minimal") // Tells API to focus only on $select attributes deltas
+		z.MgHeaders = HeadersWith(z.MgHeaders, "deltaToken", "latest")
 		// https://graph.microsoft.com/v1.0/users/delta?$deltatoken=latest
 	}
 
@@ -173,13 +167,15 @@ func GetAzGroups(z Bundle, verbose bool) (list []interface{}) {
 	}
 
 	// Now go get Azure objects using the updated URL (either a full or a delta query)
+	fromDeltaLink := deltaLinkMap != nil // Only a delta query returns just the changes
 	var deltaSet []interface{} = nil
 	deltaSet, deltaLinkMap = GetAzObjects(url, z, verbose) // Run generic deltaSet retriever function
 
 	// Save new deltaLink for future call, and merge newly acquired delta set with existing list
 	utl.SaveFileJsonGzip(deltaLinkMap, deltaLinkFile)
-	list = NormalizeCache(list, deltaSet) // Run our MERGE LOGIC with new delta set
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+	RecordDeltaChanges("g", list, deltaSet, fromDeltaLink, z) // Journal the changes before merging them away
+	list = NormalizeCache(list, deltaSet)                     // Run our MERGE LOGIC with new delta set
+	SaveCachedObjects("g", list, z)                           // Update the local cache
 	return list
 }
 
@@ -204,3 +200,33 @@ func PrintPags(z Bundle) {
 		}
 	}
 }
+
+// Returns typed groups matching on filter. See GetMatchingGroups.
+func ListGroups(filter string, force bool, z Bundle) ([]Group, error) {
+	return ToModelList[Group](GetMatchingGroups(filter, force, z))
+}
+
+// Returns typed group with given UUID from Azure, or nil if not found
+func GetGroup(uuid string, z Bundle) (*Group, error) {
+	return toModelPtr[Group](GetAzGroupByUuid(uuid, z))
+}
+
+// Returns the IDs of all groups given principal is a member of, directly or transitively.
+// Works for users, groups and service principals alike.
+func GetAzPrincipalGroupIds(principalId string, z Bundle) (groupIds []string) {
+	url := ConstMgUrl + "/v1.0/directoryObjects/" + principalId + "/getMemberGroups"
+	payload := map[string]interface{}{"securityEnabledOnly": false}
+	r, statusCode, _ := ApiPost(url, z, payload, nil)
+	if statusCode == 200 && r != nil && r["value"] != nil {
+		for _, i := range r["value"].([]interface{}) {
+			groupIds = append(groupIds, utl.Str(i))
+		}
+	}
+	return groupIds
+}
+
+// Returns all direct and nested members of given group
+func GetAzGroupTransitiveMembers(groupId string, z Bundle) (members []interface{}) {
+	url := ConstMgUrl + "/v1.0/groups/" + groupId + "/transitiveMembers?$select=id,displayName,userPrincipalName"
+	return GetAzAllPages(url, z)
+}
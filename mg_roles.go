@@ -2,7 +2,8 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/queone/utl"
 )
@@ -63,6 +64,27 @@ func PrintAdRole(x map[string]interface{}, z Bundle) {
 		}
 	}
 
+	// Print PIM eligible assignments, which the active assignments above do not include
+	params = map[string]string{
+		"$filter": "roleDefinitionId eq '" + utl.Str(x["templateId"]) + "'",
+		"$expand": "principal",
+	}
+	url = ConstMgUrl + "/v1.0/roleManagement/directory/roleEligibilitySchedules"
+	r, statusCode, _ = ApiGet(url, z, params)
+	if statusCode == 200 && r != nil && r["value"] != nil {
+		eligibilities := r["value"].([]interface{})
+		if len(eligibilities) > 0 {
+			fmt.Printf(utl.Blu("eligible") + ":\n")
+			for _, i := range eligibilities {
+				m := i.(map[string]interface{})
+				mPrinc, _ := m["principal"].(map[string]interface{})
+				pName := utl.Str(mPrinc["displayName"])
+				pType := utl.LastElem(utl.Str(mPrinc["@odata.type"]), ".")
+				fmt.Printf("  %-50s  %-10s  %s\n", utl.Gre(pName), utl.Gre(pType), utl.Gre(utl.Str(m["directoryScopeId"])))
+			}
+		}
+	}
+
 	// Print members of this role
 	// See https://github.com/microsoftgraph/microsoft-graph-docs/blob/main/api-reference/v1.0/api/directoryrole-list-members.md
 	// TODO: Fix 404 below for custom groups
@@ -86,16 +108,8 @@ func PrintAdRole(x map[string]interface{}, z Bundle) {
 
 // Returns count of Azure AD directory role entries in local cache file
 func AdRolesCountLocal(z Bundle) int64 {
-	var cachedList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_directoryRoles."+ConstCacheFileExtension)
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-			return int64(len(cachedList))
-		}
-	}
-	return 0
+	cachedList := GetCachedObjects(CacheFile("ad", z))
+	return int64(len(cachedList))
 }
 
 // Returns count of Azure AD directory role entries in current tenant
@@ -117,10 +131,11 @@ func AdRolesCountAzure(z Bundle) int64 {
 
 // Gets all AD roles matching on 'filter'. Returns entire list if filter is empty ""
 func GetMatchingAdRoles(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_directoryRoles."+ConstCacheFileExtension)
+	MigrateCache("ad", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("ad", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstMgCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzAdRoles(z, true)
@@ -148,8 +163,6 @@ func GetMatchingAdRoles(filter string, force bool, z Bundle) (list []interface{}
 
 // Gets all directory role definitions from Azure and sync to local cache. Shows progress if verbose = true
 func GetAzAdRoles(z Bundle, verbose bool) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_directoryRoles."+ConstCacheFileExtension)
-
 	// There's no API delta options for this object (too short a list?), so just one call
 
 	url := ConstMgUrl + "/beta/roleManagement/directory/roleDefinitions"
@@ -158,7 +171,7 @@ func GetAzAdRoles(z Bundle, verbose bool) (list []interface{}) {
 		return nil
 	}
 	list = r["value"].([]interface{})
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+	SaveCachedObjects("ad", list, z) // Update the local cache
 	return list
 }
 
@@ -171,3 +184,298 @@ func GetAzAdRoleByUuid(uuid string, z Bundle) map[string]interface{} {
 	r, _, _ := ApiGet(url, z, nil)
 	return r
 }
+
+// Returns typed directory role definitions matching on filter. See GetMatchingAdRoles.
+func ListDirectoryRoles(filter string, force bool, z Bundle) ([]DirectoryRole, error) {
+	return ToModelList[DirectoryRole](GetMatchingAdRoles(filter, force, z))
+}
+
+// Returns typed directory role definition with given UUID from Azure, or nil if not found
+func GetDirectoryRole(uuid string, z Bundle) (*DirectoryRole, error) {
+	return toModelPtr[DirectoryRole](GetAzAdRoleByUuid(uuid, z))
+}
+
+// Directory role PIM schedule kinds, as used in roleManagement/directory endpoint names
+const (
+	ConstAdRoleEligibility = "roleEligibility" // Eligible assignments, that must be activated to be used
+	ConstAdRoleAssignment  = "roleAssignment"  // Active assignments, permanent or time-bound
+)
+
+// Gets all directory role schedules of given kind (ConstAdRoleEligibility or ConstAdRoleAssignment),
+// with their principal and role definition expanded
+func GetAzAdRoleSchedules(kind string, z Bundle) (list []interface{}) {
+	url := ConstMgUrl + "/v1.0/roleManagement/directory/" + kind + "Schedules?$expand=principal,roleDefinition"
+	return GetAzAllPages(url, z)
+}
+
+// Converts a duration such as "8h" or "90m" into the ISO 8601 form MS Graph expects, "PT8H" or
+// "PT1H30M". Strings that already look like ISO 8601 durations are returned as-is.
+func isoDuration(duration string) (string, error) {
+	if strings.HasPrefix(strings.ToUpper(duration), "P") {
+		return strings.ToUpper(duration), nil
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil || d <= 0 {
+		return "", fmt.Errorf("invalid duration '%s'", duration)
+	}
+	iso := "PT"
+	if h := int(d.Hours()); h > 0 {
+		iso += fmt.Sprintf("%dH", h)
+	}
+	if m := int(d.Minutes()) % 60; m > 0 {
+		iso += fmt.Sprintf("%dM", m)
+	}
+	if iso == "PT" {
+		return "", fmt.Errorf("duration '%s' is shorter than a minute", duration)
+	}
+	return iso, nil
+}
+
+// Returns directory role definition ID for given role UUID or exact display name
+func resolveAdRoleId(role string, z Bundle) (string, error) {
+	if utl.ValidUuid(role) {
+		return role, nil
+	}
+	for _, i := range GetMatchingAdRoles(role, false, z) {
+		x := i.(map[string]interface{})
+		if strings.EqualFold(utl.Str(x["displayName"]), role) {
+			return utl.Str(x["id"]), nil
+		}
+	}
+	return "", fmt.Errorf("no directory role named '%s'", role)
+}
+
+// Submits a directory role schedule request of given kind and action, such as "adminAssign",
+// "adminRemove" or "selfActivate". An empty duration means no expiration, and an empty
+// directoryScopeId means the whole tenant, "/". Returns the created request object.
+func SubmitAzAdRoleScheduleRequest(kind, action, principalId, role, directoryScopeId, justification, duration string, z Bundle) (map[string]interface{}, error) {
+	roleId, err := resolveAdRoleId(role, z)
+	if err != nil {
+		return nil, err
+	}
+	if directoryScopeId == "" {
+		directoryScopeId = "/"
+	}
+	payload := map[string]interface{}{
+		"action":           action,
+		"principalId":      principalId,
+		"roleDefinitionId": roleId,
+		"directoryScopeId": directoryScopeId,
+		"justification":    justification,
+	}
+	if !strings.HasSuffix(action, "Remove") && !strings.HasSuffix(action, "Deactivate") {
+		expiration := map[string]interface{}{"type": "noExpiration"}
+		if duration != "" {
+			iso, err := isoDuration(duration)
+			if err != nil {
+				return nil, err
+			}
+			expiration = map[string]interface{}{"type": "afterDuration", "duration": iso}
+		}
+		payload["scheduleInfo"] = map[string]interface{}{
+			"startDateTime": time.Now().UTC().Format(time.RFC3339),
+			"expiration":    expiration,
+		}
+	}
+	url := ConstMgUrl + "/v1.0/roleManagement/directory/" + kind + "ScheduleRequests"
+	r, statusCode, _ := ApiPost(url, z, payload, nil)
+	if statusCode != 200 && statusCode != 201 {
+		if e, ok := r["error"].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("%s", utl.Str(e["message"]))
+		}
+		return nil, fmt.Errorf("%s request failed with status %d", kind, statusCode)
+	}
+	return r, nil
+}
+
+// Makes principal eligible for directory role, for given duration or permanently if empty
+func CreateAzAdRoleEligibility(principalId, role, directoryScopeId, justification, duration string, z Bundle) (map[string]interface{}, error) {
+	return SubmitAzAdRoleScheduleRequest(ConstAdRoleEligibility, "adminAssign", principalId, role, directoryScopeId, justification, duration, z)
+}
+
+// Removes principal's eligibility for directory role
+func RemoveAzAdRoleEligibility(principalId, role, directoryScopeId, justification string, z Bundle) (map[string]interface{}, error) {
+	return SubmitAzAdRoleScheduleRequest(ConstAdRoleEligibility, "adminRemove", principalId, role, directoryScopeId, justification, "", z)
+}
+
+// Actively assigns directory role to principal, for given duration or permanently if empty
+func CreateAzAdRoleActiveAssignment(principalId, role, directoryScopeId, justification, duration string, z Bundle) (map[string]interface{}, error) {
+	return SubmitAzAdRoleScheduleRequest(ConstAdRoleAssignment, "adminAssign", principalId, role, directoryScopeId, justification, duration, z)
+}
+
+// Removes principal's active assignment of directory role
+func RemoveAzAdRoleActiveAssignment(principalId, role, directoryScopeId, justification string, z Bundle) (map[string]interface{}, error) {
+	return SubmitAzAdRoleScheduleRequest(ConstAdRoleAssignment, "adminRemove", principalId, role, directoryScopeId, justification, "", z)
+}
+
+// Activates a directory role the signed-in user is eligible for, for given duration such as
+// "4h" or "PT4H". Most tenants require a justification and cap the duration via PIM policy.
+func ActivateAzAdRole(role, directoryScopeId, justification, duration string, z Bundle) (map[string]interface{}, error) {
+	if duration == "" {
+		return nil, fmt.Errorf("a duration is required to activate a role")
+	}
+	me, statusCode, _ := ApiGet(ConstMgUrl+"/v1.0/me?$select=id", z, nil)
+	if statusCode != 200 || me == nil || utl.Str(me["id"]) == "" {
+		return nil, fmt.Errorf("unable to get signed-in user; self activation needs a user login")
+	}
+	return SubmitAzAdRoleScheduleRequest(ConstAdRoleAssignment, "selfActivate", utl.Str(me["id"]), role, directoryScopeId, justification, duration, z)
+}
+
+// Deactivates a directory role the signed-in user previously activated
+func DeactivateAzAdRole(role, directoryScopeId string, z Bundle) (map[string]interface{}, error) {
+	me, statusCode, _ := ApiGet(ConstMgUrl+"/v1.0/me?$select=id", z, nil)
+	if statusCode != 200 || me == nil || utl.Str(me["id"]) == "" {
+		return nil, fmt.Errorf("unable to get signed-in user; self deactivation needs a user login")
+	}
+	return SubmitAzAdRoleScheduleRequest(ConstAdRoleAssignment, "selfDeactivate", utl.Str(me["id"]), role, directoryScopeId, "", "", z)
+}
+
+// Prints all eligible and active directory role schedules
+func PrintAdRoleSchedules(z Bundle) {
+	for _, kind := range []string{ConstAdRoleEligibility, ConstAdRoleAssignment} {
+		label := "eligible"
+		if kind == ConstAdRoleAssignment {
+			label = "active"
+		}
+		for _, i := range GetAzAdRoleSchedules(kind, z) {
+			x := i.(map[string]interface{})
+			principal, _ := x["principal"].(map[string]interface{})
+			roleDef, _ := x["roleDefinition"].(map[string]interface{})
+			end := "permanent"
+			if info, ok := x["scheduleInfo"].(map[string]interface{}); ok {
+				if exp, ok := info["expiration"].(map[string]interface{}); ok && utl.Str(exp["endDateTime"]) != "" {
+					end = utl.Str(exp["endDateTime"])
+				}
+			}
+			fmt.Printf("%-8s  %-40s  %-40s  %-20s  %s\n", utl.Gre(label), utl.Gre(utl.Str(roleDef["displayName"])),
+				utl.Gre(utl.Str(principal["displayName"])), utl.Gre(end), utl.Gre(utl.Str(x["directoryScopeId"])))
+		}
+	}
+}
+
+// Returns principalId, role and directoryScopeId from a directory role assignment specfile
+// object. Role may be a UUID or a display name. Scope defaults to the whole tenant, "/".
+func adAssignmentSpecAttributes(x map[string]interface{}) (principalId, role, directoryScopeId string) {
+	principalId = utl.Str(x["principalId"])
+	role = utl.Str(x["roleDefinitionId"])
+	directoryScopeId = utl.Str(x["directoryScopeId"])
+	if principalId == "" || role == "" {
+		utl.Die("Specfile is missing required attributes. Need at least:\n\n" +
+			"principalId: <UUID>\n" +
+			"roleDefinitionId: <UUID or role displayName>\n" +
+			"directoryScopeId: <'/' or /administrativeUnits/UUID>\n\n" +
+			"See script '-k*' options to create properly formatted sample files.\n")
+	}
+	if directoryScopeId == "" {
+		directoryScopeId = "/"
+	}
+	return principalId, role, directoryScopeId
+}
+
+// Prints directory role assignment object in YAML-like format
+func PrintAdRoleAssignment(x map[string]interface{}, z Bundle) {
+	if x == nil {
+		return
+	}
+	roleNameMap := make(map[string]string)
+	for _, i := range GetMatchingAdRoles("", false, z) {
+		r := i.(map[string]interface{})
+		roleNameMap[utl.Str(r["id"])] = utl.Str(r["displayName"])
+	}
+	roleId := utl.Str(x["roleDefinitionId"])
+	fmt.Printf("%s: %s\n", utl.Blu("id"), utl.Gre(utl.Str(x["id"])))
+	fmt.Printf("%s: %s\n", utl.Blu("principalId"), utl.Gre(utl.Str(x["principalId"])))
+	fmt.Printf("%s: %s  # Role \"%s\"\n", utl.Blu("roleDefinitionId"), utl.Gre(roleId), roleNameMap[roleId])
+	fmt.Printf("%s: %s\n", utl.Blu("directoryScopeId"), utl.Gre(utl.Str(x["directoryScopeId"])))
+}
+
+// Assigns directory role to a user, group or SP, at tenant scope "/" or at an administrative
+// unit scope "/administrativeUnits/<UUID>". Role may be a UUID or a display name.
+func AssignAzAdRole(principalId, role, directoryScopeId string, z Bundle) (map[string]interface{}, error) {
+	roleId, err := resolveAdRoleId(role, z)
+	if err != nil {
+		return nil, err
+	}
+	if directoryScopeId == "" {
+		directoryScopeId = "/"
+	}
+	payload := map[string]interface{}{
+		"principalId":      principalId,
+		"roleDefinitionId": roleId,
+		"directoryScopeId": directoryScopeId,
+	}
+	url := ConstMgUrl + "/v1.0/roleManagement/directory/roleAssignments"
+	r, statusCode, _ := ApiPost(url, z, payload, nil)
+	if statusCode != 200 && statusCode != 201 {
+		if e, ok := r["error"].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("%s", utl.Str(e["message"]))
+		}
+		return nil, fmt.Errorf("role assignment failed with status %d", statusCode)
+	}
+	return r, nil
+}
+
+// Gets the directory role assignment for principal, role and scope, or nil if there is none
+func GetAzAdRoleAssignment(principalId, role, directoryScopeId string, z Bundle) map[string]interface{} {
+	roleId, err := resolveAdRoleId(role, z)
+	if err != nil {
+		return nil
+	}
+	if directoryScopeId == "" {
+		directoryScopeId = "/"
+	}
+	params := map[string]string{
+		"$filter": "principalId eq '" + principalId + "' and roleDefinitionId eq '" + roleId + "'",
+	}
+	url := ConstMgUrl + "/v1.0/roleManagement/directory/roleAssignments"
+	r, statusCode, _ := ApiGet(url, z, params)
+	if statusCode == 200 && r != nil && r["value"] != nil {
+		for _, i := range r["value"].([]interface{}) {
+			x := i.(map[string]interface{})
+			if strings.EqualFold(utl.Str(x["directoryScopeId"]), directoryScopeId) {
+				return x
+			}
+		}
+	}
+	return nil
+}
+
+// Removes directory role assignment by its ID
+func DeleteAzAdRoleAssignmentById(id string, z Bundle) error {
+	url := ConstMgUrl + "/v1.0/roleManagement/directory/roleAssignments/" + id
+	r, statusCode, _ := ApiDelete(url, z, nil)
+	if statusCode != 200 && statusCode != 204 {
+		if e, ok := r["error"].(map[string]interface{}); ok {
+			return fmt.Errorf("%s", utl.Str(e["message"]))
+		}
+		return fmt.Errorf("role assignment removal failed with status %d", statusCode)
+	}
+	return nil
+}
+
+// Unassigns directory role from principal at given scope
+func UnassignAzAdRole(principalId, role, directoryScopeId string, z Bundle) error {
+	x := GetAzAdRoleAssignment(principalId, role, directoryScopeId, z)
+	if x == nil {
+		return fmt.Errorf("directory role assignment does not exist")
+	}
+	return DeleteAzAdRoleAssignmentById(utl.Str(x["id"]), z)
+}
+
+// Creates directory role assignment as defined by specfile object x
+func CreateAzAdRoleAssignment(x map[string]interface{}, z Bundle) error {
+	principalId, role, directoryScopeId := adAssignmentSpecAttributes(x)
+	r, err := AssignAzAdRole(principalId, role, directoryScopeId, z)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	utl.PrintYaml(r)
+	return nil
+}
+
+// Gets the directory role assignment matching specfile object x, or nil if there is none
+func GetAzAdRoleAssignmentByObject(x map[string]interface{}, z Bundle) map[string]interface{} {
+	principalId, role, directoryScopeId := adAssignmentSpecAttributes(x)
+	return GetAzAdRoleAssignment(principalId, role, directoryScopeId, z)
+}
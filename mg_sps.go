@@ -2,7 +2,6 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -291,11 +290,10 @@ func RemoveSpSecret(uuid, keyId string, z Bundle) {
 func SpsCountLocal(z Bundle) (native, microsoft int64) {
 	var nativeList []interface{} = nil
 	var microsoftList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_servicePrincipals."+ConstCacheFileExtension)
+	cacheFile := CacheFile("sp", z)
 	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList := rawList.([]interface{})
+		cachedList := GetCachedObjects(cacheFile)
+		if cachedList != nil {
 			for _, i := range cachedList {
 				x := i.(map[string]interface{})
 				if utl.Str(x["appOwnerOrganizationId"]) == z.TenantId { // If owned by current tenant ...
@@ -314,7 +312,7 @@ func SpsCountLocal(z Bundle) (native, microsoft int64) {
 func SpsCountAzure(z Bundle) (native, microsoft int64) {
 	// First, get total number of SPs in tenant
 	var all int64 = 0
-	z.MgHeaders["ConsistencyLevel"] = "eventual"
+	z.MgHeaders = HeadersWith(z.MgHeaders, "ConsistencyLevel", "eventual")
 	//baseUrl := ConstMgUrl + "/v1.0/servicePrincipals"
 	baseUrl := ConstMgUrl + "/beta/servicePrincipals"
 	url := baseUrl + "/$count"
@@ -357,10 +355,11 @@ func GetIdMapSps(z Bundle) (nameMap map[string]string) {
 
 // Gets all service principals matching on 'filter'. Return entire list if filter is empty ""
 func GetMatchingSps(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_servicePrincipals."+ConstCacheFileExtension)
+	MigrateCache("sp", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("sp", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstMgCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzSps(z, true)
@@ -388,18 +387,19 @@ func GetMatchingSps(filter string, force bool, z Bundle) (list []interface{}) {
 
 // Gets all service principals from Azure and sync to local cache. Shows progress if verbose = true
 func GetAzSps(z Bundle, verbose bool) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_servicePrincipals."+ConstCacheFileExtension)
-	deltaLinkFile := filepath.Join(z.ConfDir, z.TenantId+"_servicePrincipals_deltaLink."+ConstCacheFileExtension)
+	MigrateCache("sp", z)
+	cacheFile := CacheFile("sp", z)
+	deltaLinkFile := DeltaLinkFile("sp", z)
 
 	baseUrl := ConstMgUrl + "/beta/servicePrincipals"
 	// Get delta updates only if/when below attributes in $select are modified
-	selection := "?$select=displayName,appId,accountEnabled,appOwnerOrganizationId,passwordCredentials"
+	selection := "?$select=" + strings.Join(cacheDescriptors["sp"].Select, ",")
 	url := baseUrl + "/delta" + selection + "&$top=999"
 	list = GetCachedObjects(cacheFile) // Get current cache
 	if len(list) < 1 {
 		// These are only needed on initial cache run
-		z.MgHeaders["Prefer"] = "return=minimal" // Tells API to focus only on $select attributes deltas
-		z.MgHeaders["deltaToken"] = "latest"
+		z.MgHeaders = HeadersWith(z.MgHeaders, "Prefer", "return=minimal") // Tells API to focus only on $select attributes deltas
+		z.MgHeaders = HeadersWith(z.MgHeaders, "deltaToken", "latest")
 		// https://graph.microsoft.com/v1.0/users/delta?$deltatoken=latest
 	}
 
@@ -414,13 +414,15 @@ func GetAzSps(z Bundle, verbose bool) (list []interface{}) {
 	}
 
 	// Now go get Azure objects using the updated URL (either a full or a delta query)
+	fromDeltaLink := deltaLinkMap != nil // Only a delta query returns just the changes
 	var deltaSet []interface{} = nil
 	deltaSet, deltaLinkMap = GetAzObjects(url, z, verbose) // Run generic deltaSet retriever function
 
 	// Save new deltaLink for future call, and merge newly acquired delta set with existing list
 	utl.SaveFileJsonGzip(deltaLinkMap, deltaLinkFile)
-	list = NormalizeCache(list, deltaSet) // Run our MERGE LOGIC with new delta set
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+	RecordDeltaChanges("sp", list, deltaSet, fromDeltaLink, z) // Journal the changes before merging them away
+	list = NormalizeCache(list, deltaSet)                      // Run our MERGE LOGIC with new delta set
+	SaveCachedObjects("sp", list, z)                           // Update the local cache
 	return list
 }
 
@@ -451,3 +453,13 @@ func GetAzSpByUuid(uuid string, z Bundle) map[string]interface{} {
 	}
 	return r
 }
+
+// Returns typed service principals matching on filter. See GetMatchingSps.
+func ListServicePrincipals(filter string, force bool, z Bundle) ([]ServicePrincipal, error) {
+	return ToModelList[ServicePrincipal](GetMatchingSps(filter, force, z))
+}
+
+// Returns typed service principal with given UUID from Azure, or nil if not found
+func GetServicePrincipal(uuid string, z Bundle) (*ServicePrincipal, error) {
+	return toModelPtr[ServicePrincipal](GetAzSpByUuid(uuid, z))
+}
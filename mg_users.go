@@ -2,7 +2,7 @@ package maz
 
 import (
 	"fmt"
-	"path/filepath"
+	"strings"
 
 	"github.com/queone/utl"
 )
@@ -39,21 +39,13 @@ func PrintUser(x map[string]interface{}, z Bundle) {
 
 // Returns the number of entries in local cache file
 func UsersCountLocal(z Bundle) int64 {
-	var cachedList []interface{} = nil
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_users."+ConstCacheFileExtension)
-	if utl.FileUsable(cacheFile) {
-		rawList, _ := utl.LoadFileJsonGzip(cacheFile)
-		if rawList != nil {
-			cachedList = rawList.([]interface{})
-			return int64(len(cachedList))
-		}
-	}
-	return 0
+	cachedList := GetCachedObjects(CacheFile("u", z))
+	return int64(len(cachedList))
 }
 
 // Returns the number of entries in Azure tenant
 func UsersCountAzure(z Bundle) int64 {
-	z.MgHeaders["ConsistencyLevel"] = "eventual"
+	z.MgHeaders = HeadersWith(z.MgHeaders, "ConsistencyLevel", "eventual")
 	url := ConstMgUrl + "/v1.0/users/$count"
 	r, _, _ := ApiGet(url, z, nil)
 	ApiErrorCheck("GET", url, utl.Trace(), r)
@@ -79,10 +71,11 @@ func GetIdMapUsers(z Bundle) (nameMap map[string]string) {
 
 // Gets all users matching on 'filter'. Returns entire list if filter is empty ""
 func GetMatchingUsers(filter string, force bool, z Bundle) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_users."+ConstCacheFileExtension)
+	MigrateCache("u", z) // Rebuild or migrate cache if its schema is outdated
+	cacheFile := CacheFile("u", z)
 	cacheFileAge := utl.FileAge(cacheFile)
-	if utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
-		// If Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
+	if !z.CacheReadOnly && utl.InternetIsAvailable() && (force || cacheFileAge == 0 || cacheFileAge > ConstMgCacheFileAgePeriod) {
+		// If cache is writable AND Internet is available AND (force was requested OR cacheFileAge is zero (meaning does not exist)
 		// OR it is older than ConstMgCacheFileAgePeriod) then query Azure directly to get all objects
 		// and show progress while doing so (true = verbose below)
 		list = GetAzUsers(z, true)
@@ -110,18 +103,19 @@ func GetMatchingUsers(filter string, force bool, z Bundle) (list []interface{})
 
 // Gets all users from Azure and sync to local cache. Show progress if verbose = true
 func GetAzUsers(z Bundle, verbose bool) (list []interface{}) {
-	cacheFile := filepath.Join(z.ConfDir, z.TenantId+"_users."+ConstCacheFileExtension)
-	deltaLinkFile := filepath.Join(z.ConfDir, z.TenantId+"_users_deltaLink."+ConstCacheFileExtension)
+	MigrateCache("u", z)
+	cacheFile := CacheFile("u", z)
+	deltaLinkFile := DeltaLinkFile("u", z)
 
 	baseUrl := ConstMgUrl + "/beta/users"
 	// Get delta updates only if/when selection attributes are modified
-	selection := "?$select=displayName,userPrincipalName,onPremisesSamAccountName"
+	selection := "?$select=" + strings.Join(cacheDescriptors["u"].Select, ",")
 	url := baseUrl + "/delta" + selection + "&$top=999"
 	list = GetCachedObjects(cacheFile) // Get current cache
 	if len(list) < 1 {
 		// These are only needed on initial cache run
-		z.MgHeaders["Prefer"] = "return=minimal" // Tells API to focus only on $select attributes deltas
-		z.MgHeaders["deltaToken"] = "latest"
+		z.MgHeaders = HeadersWith(z.MgHeaders, "Prefer", "return=minimal") // Tells API to focus only on $select attributes deltas
+		z.MgHeaders = HeadersWith(z.MgHeaders, "deltaToken", "latest")
 		// https://graph.microsoft.com/v1.0/users/delta?$deltatoken=latest
 	}
 
@@ -136,13 +130,15 @@ func GetAzUsers(z Bundle, verbose bool) (list []interface{}) {
 	}
 
 	// Now go get Azure objects using the updated URL (either a full or a delta query)
+	fromDeltaLink := deltaLinkMap != nil // Only a delta query returns just the changes
 	var deltaSet []interface{} = nil
 	deltaSet, deltaLinkMap = GetAzObjects(url, z, verbose) // Run generic deltaSet retriever function
 
 	// Save new deltaLink for future call, and merge newly acquired delta set with existing list
 	utl.SaveFileJsonGzip(deltaLinkMap, deltaLinkFile)
-	list = NormalizeCache(list, deltaSet) // Run our MERGE LOGIC with new delta set
-	utl.SaveFileJsonGzip(list, cacheFile) // Update the local cache
+	RecordDeltaChanges("u", list, deltaSet, fromDeltaLink, z) // Journal the changes before merging them away
+	list = NormalizeCache(list, deltaSet)                     // Run our MERGE LOGIC with new delta set
+	SaveCachedObjects("u", list, z)                           // Update the local cache
 	return list
 }
 
@@ -154,3 +150,13 @@ func GetAzUserByUuid(uuid string, z Bundle) map[string]interface{} {
 	r, _, _ := ApiGet(url, z, nil)
 	return r
 }
+
+// Returns typed users matching on filter. See GetMatchingUsers.
+func ListUsers(filter string, force bool, z Bundle) ([]User, error) {
+	return ToModelList[User](GetMatchingUsers(filter, force, z))
+}
+
+// Returns typed user with given UUID from Azure, or nil if not found
+func GetUser(uuid string, z Bundle) (*User, error) {
+	return toModelPtr[User](GetAzUserByUuid(uuid, z))
+}
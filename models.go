@@ -0,0 +1,446 @@
+package maz
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Typed models of the objects maz works with. Each one only spells out the attributes maz
+// commonly uses. Anything else Azure returns is kept in Unknown, and explicit nulls and empty
+// values are remembered, so that decoding and re-encoding an object never loses anything.
+// Convert to and from the untyped map[string]interface{} form with ToModel and FromModel.
+
+// Attributes that a typed model does not know about, embedded in every model
+type JsonExtra struct {
+	Unknown map[string]json.RawMessage `json:"-"` // Unmodeled attributes, kept as-is
+	present map[string]json.RawMessage // All attributes originally decoded, to restore nulls
+}
+
+type RoleDefinition struct {
+	Id         string                   `json:"id"`
+	Name       string                   `json:"name"`
+	Type       string                   `json:"type"`
+	Properties RoleDefinitionProperties `json:"properties"`
+	JsonExtra
+}
+
+type RoleDefinitionProperties struct {
+	RoleName         string           `json:"roleName"`
+	Description      string           `json:"description"`
+	Type             string           `json:"type"` // "BuiltInRole" or "CustomRole"
+	Permissions      []RolePermission `json:"permissions"`
+	AssignableScopes []string         `json:"assignableScopes"`
+	CreatedOn        string           `json:"createdOn"`
+	UpdatedOn        string           `json:"updatedOn"`
+	CreatedBy        string           `json:"createdBy"`
+	UpdatedBy        string           `json:"updatedBy"`
+	JsonExtra
+}
+
+type RolePermission struct {
+	Actions        []string `json:"actions"`
+	NotActions     []string `json:"notActions"`
+	DataActions    []string `json:"dataActions"`
+	NotDataActions []string `json:"notDataActions"`
+	JsonExtra
+}
+
+type RoleAssignment struct {
+	Id         string                   `json:"id"`
+	Name       string                   `json:"name"`
+	Type       string                   `json:"type"`
+	Properties RoleAssignmentProperties `json:"properties"`
+	JsonExtra
+}
+
+type RoleAssignmentProperties struct {
+	RoleDefinitionId                   string `json:"roleDefinitionId"`
+	PrincipalId                        string `json:"principalId"`
+	PrincipalType                      string `json:"principalType"`
+	Scope                              string `json:"scope"`
+	Description                        string `json:"description"`
+	Condition                          string `json:"condition"`
+	ConditionVersion                   string `json:"conditionVersion"`
+	DelegatedManagedIdentityResourceId string `json:"delegatedManagedIdentityResourceId"`
+	CreatedOn                          string `json:"createdOn"`
+	UpdatedOn                          string `json:"updatedOn"`
+	CreatedBy                          string `json:"createdBy"`
+	UpdatedBy                          string `json:"updatedBy"`
+	JsonExtra
+}
+
+type Subscription struct {
+	Id                  string `json:"id"`
+	SubscriptionId      string `json:"subscriptionId"`
+	TenantId            string `json:"tenantId"`
+	DisplayName         string `json:"displayName"`
+	State               string `json:"state"`
+	AuthorizationSource string `json:"authorizationSource"`
+	JsonExtra
+}
+
+type ManagementGroup struct {
+	Id         string                    `json:"id"`
+	Name       string                    `json:"name"`
+	Type       string                    `json:"type"`
+	Properties ManagementGroupProperties `json:"properties"`
+	JsonExtra
+}
+
+type ManagementGroupProperties struct {
+	TenantId    string `json:"tenantId"`
+	DisplayName string `json:"displayName"`
+	JsonExtra
+}
+
+type User struct {
+	Id                       string `json:"id"`
+	DisplayName              string `json:"displayName"`
+	UserPrincipalName        string `json:"userPrincipalName"`
+	OnPremisesSamAccountName string `json:"onPremisesSamAccountName"`
+	Mail                     string `json:"mail"`
+	AccountEnabled           bool   `json:"accountEnabled"`
+	UserType                 string `json:"userType"`
+	JsonExtra
+}
+
+type Group struct {
+	Id                 string   `json:"id"`
+	DisplayName        string   `json:"displayName"`
+	Description        string   `json:"description"`
+	IsAssignableToRole bool     `json:"isAssignableToRole"`
+	Mail               string   `json:"mail"`
+	MailEnabled        bool     `json:"mailEnabled"`
+	SecurityEnabled    bool     `json:"securityEnabled"`
+	GroupTypes         []string `json:"groupTypes"`
+	JsonExtra
+}
+
+type ServicePrincipal struct {
+	Id                     string               `json:"id"`
+	DisplayName            string               `json:"displayName"`
+	AppId                  string               `json:"appId"`
+	AccountEnabled         bool                 `json:"accountEnabled"`
+	AppOwnerOrganizationId string               `json:"appOwnerOrganizationId"`
+	ServicePrincipalType   string               `json:"servicePrincipalType"`
+	PasswordCredentials    []PasswordCredential `json:"passwordCredentials"`
+	JsonExtra
+}
+
+type Application struct {
+	Id                     string                   `json:"id"`
+	DisplayName            string                   `json:"displayName"`
+	AppId                  string                   `json:"appId"`
+	SignInAudience         string                   `json:"signInAudience"`
+	RequiredResourceAccess []RequiredResourceAccess `json:"requiredResourceAccess"`
+	PasswordCredentials    []PasswordCredential     `json:"passwordCredentials"`
+	JsonExtra
+}
+
+type PasswordCredential struct {
+	KeyId         string `json:"keyId"`
+	DisplayName   string `json:"displayName"`
+	Hint          string `json:"hint"`
+	StartDateTime string `json:"startDateTime"`
+	EndDateTime   string `json:"endDateTime"`
+	SecretText    string `json:"secretText"`
+	JsonExtra
+}
+
+type RequiredResourceAccess struct {
+	ResourceAppId  string           `json:"resourceAppId"`
+	ResourceAccess []ResourceAccess `json:"resourceAccess"`
+	JsonExtra
+}
+
+type ResourceAccess struct {
+	Id   string `json:"id"`
+	Type string `json:"type"` // "Scope" or "Role"
+	JsonExtra
+}
+
+// Entra ID (Azure AD) directory role definition
+type DirectoryRole struct {
+	Id              string                    `json:"id"`
+	DisplayName     string                    `json:"displayName"`
+	Description     string                    `json:"description"`
+	TemplateId      string                    `json:"templateId"`
+	IsBuiltIn       bool                      `json:"isBuiltIn"`
+	IsEnabled       bool                      `json:"isEnabled"`
+	RolePermissions []DirectoryRolePermission `json:"rolePermissions"`
+	JsonExtra
+}
+
+type DirectoryRolePermission struct {
+	AllowedResourceActions []string `json:"allowedResourceActions"`
+	Condition              string   `json:"condition"`
+	JsonExtra
+}
+
+// Each model decodes and encodes through an alias type without methods, to avoid recursion
+
+func (x *RoleDefinition) UnmarshalJSON(b []byte) error {
+	type plain RoleDefinition
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x RoleDefinition) MarshalJSON() ([]byte, error) {
+	type plain RoleDefinition
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *RoleDefinitionProperties) UnmarshalJSON(b []byte) error {
+	type plain RoleDefinitionProperties
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x RoleDefinitionProperties) MarshalJSON() ([]byte, error) {
+	type plain RoleDefinitionProperties
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *RolePermission) UnmarshalJSON(b []byte) error {
+	type plain RolePermission
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x RolePermission) MarshalJSON() ([]byte, error) {
+	type plain RolePermission
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *RoleAssignment) UnmarshalJSON(b []byte) error {
+	type plain RoleAssignment
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x RoleAssignment) MarshalJSON() ([]byte, error) {
+	type plain RoleAssignment
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *RoleAssignmentProperties) UnmarshalJSON(b []byte) error {
+	type plain RoleAssignmentProperties
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x RoleAssignmentProperties) MarshalJSON() ([]byte, error) {
+	type plain RoleAssignmentProperties
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *Subscription) UnmarshalJSON(b []byte) error {
+	type plain Subscription
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x Subscription) MarshalJSON() ([]byte, error) {
+	type plain Subscription
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *ManagementGroup) UnmarshalJSON(b []byte) error {
+	type plain ManagementGroup
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x ManagementGroup) MarshalJSON() ([]byte, error) {
+	type plain ManagementGroup
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *ManagementGroupProperties) UnmarshalJSON(b []byte) error {
+	type plain ManagementGroupProperties
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x ManagementGroupProperties) MarshalJSON() ([]byte, error) {
+	type plain ManagementGroupProperties
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *User) UnmarshalJSON(b []byte) error {
+	type plain User
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x User) MarshalJSON() ([]byte, error) {
+	type plain User
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *Group) UnmarshalJSON(b []byte) error {
+	type plain Group
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x Group) MarshalJSON() ([]byte, error) {
+	type plain Group
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *ServicePrincipal) UnmarshalJSON(b []byte) error {
+	type plain ServicePrincipal
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x ServicePrincipal) MarshalJSON() ([]byte, error) {
+	type plain ServicePrincipal
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *Application) UnmarshalJSON(b []byte) error {
+	type plain Application
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x Application) MarshalJSON() ([]byte, error) {
+	type plain Application
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *PasswordCredential) UnmarshalJSON(b []byte) error {
+	type plain PasswordCredential
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x PasswordCredential) MarshalJSON() ([]byte, error) {
+	type plain PasswordCredential
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *RequiredResourceAccess) UnmarshalJSON(b []byte) error {
+	type plain RequiredResourceAccess
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x RequiredResourceAccess) MarshalJSON() ([]byte, error) {
+	type plain RequiredResourceAccess
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *ResourceAccess) UnmarshalJSON(b []byte) error {
+	type plain ResourceAccess
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x ResourceAccess) MarshalJSON() ([]byte, error) {
+	type plain ResourceAccess
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *DirectoryRole) UnmarshalJSON(b []byte) error {
+	type plain DirectoryRole
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x DirectoryRole) MarshalJSON() ([]byte, error) {
+	type plain DirectoryRole
+	return marshalModel(plain(x), x.JsonExtra)
+}
+func (x *DirectoryRolePermission) UnmarshalJSON(b []byte) error {
+	type plain DirectoryRolePermission
+	return unmarshalModel(b, (*plain)(x), &x.JsonExtra)
+}
+func (x DirectoryRolePermission) MarshalJSON() ([]byte, error) {
+	type plain DirectoryRolePermission
+	return marshalModel(plain(x), x.JsonExtra)
+}
+
+// Returns the JSON attribute names of the modeled fields of struct type t
+func modelFieldNames(t reflect.Type) (names []string, index [][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous || !f.IsExported() {
+			continue // The embedded JsonExtra
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+		index = append(index, f.Index)
+	}
+	return names, index
+}
+
+// Decodes JSON object b into model v, keeping unmodeled attributes in extra
+func unmarshalModel(b []byte, v interface{}, extra *JsonExtra) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil || all == nil {
+		return err // Also covers a plain JSON null
+	}
+	names, _ := modelFieldNames(reflect.TypeOf(v).Elem())
+	extra.present = all
+	extra.Unknown = make(map[string]json.RawMessage)
+	for k, raw := range all {
+		extra.Unknown[k] = raw
+	}
+	for _, name := range names {
+		delete(extra.Unknown, name)
+	}
+	return nil
+}
+
+// Encodes model v as a JSON object, merging back in any unmodeled attributes. Zero valued
+// fields are left out, unless they were present when the object was decoded, in which case
+// their original null or empty value is restored.
+func marshalModel(v interface{}, extra JsonExtra) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	names, index := modelFieldNames(rv.Type())
+	out := make(map[string]json.RawMessage)
+	for i, name := range names {
+		fv := rv.FieldByIndex(index[i])
+		orig, wasPresent := extra.present[name]
+		if fv.IsZero() {
+			if !wasPresent {
+				continue
+			}
+			if rawIsZero(orig) {
+				out[name] = orig // Restore original null, "", false, [] or {}
+				continue
+			}
+		}
+		b, err := json.Marshal(fv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		out[name] = b
+	}
+	for k, raw := range extra.Unknown {
+		if _, modeled := out[k]; !modeled {
+			out[k] = raw
+		}
+	}
+	return json.Marshal(out)
+}
+
+// Returns true if raw JSON value is null, or an empty or zero value
+func rawIsZero(raw json.RawMessage) bool {
+	switch string(bytes.TrimSpace(raw)) {
+	case "null", `""`, "false", "0", "[]", "{}":
+		return true
+	}
+	return false
+}
+
+// Converts an untyped object, as returned by the GetMatching* and GetAz* functions, into
+// typed model T. Returns an error instead of panicking if the object has an unexpected shape.
+func ToModel[T any](x interface{}) (model T, err error) {
+	b, err := json.Marshal(x)
+	if err != nil {
+		return model, err
+	}
+	err = json.Unmarshal(b, &model)
+	return model, err
+}
+
+// Converts a list of untyped objects into a list of typed models T
+func ToModelList[T any](list []interface{}) (models []T, err error) {
+	models = make([]T, 0, len(list))
+	for _, i := range list {
+		model, err := ToModel[T](i)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// Converts typed model back into the untyped object form the rest of maz uses
+func FromModel(model interface{}) (x map[string]interface{}, err error) {
+	b, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &x)
+	return x, err
+}
+
+// Converts an untyped object returned by a GetAz*ByUuid function into typed model T. Returns
+// nil if the object was not found, which includes the error objects the API returns for it.
+func toModelPtr[T any](x map[string]interface{}) (*T, error) {
+	if x == nil || x["error"] != nil || x["id"] == nil {
+		return nil, nil
+	}
+	model, err := ToModel[T](x)
+	if err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
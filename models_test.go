@@ -0,0 +1,183 @@
+package maz
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Decodes JSON test input into the untyped object form
+func mustObject(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var x map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &x); err != nil {
+		t.Fatalf("bad test JSON: %v", err)
+	}
+	return x
+}
+
+// Converts x to model T and back, and checks that nothing was lost or added
+func checkRoundTrip[T any](t *testing.T, name string, x map[string]interface{}) {
+	t.Helper()
+	model, err := ToModel[T](x)
+	if err != nil {
+		t.Errorf("%s: ToModel() error: %v", name, err)
+		return
+	}
+	got, err := FromModel(model)
+	if err != nil {
+		t.Errorf("%s: FromModel() error: %v", name, err)
+		return
+	}
+	if !reflect.DeepEqual(got, x) {
+		g, _ := json.Marshal(got)
+		w, _ := json.Marshal(x)
+		t.Errorf("%s: round trip =\n  %s\nwant\n  %s", name, g, w)
+	}
+}
+
+func TestModelRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		json  string
+	}{
+		{"role definition", "d", `{
+			"id": "/providers/Microsoft.Authorization/roleDefinitions/1111",
+			"name": "1111",
+			"type": "Microsoft.Authorization/roleDefinitions",
+			"properties": {
+				"roleName": "My Role",
+				"description": "",
+				"type": "CustomRole",
+				"permissions": [{"actions": ["*/read"], "notActions": [], "dataActions": [], "notDataActions": [],
+					"conditionVersion": null}],
+				"assignableScopes": ["/subscriptions/sub1"],
+				"createdOn": "2024-01-01T00:00:00Z",
+				"updatedBy": null,
+				"someNewAttribute": {"nested": [1, 2, true]}
+			},
+			"systemData": {"createdBy": "x"}
+		}`},
+		{"role assignment", "a", `{
+			"id": "/subscriptions/sub1/providers/Microsoft.Authorization/roleAssignments/2222",
+			"name": "2222",
+			"properties": {
+				"roleDefinitionId": "/subscriptions/sub1/providers/Microsoft.Authorization/roleDefinitions/1111",
+				"principalId": "3333",
+				"principalType": "Group",
+				"scope": "/subscriptions/sub1",
+				"condition": null,
+				"conditionVersion": null,
+				"delegatedManagedIdentityResourceId": null
+			}
+		}`},
+		{"subscription", "s", `{"id": "/subscriptions/sub1", "subscriptionId": "sub1", "state": "Enabled",
+			"subscriptionPolicies": {"spendingLimit": "Off"}, "tags": {}}`},
+		{"management group", "m", `{"id": "/providers/Microsoft.Management/managementGroups/mg1", "name": "mg1",
+			"properties": {"displayName": "MG 1", "tenantId": "t1", "details": {"parent": null}}}`},
+		{"user", "u", `{"id": "4444", "displayName": "Jo", "accountEnabled": false, "mail": null,
+			"onPremisesSamAccountName": "", "businessPhones": []}`},
+		{"group", "g", `{"id": "5555", "displayName": "Admins", "isAssignableToRole": true, "mailEnabled": false,
+			"securityEnabled": true, "groupTypes": [], "description": null}`},
+		{"service principal", "sp", `{"id": "6666", "appId": "7777", "accountEnabled": true,
+			"passwordCredentials": [{"keyId": "8888", "hint": "abc", "secretText": null, "customKeyIdentifier": null}],
+			"tags": ["WindowsAzureActiveDirectoryIntegratedApp"]}`},
+		{"application", "ap", `{"id": "9999", "appId": "7777", "signInAudience": "AzureADMyOrg",
+			"requiredResourceAccess": [{"resourceAppId": "00000003-0000-0000-c000-000000000000",
+				"resourceAccess": [{"id": "e1fe6dd8-ba31-4d61-89e7-88639da4683d", "type": "Scope"}]}],
+			"passwordCredentials": [], "web": {"redirectUris": []}}`},
+		{"directory role", "dr", `{"id": "aaaa", "displayName": "Reader", "isBuiltIn": true, "isEnabled": true,
+			"templateId": "aaaa", "rolePermissions": [{"allowedResourceActions": ["microsoft.directory/users/read"],
+				"condition": null, "excludedResourceActions": []}], "version": "1"}`},
+	}
+	for _, tt := range tests {
+		x := mustObject(t, tt.json)
+		switch tt.model {
+		case "d":
+			checkRoundTrip[RoleDefinition](t, tt.name, x)
+		case "a":
+			checkRoundTrip[RoleAssignment](t, tt.name, x)
+		case "s":
+			checkRoundTrip[Subscription](t, tt.name, x)
+		case "m":
+			checkRoundTrip[ManagementGroup](t, tt.name, x)
+		case "u":
+			checkRoundTrip[User](t, tt.name, x)
+		case "g":
+			checkRoundTrip[Group](t, tt.name, x)
+		case "sp":
+			checkRoundTrip[ServicePrincipal](t, tt.name, x)
+		case "ap":
+			checkRoundTrip[Application](t, tt.name, x)
+		case "dr":
+			checkRoundTrip[DirectoryRole](t, tt.name, x)
+		}
+	}
+}
+
+func TestModelEdits(t *testing.T) {
+	x := mustObject(t, `{"id": "1", "properties": {"roleName": "Old", "description": null, "extra": 1}}`)
+	role, err := ToModel[RoleDefinition](x)
+	if err != nil {
+		t.Fatalf("ToModel() error: %v", err)
+	}
+	if role.Properties.RoleName != "Old" {
+		t.Errorf("RoleName = %q, want %q", role.Properties.RoleName, "Old")
+	}
+	role.Properties.RoleName = "New"
+	role.Properties.Description = "Set"
+	role.Properties.AssignableScopes = []string{"/"}
+	got, err := FromModel(role)
+	if err != nil {
+		t.Fatalf("FromModel() error: %v", err)
+	}
+	want := mustObject(t, `{"id": "1", "properties": {"roleName": "New", "description": "Set", "extra": 1,
+		"assignableScopes": ["/"]}}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FromModel() = %v, want %v", got, want)
+	}
+}
+
+func TestToModelErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		x    interface{}
+	}{
+		{"properties not an object", map[string]interface{}{"properties": "x"}},
+		{"permissions not a list", map[string]interface{}{"properties": map[string]interface{}{"permissions": 1}}},
+		{"id not a string", map[string]interface{}{"id": []interface{}{1}}},
+		{"not an object", []interface{}{"x"}},
+	}
+	for _, tt := range tests {
+		if _, err := ToModel[RoleDefinition](tt.x); err == nil {
+			t.Errorf("%s: ToModel() = nil error, want error", tt.name)
+		}
+	}
+	if _, err := ToModelList[RoleDefinition]([]interface{}{map[string]interface{}{"id": "1"}, "x"}); err == nil {
+		t.Errorf("ToModelList() = nil error, want error")
+	}
+}
+
+func TestToModelPtr(t *testing.T) {
+	tests := []struct {
+		name   string
+		x      map[string]interface{}
+		wantId string // "" for a nil result
+	}{
+		{"nil", nil, ""},
+		{"error object", map[string]interface{}{"error": map[string]interface{}{"code": "NotFound"}}, ""},
+		{"no id", map[string]interface{}{"name": "x"}, ""},
+		{"found", map[string]interface{}{"id": "1", "name": "x"}, "1"},
+	}
+	for _, tt := range tests {
+		got, err := toModelPtr[RoleAssignment](tt.x)
+		if err != nil {
+			t.Errorf("%s: toModelPtr() error: %v", tt.name, err)
+			continue
+		}
+		if (got == nil) != (tt.wantId == "") || (got != nil && got.Id != tt.wantId) {
+			t.Errorf("%s: toModelPtr() = %+v, want id %q", tt.name, got, tt.wantId)
+		}
+	}
+}
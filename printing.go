@@ -7,47 +7,80 @@ import (
 	"github.com/queone/utl"
 )
 
+// Local cache and Azure counts for one kind of object
+type ObjectCount struct {
+	Local int64
+	Azure int64
+}
+
+// Status counts of all AZ and MG objects that are in Azure, and in the local cache files
+type CountStatus struct {
+	Users                  ObjectCount
+	Groups                 ObjectCount
+	Apps                   ObjectCount
+	SpsMultiTenant         ObjectCount
+	SpsNative              ObjectCount
+	AdRoles                ObjectCount
+	MgGroups               ObjectCount
+	Subscriptions          ObjectCount
+	RoleDefinitionsBuiltIn ObjectCount
+	RoleDefinitionsCustom  ObjectCount
+	RoleAssignments        ObjectCount
+}
+
+// Gathers status counts of all AZ and MG objects. All the independent local and Azure
+// count calls are run concurrently, since some of them can take a long time.
+func GetCountStatus(z Bundle) (c CountStatus) {
+	RunConcurrently(z,
+		func() { c.Users.Local = UsersCountLocal(z) },
+		func() { c.Users.Azure = UsersCountAzure(z) },
+		func() { c.Groups.Local = GroupsCountLocal(z) },
+		func() { c.Groups.Azure = GroupsCountAzure(z) },
+		func() { c.Apps.Local = AppsCountLocal(z) },
+		func() { c.Apps.Azure = AppsCountAzure(z) },
+		func() { c.SpsNative.Local, c.SpsMultiTenant.Local = SpsCountLocal(z) },
+		func() { c.SpsNative.Azure, c.SpsMultiTenant.Azure = SpsCountAzure(z) },
+		func() { c.AdRoles.Local = AdRolesCountLocal(z) },
+		func() { c.AdRoles.Azure = AdRolesCountAzure(z) },
+		func() { c.MgGroups.Local = MgGroupCountLocal(z) },
+		func() { c.MgGroups.Azure = MgGroupCountAzure(z) },
+		func() { c.Subscriptions.Local = SubsCountLocal(z) },
+		func() { c.Subscriptions.Azure = SubsCountAzure(z) },
+		func() { c.RoleDefinitionsBuiltIn.Local, c.RoleDefinitionsCustom.Local = RoleDefinitionCountLocal(z) },
+		func() { c.RoleDefinitionsBuiltIn.Azure, c.RoleDefinitionsCustom.Azure = RoleDefinitionCountAzure(z) },
+		func() { c.RoleAssignments.Local = RoleAssignmentsCountLocal(z) },
+		func() { c.RoleAssignments.Azure = RoleAssignmentsCountAzure(z) },
+	)
+	return c
+}
+
 // Prints a status count of all AZ and MG objects that are in Azure, and the local files.
 func PrintCountStatus(z Bundle) {
 	fmt.Printf("Note: Counting some Azure resources can take a long time\n")
+	c := GetCountStatus(z)
+	rows := []struct {
+		name  string
+		count ObjectCount
+	}{
+		{"Azure AD Users", c.Users},
+		{"Azure AD Groups", c.Groups},
+		{"Azure App Registrations", c.Apps},
+		{"Azure SPs (multi-tenant)", c.SpsMultiTenant},
+		{"Azure SPs (native to tenant)", c.SpsNative},
+		{"Azure AD Roles", c.AdRoles},
+		{"Azure Management Groups", c.MgGroups},
+		{"Azure Subscriptions", c.Subscriptions},
+		{"Resource Role Definitions BuiltIn", c.RoleDefinitionsBuiltIn},
+		{"Resource Role Definitions Custom", c.RoleDefinitionsCustom},
+		{"Resource Role Assignments", c.RoleAssignments},
+	}
 	fmt.Printf("%-36s%10s%10s\n", "OBJECTS", "LOCAL", "AZURE")
-	status := utl.Blu(utl.PostSpc("Azure AD Users", 36))
-	status += utl.Gre(utl.PreSpc(UsersCountLocal(z), 10))
-	status += utl.Gre(utl.PreSpc(UsersCountAzure(z), 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Azure AD Groups", 36))
-	status += utl.Gre(utl.PreSpc(GroupsCountLocal(z), 10))
-	status += utl.Gre(utl.PreSpc(GroupsCountAzure(z), 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Azure App Registrations", 36))
-	status += utl.Gre(utl.PreSpc(AppsCountLocal(z), 10))
-	status += utl.Gre(utl.PreSpc(AppsCountAzure(z), 10)) + "\n"
-	nativeSpsLocal, msSpsLocal := SpsCountLocal(z)
-	nativeSpsAzure, msSpsAzure := SpsCountAzure(z)
-	status += utl.Blu(utl.PostSpc("Azure SPs (multi-tenant)", 36))
-	status += utl.Gre(utl.PreSpc(msSpsLocal, 10))
-	status += utl.Gre(utl.PreSpc(msSpsAzure, 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Azure SPs (native to tenant)", 36))
-	status += utl.Gre(utl.PreSpc(nativeSpsLocal, 10))
-	status += utl.Gre(utl.PreSpc(nativeSpsAzure, 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Azure AD Roles", 36))
-	status += utl.Gre(utl.PreSpc(AdRolesCountLocal(z), 10))
-	status += utl.Gre(utl.PreSpc(AdRolesCountAzure(z), 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Azure Management Groups", 36))
-	status += utl.Gre(utl.PreSpc(MgGroupCountLocal(z), 10))
-	status += utl.Gre(utl.PreSpc(MgGroupCountAzure(z), 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Azure Subscriptions", 36))
-	status += utl.Gre(utl.PreSpc(SubsCountLocal(z), 10))
-	status += utl.Gre(utl.PreSpc(SubsCountAzure(z), 10)) + "\n"
-	builtinLocal, customLocal := RoleDefinitionCountLocal(z)
-	builtinAzure, customAzure := RoleDefinitionCountAzure(z)
-	status += utl.Blu(utl.PostSpc("Resource Role Definitions BuiltIn", 36))
-	status += utl.Gre(utl.PreSpc(builtinLocal, 10))
-	status += utl.Gre(utl.PreSpc(builtinAzure, 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Resource Role Definitions Custom", 36))
-	status += utl.Gre(utl.PreSpc(customLocal, 10))
-	status += utl.Gre(utl.PreSpc(customAzure, 10)) + "\n"
-	status += utl.Blu(utl.PostSpc("Resource Role Assignments", 36))
-	status += utl.Gre(utl.PreSpc(RoleAssignmentsCountLocal(z), 10))
-	status += utl.Gre(utl.PreSpc(RoleAssignmentsCountAzure(z), 10)) + "\n"
+	status := ""
+	for _, row := range rows {
+		status += utl.Blu(utl.PostSpc(row.name, 36))
+		status += utl.Gre(utl.PreSpc(row.count.Local, 10))
+		status += utl.Gre(utl.PreSpc(row.count.Azure, 10)) + "\n"
+	}
 	fmt.Print(status)
 }
 
@@ -78,6 +111,29 @@ func PrintTersely(t string, object interface{}) {
 		fmt.Printf("%s  %s\n", utl.Str(x["id"]), utl.Str(x["displayName"]))
 	case "sp", "ap":
 		fmt.Printf("%s  %-60s %s\n", utl.Str(x["id"]), utl.Str(x["displayName"]), utl.Str(x["appId"]))
+	case "rg", "rs":
+		fmt.Printf("%-16s  %s\n", utl.Str(x["location"]), utl.Str(x["id"]))
+	case "ae", "as":
+		xProp, _ := x["properties"].(map[string]interface{})
+		rdId := utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/")
+		principalId := utl.Str(xProp["principalId"])
+		endDateTime := utl.Str(xProp["endDateTime"])
+		if endDateTime == "" {
+			endDateTime = "permanent"
+		}
+		fmt.Printf("%s  %s  %s  %-20s  %s\n", utl.Str(x["name"]), rdId, principalId, endDateTime, utl.Str(xProp["scope"]))
+	case "dn":
+		xProp, _ := x["properties"].(map[string]interface{})
+		fmt.Printf("%s  %-50s  %s\n", utl.Str(x["name"]), utl.Str(xProp["denyAssignmentName"]), utl.Str(xProp["scope"]))
+	case "ca":
+		xProp, _ := x["properties"].(map[string]interface{})
+		fmt.Printf("%s  %-50s  %-40s  %s\n", utl.Str(x["name"]), utl.Str(xProp["emailAddress"]), utl.Str(xProp["role"]), classicAdminSubId(x))
+	case "po":
+		kind := "Action"
+		if x["isDataAction"] == true {
+			kind = "DataAction"
+		}
+		fmt.Printf("%-10s  %-90s  %s\n", kind, utl.Str(x["name"]), utl.Str(x["displayName"]))
 	case "ad":
 		builtIn := "Custom"
 		if utl.Str(x["isBuiltIn"]) == "true" {
@@ -93,19 +149,14 @@ func PrintTersely(t string, object interface{}) {
 
 // Prints object by given UUID
 func PrintObjectByUuid(uuid string, z Bundle) {
-	list := FindAzObjectsByUuid(uuid, z) // Search for this UUID under all maz objects types
-	for i, obj := range list {
-		x := obj.(map[string]interface{})
-		mazType := utl.Str(x["mazType"])
-		if mazType != "" {
-			fmt.Printf("Object %d (%s):\n", i, utl.Red(mazTypesLong[mazType]))
-			PrintObject(mazType, x, z)
-		}
+	matches := FindAzObjectMatchesByUuid(uuid, z) // Search for this UUID under all maz objects types
+	for i, m := range matches {
+		fmt.Printf("Object %d (%s):\n", i, utl.Red(mazTypesLong[m.MazType]))
+		PrintObject(m.MazType, m.Object, z)
 	}
 
-	if len(list) > 1 {
-		x0 := list[0].(map[string]interface{})
-		appId := utl.Str(x0["appId"])
+	if len(matches) > 1 {
+		appId := utl.Str(matches[0].Object["appId"])
 		if appId == uuid {
 			fmt.Println(utl.Yel("Above objects share this appId UUID"))
 		} else {
@@ -135,6 +186,16 @@ func PrintObject(t string, x map[string]interface{}, z Bundle) {
 		PrintApp(x, z)
 	case "ad":
 		PrintAdRole(x, z)
+	case "rg", "rs":
+		PrintResource(x)
+	case "ae", "as":
+		PrintPimAssignment(x, z)
+	case "dn":
+		PrintDenyAssignment(x, z)
+	case "ca":
+		PrintClassicAdmin(x, z)
+	case "po":
+		PrintProviderOperation(x)
 	}
 }
 
@@ -346,9 +407,10 @@ func PrintStringMapColor(strMap map[string]string) {
 	}
 }
 
-// Prints all objects that match on given specifier
+// Prints all objects that match on given specifier. When the Bundle cache is read-only, such
+// as a snapshot, objects are only ever printed from that cache, never directly from Azure.
 func PrintMatching(printFormat, t, specifier string, z Bundle) {
-	if utl.ValidUuid(specifier) {
+	if utl.ValidUuid(specifier) && !z.CacheReadOnly {
 		// If valid UUID string, get object direct from Azure
 		x := GetAzObjectByUuid(t, specifier, z)
 		if x != nil {
@@ -365,7 +427,7 @@ func PrintMatching(printFormat, t, specifier string, z Bundle) {
 		// If it's only one object, try getting it direct from Azure instead of using the local cache
 		x := matchingObjects[0].(map[string]interface{})
 		uuid := utl.Str(x["id"])
-		if utl.ValidUuid(uuid) {
+		if utl.ValidUuid(uuid) && !z.CacheReadOnly {
 			x = GetAzObjectByUuid(t, uuid, z) // Replace object with version directly in Azure
 		}
 		if printFormat == "json" {
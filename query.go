@@ -0,0 +1,520 @@
+package maz
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/queone/utl"
+)
+
+// A compiled filter expression, to match cached objects on specific fields. Examples:
+//
+//	displayName ~ "^prod-" and accountEnabled == false
+//	appId in ("00000003-0000-0000-c000-000000000000", "797f4846-ba00-4fd7-ba43-dac1f8f63013")
+//	properties.roleName ~ "(?i)reader" and not properties.type == "BuiltInRole"
+//	passwordCredentials[*].displayName == "ci-secret" or passwordCredentials[0].hint != null
+//
+// Field paths are dot separated, with [n] to pick an array element and [*] (or no index at
+// all) to match any element. A bare field path is true if the field is set and not false,
+// null, zero or empty. Operators are == != ~ !~ < <= > >= and 'in', combined with 'and',
+// 'or', 'not' and parentheses. Values are "double" or 'single' quoted strings, numbers,
+// true, false and null. The ~ and !~ operators take Go regular expressions.
+type Filter struct {
+	expr string
+	root queryNode
+}
+
+type queryNode interface {
+	eval(x map[string]interface{}) bool
+}
+
+type queryAnd struct{ left, right queryNode }
+type queryOr struct{ left, right queryNode }
+type queryNot struct{ node queryNode }
+type queryTruthy struct{ path []string }
+type queryCompare struct {
+	path   []string
+	op     string
+	values []interface{}
+	regex  *regexp.Regexp
+}
+
+func (n queryAnd) eval(x map[string]interface{}) bool { return n.left.eval(x) && n.right.eval(x) }
+func (n queryOr) eval(x map[string]interface{}) bool  { return n.left.eval(x) || n.right.eval(x) }
+func (n queryNot) eval(x map[string]interface{}) bool { return !n.node.eval(x) }
+
+func (n queryTruthy) eval(x map[string]interface{}) bool {
+	for _, v := range resolveFieldPath(x, n.path) {
+		switch v := v.(type) {
+		case nil:
+		case bool:
+			if v {
+				return true
+			}
+		case float64:
+			if v != 0 {
+				return true
+			}
+		case string:
+			if v != "" {
+				return true
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (n queryCompare) eval(x map[string]interface{}) bool {
+	candidates := resolveFieldPath(x, n.path)
+	if len(candidates) == 0 {
+		candidates = []interface{}{nil} // A missing field compares as null
+	}
+	// Negated operators must hold for every candidate, all others for any one of them
+	switch n.op {
+	case "!=", "!~":
+		for _, v := range candidates {
+			if !compareValue(v, n.op, n.values[0], n.regex) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range candidates {
+		for _, want := range n.values {
+			if compareValue(v, n.op, want, n.regex) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Compares field value v against wanted value with given operator
+func compareValue(v interface{}, op string, want interface{}, regex *regexp.Regexp) bool {
+	switch op {
+	case "~":
+		return v != nil && regex.MatchString(utl.Str(v))
+	case "!~":
+		return v == nil || !regex.MatchString(utl.Str(v))
+	case "==", "in":
+		return valuesEqual(v, want)
+	case "!=":
+		return !valuesEqual(v, want)
+	}
+	// Ordering operators, numeric if both sides are numbers, otherwise string based
+	var cmp int
+	a, aNum := v.(float64)
+	b, bNum := want.(float64)
+	if aNum && bNum {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	} else {
+		if v == nil || want == nil {
+			return false
+		}
+		cmp = strings.Compare(utl.Str(v), utl.Str(want))
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// Returns true if field value v equals wanted filter value
+func valuesEqual(v, want interface{}) bool {
+	switch w := want.(type) {
+	case nil:
+		return v == nil
+	case bool:
+		b, ok := v.(bool)
+		return ok && b == w
+	case float64:
+		f, ok := v.(float64)
+		return ok && f == w
+	}
+	if v == nil {
+		return false
+	}
+	return utl.Str(v) == utl.Str(want)
+}
+
+// Returns all values found at given field path. Paths fan out over arrays, so there can be
+// more than one. Keys are matched exactly first, then case-insensitively.
+func resolveFieldPath(x interface{}, path []string) (values []interface{}) {
+	if len(path) == 0 {
+		return []interface{}{x}
+	}
+	step, rest := path[0], path[1:]
+	switch obj := x.(type) {
+	case map[string]interface{}:
+		if strings.HasPrefix(step, "[") {
+			return nil // Index into an object
+		}
+		v, ok := obj[step]
+		if !ok {
+			for k, kv := range obj {
+				if strings.EqualFold(k, step) {
+					v, ok = kv, true
+					break
+				}
+			}
+		}
+		if !ok {
+			return nil
+		}
+		return resolveFieldPath(v, rest)
+	case []interface{}:
+		if step == "[*]" {
+			path = rest // Explicit any-element, otherwise it's implied
+		} else if strings.HasPrefix(step, "[") {
+			n, _ := strconv.Atoi(strings.Trim(step, "[]"))
+			if n < 0 || n >= len(obj) {
+				return nil
+			}
+			return resolveFieldPath(obj[n], rest)
+		}
+		for _, item := range obj {
+			values = append(values, resolveFieldPath(item, path)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// Compiles given filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.tokens[p.pos].text, p.tokens[p.pos].pos)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Returns true if object x matches the filter
+func (f *Filter) Match(x map[string]interface{}) bool {
+	return f.root.eval(x)
+}
+
+// Returns the original filter expression
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Returns all cached objects of type t that match given filter expression. Objects come
+// from GetObjects, so the usual cache refresh rules apply.
+func QueryObjects(t, expr string, force bool, z Bundle) (list []interface{}, err error) {
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range GetObjects(t, "", force, z) {
+		if x, ok := i.(map[string]interface{}); ok && filter.Match(x) {
+			list = append(list, x)
+		}
+	}
+	return list, nil
+}
+
+// Prints all cached objects of type t that match given filter expression
+func PrintQuery(printFormat, t, expr string, z Bundle) {
+	list, err := QueryObjects(t, expr, false, z)
+	if err != nil {
+		utl.Die("Filter error: %s\n", err.Error())
+	}
+	if printFormat == "json" {
+		utl.PrintJsonColor(list)
+		return
+	}
+	for _, x := range list {
+		PrintTersely(t, x)
+	}
+}
+
+// Query language lexer and recursive descent parser
+
+type queryToken struct {
+	kind string // "ident", "string", "number", "op", "(", ")", ","
+	text string
+	pos  int
+}
+
+// Splits filter expression into tokens
+func lexQuery(expr string) (tokens []queryToken, err error) {
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, queryToken{string(c), string(c), i})
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for i < len(expr) && expr[i] != c {
+				if expr[i] == '\\' && i+1 < len(expr) && (expr[i+1] == c || expr[i+1] == '\\') {
+					i++ // Escaped quote or backslash
+				}
+				sb.WriteByte(expr[i])
+				i++
+			}
+			if i >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, queryToken{"string", sb.String(), start})
+		case strings.ContainsRune("=!~<>", rune(c)):
+			start := i
+			for i < len(expr) && strings.ContainsRune("=!~<>", rune(expr[i])) {
+				i++
+			}
+			op := expr[start:i]
+			switch op {
+			case "==", "!=", "~", "!~", "<", "<=", ">", ">=":
+			case "=":
+				op = "==" // Be forgiving
+			default:
+				return nil, fmt.Errorf("unknown operator '%s' at position %d", op, start)
+			}
+			tokens = append(tokens, queryToken{"op", op, start})
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t\n()=!~<>,'\"", rune(expr[i])) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+			}
+			word := expr[start:i]
+			kind := "ident" // Includes words that start with a digit but are no number, such as UUIDs
+			if _, err := strconv.ParseFloat(word, 64); err == nil && (c == '-' || c == '.' || (c >= '0' && c <= '9')) {
+				kind = "number"
+			}
+			tokens = append(tokens, queryToken{kind, word, start})
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// Returns true and advances if next token is given keyword
+func (p *queryParser) keyword(word string) bool {
+	if t := p.peek(); t != nil && t.kind == "ident" && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = queryOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = queryAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.keyword("not") {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	if t.kind == "(" {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next == nil || next.kind != ")" {
+			return nil, fmt.Errorf("missing ')' for '(' at position %d", t.pos)
+		}
+		p.pos++
+		return node, nil
+	}
+	if t.kind != "ident" {
+		return nil, fmt.Errorf("expected a field name at position %d, got '%s'", t.pos, t.text)
+	}
+	p.pos++
+	path, err := parseFieldPath(t.text)
+	if err != nil {
+		return nil, fmt.Errorf("%s at position %d", err.Error(), t.pos)
+	}
+
+	// 'in' list
+	if p.keyword("in") {
+		if t := p.peek(); t == nil || t.kind != "(" {
+			return nil, fmt.Errorf("expected '(' after 'in'")
+		}
+		p.pos++
+		var values []interface{}
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			t := p.peek()
+			if t != nil && t.kind == "," {
+				p.pos++
+				continue
+			}
+			if t != nil && t.kind == ")" {
+				p.pos++
+				break
+			}
+			return nil, fmt.Errorf("expected ',' or ')' in 'in' list")
+		}
+		return queryCompare{path: path, op: "in", values: values}, nil
+	}
+
+	// Comparison, or just a bare field path
+	op := p.peek()
+	if op == nil || op.kind != "op" {
+		return queryTruthy{path}, nil
+	}
+	p.pos++
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	node := queryCompare{path: path, op: op.text, values: []interface{}{v}}
+	if op.text == "~" || op.text == "!~" {
+		node.regex, err = regexp.Compile(utl.Str(v))
+		if err != nil {
+			return nil, fmt.Errorf("bad regular expression at position %d: %s", op.pos, err.Error())
+		}
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseValue() (interface{}, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("expected a value at end of filter")
+	}
+	p.pos++
+	switch t.kind {
+	case "string":
+		return t.text, nil
+	case "number":
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number '%s' at position %d", t.text, t.pos)
+		}
+		return f, nil
+	case "ident":
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.text, nil // Allow unquoted single words, such as UUIDs
+	}
+	return nil, fmt.Errorf("expected a value at position %d, got '%s'", t.pos, t.text)
+}
+
+// Splits a field path such as 'a.b[0].c[*]' into steps: a, b, [0], c, [*]
+func parseFieldPath(s string) (path []string, err error) {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return nil, fmt.Errorf("empty field name in '%s'", s)
+		}
+		name := part
+		if i := strings.Index(part, "["); i >= 0 {
+			name = part[:i]
+			if name != "" {
+				path = append(path, name)
+			}
+			for _, idx := range strings.SplitAfter(part[i:], "]") {
+				if idx == "" {
+					continue
+				}
+				inner := strings.TrimSuffix(strings.TrimPrefix(idx, "["), "]")
+				if _, err := strconv.Atoi(inner); !strings.HasPrefix(idx, "[") ||
+					!strings.HasSuffix(idx, "]") || (inner != "*" && err != nil) {
+					return nil, fmt.Errorf("bad array index '%s' in '%s'", idx, s)
+				}
+				path = append(path, "["+inner+"]")
+			}
+			continue
+		}
+		path = append(path, name)
+	}
+	return path, nil
+}
@@ -0,0 +1,120 @@
+package maz
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLexQuery(t *testing.T) {
+	tests := []struct {
+		expr  string
+		kinds []string
+		texts []string
+	}{
+		{`name == "x"`, []string{"ident", "op", "string"}, []string{"name", "==", "x"}},
+		{`a = 'it\'s'`, []string{"ident", "op", "string"}, []string{"a", "==", "it's"}},
+		{`count >= 10`, []string{"ident", "op", "number"}, []string{"count", ">=", "10"}},
+		{`x < -1.5`, []string{"ident", "op", "number"}, []string{"x", "<", "-1.5"}},
+		{`id == 3f2a0c1e-0000-4000-8000-000000000001`, []string{"ident", "op", "ident"},
+			[]string{"id", "==", "3f2a0c1e-0000-4000-8000-000000000001"}},
+		{`id == 12345678-1234-1234-1234-123456789012`, []string{"ident", "op", "ident"},
+			[]string{"id", "==", "12345678-1234-1234-1234-123456789012"}},
+		{`a in (1, 2)`, []string{"ident", "ident", "(", "number", ",", "number", ")"},
+			[]string{"a", "in", "(", "1", ",", "2", ")"}},
+		{`not x !~ "^a"`, []string{"ident", "ident", "op", "string"}, []string{"not", "x", "!~", "^a"}},
+	}
+	for _, tt := range tests {
+		tokens, err := lexQuery(tt.expr)
+		if err != nil {
+			t.Errorf("lexQuery(%q) error: %v", tt.expr, err)
+			continue
+		}
+		var kinds, texts []string
+		for _, tok := range tokens {
+			kinds = append(kinds, tok.kind)
+			texts = append(texts, tok.text)
+		}
+		if !reflect.DeepEqual(kinds, tt.kinds) || !reflect.DeepEqual(texts, tt.texts) {
+			t.Errorf("lexQuery(%q) = %v %v, want %v %v", tt.expr, kinds, texts, tt.kinds, tt.texts)
+		}
+	}
+}
+
+func TestLexQueryErrors(t *testing.T) {
+	for _, expr := range []string{`name == "x`, `a === b`, `a =! b`} {
+		if _, err := lexQuery(expr); err == nil {
+			t.Errorf("lexQuery(%q) = nil error, want error", expr)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	user := map[string]interface{}{
+		"id":             "12345678-1234-1234-1234-123456789012",
+		"displayName":    "prod-web",
+		"accountEnabled": false,
+		"count":          float64(3),
+		"properties": map[string]interface{}{
+			"roleName": "Storage Blob Data Reader",
+			"type":     "BuiltInRole",
+		},
+		"passwordCredentials": []interface{}{
+			map[string]interface{}{"displayName": "ci-secret", "hint": "abc"},
+			map[string]interface{}{"displayName": "other", "hint": nil},
+		},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`displayName == "prod-web"`, true},
+		{`displayName != "prod-web"`, false},
+		{`displayName ~ "^prod-"`, true},
+		{`displayName !~ "^prod-"`, false},
+		{`displayName ~ "^prod-" and accountEnabled == false`, true},
+		{`accountEnabled`, false},
+		{`not accountEnabled`, true},
+		{`count > 2 and count <= 3`, true},
+		{`count < 3`, false},
+		{`id == 12345678-1234-1234-1234-123456789012`, true},
+		{`id in ("a", "12345678-1234-1234-1234-123456789012")`, true},
+		{`id in ("a", "b")`, false},
+		{`properties.roleName ~ "(?i)reader" and not properties.type == "BuiltInRole"`, false},
+		{`properties.roleName ~ "(?i)reader" or properties.type == "CustomRole"`, true},
+		{`passwordCredentials[*].displayName == "ci-secret"`, true},
+		{`passwordCredentials.displayName == "other"`, true},
+		{`passwordCredentials[1].displayName == "ci-secret"`, false},
+		{`passwordCredentials[0].hint != null`, true},
+		{`missing`, false},
+		{`(displayName == "x" or count == 3) and not missing`, true},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(user); got != tt.want {
+			t.Errorf("ParseFilter(%q).Match() = %v, want %v", tt.expr, got, tt.want)
+		}
+		if f.String() != tt.expr {
+			t.Errorf("ParseFilter(%q).String() = %q", tt.expr, f.String())
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`displayName ==`,
+		`(displayName == "x"`,
+		`displayName == "x")`,
+		`displayName ~ "("`,
+		`a and`,
+		`a in "x"`,
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) = nil error, want error", expr)
+		}
+	}
+}
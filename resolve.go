@@ -0,0 +1,226 @@
+package maz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/queone/utl"
+)
+
+// Name-based references in specfiles. Instead of raw UUIDs and fully qualified scopes,
+// specfiles may use:
+//
+//	principalName: UPN or displayName of a user, or displayName of a group or SP
+//	roleName:      role definition name, in assignment and eligibility specfiles
+//	scope:         friendly forms such as "sub:Prod-Shared/rg:network", "mg:Platform", or "tenant"
+//
+// Names are resolved against the local cache, the same as the GetIdMap* functions. Unknown or
+// ambiguous names are errors.
+
+// Resolves name-based references of specfile object x of maz type t, in place, into the UUIDs
+// and fully qualified scopes that Azure expects
+func ResolveSpecfileNames(t string, x map[string]interface{}, z Bundle) error {
+	var r nameResolver
+	return r.resolve(t, x, z)
+}
+
+// Resolves the name-based references of one specfile object. Reusing the same resolver across
+// many objects only reads each cache once.
+func (r *nameResolver) resolve(t string, x map[string]interface{}, z Bundle) error {
+	if x == nil {
+		return nil
+	}
+	switch t {
+	case "a", "ae":
+		xProp, _ := x["properties"].(map[string]interface{})
+		if err := r.resolvePrincipal(xProp, z); err != nil {
+			return err
+		}
+		if err := r.resolveRole(xProp, z); err != nil {
+			return err
+		}
+		if s := utl.Str(xProp["scope"]); s != "" {
+			scope, err := r.resolveScope(s, z)
+			if err != nil {
+				return err
+			}
+			xProp["scope"] = scope
+		}
+	case "d":
+		xProp, _ := x["properties"].(map[string]interface{})
+		scopes, _ := xProp["assignableScopes"].([]interface{})
+		for n, i := range scopes {
+			scope, err := r.resolveScope(utl.Str(i), z)
+			if err != nil {
+				return err
+			}
+			scopes[n] = scope
+		}
+	case "ada":
+		return r.resolvePrincipal(x, z) // Directory role assignments are flat, with no properties
+	}
+	return nil
+}
+
+// Lazily loaded name:ids maps, so each is only read from cache when a specfile needs it
+type nameResolver struct {
+	principals, roles, subs, mgs, rgs map[string][]string
+}
+
+// Adds the id under the lowercased name, once
+func addName(m map[string][]string, name, id string) {
+	if name == "" || id == "" {
+		return
+	}
+	key := strings.ToLower(name)
+	if !utl.ItemInList(id, m[key]) {
+		m[key] = append(m[key], id)
+	}
+}
+
+// Returns the only id known by given name, or an error if there is none or more than one
+func lookupName(m map[string][]string, kind, name string) (string, error) {
+	ids := m[strings.ToLower(name)]
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("unknown %s '%s'", kind, name)
+	case 1:
+		return ids[0], nil
+	}
+	sort.Strings(ids)
+	return "", fmt.Errorf("ambiguous %s '%s' matches %d objects: %s", kind, name, len(ids), strings.Join(ids, ", "))
+}
+
+// Replaces principalName with principalId in given properties
+func (r *nameResolver) resolvePrincipal(xProp map[string]interface{}, z Bundle) error {
+	name := utl.Str(xProp["principalName"])
+	if name == "" {
+		return nil
+	}
+	if r.principals == nil {
+		r.principals = map[string][]string{}
+		for _, i := range GetMatchingUsers("", false, z) {
+			x := i.(map[string]interface{})
+			addName(r.principals, utl.Str(x["userPrincipalName"]), utl.Str(x["id"]))
+			addName(r.principals, utl.Str(x["displayName"]), utl.Str(x["id"]))
+		}
+		for _, m := range []map[string]string{GetIdMapGroups(z), GetIdMapSps(z)} {
+			for id, displayName := range m {
+				addName(r.principals, displayName, id)
+			}
+		}
+	}
+	id, err := lookupName(r.principals, "principal", name)
+	if err != nil {
+		return err
+	}
+	if existing := utl.Str(xProp["principalId"]); existing != "" && !strings.EqualFold(existing, id) {
+		return fmt.Errorf("principalName '%s' is %s, but principalId is %s", name, id, existing)
+	}
+	xProp["principalId"] = id
+	delete(xProp, "principalName")
+	return nil
+}
+
+// Replaces roleName with roleDefinitionId in given assignment properties
+func (r *nameResolver) resolveRole(xProp map[string]interface{}, z Bundle) error {
+	name := utl.Str(xProp["roleName"])
+	if name == "" {
+		return nil
+	}
+	if r.roles == nil {
+		r.roles = map[string][]string{}
+		for id, roleName := range GetIdMapRoleDefs(z) {
+			addName(r.roles, roleName, id)
+		}
+	}
+	id, err := lookupName(r.roles, "role", name)
+	if err != nil {
+		return err
+	}
+	existing := utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/")
+	if existing != "" && !strings.EqualFold(existing, id) {
+		return fmt.Errorf("roleName '%s' is %s, but roleDefinitionId is %s", name, id, existing)
+	}
+	xProp["roleDefinitionId"] = id
+	delete(xProp, "roleName")
+	return nil
+}
+
+// Returns the fully qualified form of a friendly scope. Scopes that are already fully
+// qualified are returned unchanged. Friendly scopes are a "/" separated list of:
+//
+//	tenant            The tenant root, "/"
+//	mg:<name>         A management group, by name or displayName
+//	sub:<name>        A subscription, by displayName or UUID
+//	rg:<name>         A resource group in the cache, following a sub:<name> element
+//
+// Any path after rg:<name> is appended as is, for resource scopes.
+func (r *nameResolver) resolveScope(scope string, z Bundle) (string, error) {
+	if scope == "" || strings.HasPrefix(scope, "/") {
+		return scope, nil
+	}
+	if strings.EqualFold(scope, "tenant") {
+		return "/", nil
+	}
+	parts := strings.Split(scope, "/")
+	kind, name, _ := strings.Cut(parts[0], ":")
+	switch strings.ToLower(kind) {
+	case "mg":
+		if len(parts) > 1 {
+			return "", fmt.Errorf("scope '%s' can't have elements after the management group", scope)
+		}
+		if r.mgs == nil {
+			r.mgs = map[string][]string{}
+			for _, i := range GetMatchingMgGroups("", false, z) {
+				x := i.(map[string]interface{})
+				id := utl.Str(x["id"])
+				addName(r.mgs, utl.Str(x["name"]), id)
+				if xProp, ok := x["properties"].(map[string]interface{}); ok {
+					addName(r.mgs, utl.Str(xProp["displayName"]), id)
+				}
+			}
+		}
+		return lookupName(r.mgs, "management group", name)
+	case "sub":
+		if r.subs == nil {
+			r.subs = map[string][]string{}
+			for id, displayName := range GetIdMapSubs(z) {
+				addName(r.subs, displayName, id)
+				addName(r.subs, id, id)
+			}
+		}
+		subId, err := lookupName(r.subs, "subscription", name)
+		if err != nil {
+			return "", err
+		}
+		resolved := "/subscriptions/" + subId
+		if len(parts) > 1 {
+			rgKind, rgName, _ := strings.Cut(parts[1], ":")
+			if !strings.EqualFold(rgKind, "rg") || rgName == "" {
+				return "", fmt.Errorf("scope '%s' must have an rg:<name> element after the subscription", scope)
+			}
+			if r.rgs == nil {
+				r.rgs = map[string][]string{}
+				for _, i := range GetMatchingResourceGroups("", false, z) {
+					id := utl.Str(i.(map[string]interface{})["id"])
+					split := strings.Split(id, "/") // "/subscriptions/<subId>/resourceGroups/<name>"
+					if len(split) == 5 {
+						addName(r.rgs, split[2]+"/"+split[4], id)
+					}
+				}
+			}
+			rgId, err := lookupName(r.rgs, "resource group", subId+"/"+rgName)
+			if err != nil {
+				return "", err
+			}
+			resolved = rgId
+			if len(parts) > 2 {
+				resolved += "/" + strings.Join(parts[2:], "/")
+			}
+		}
+		return resolved, nil
+	}
+	return "", fmt.Errorf("unknown scope '%s', expected a fully qualified scope, tenant, mg:<name> or sub:<name>[/rg:<name>]", scope)
+}
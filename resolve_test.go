@@ -0,0 +1,212 @@
+package maz
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testSub1   = "11111111-1111-1111-1111-111111111111"
+	testSub2   = "22222222-2222-2222-2222-222222222222"
+	testUser   = "33333333-3333-3333-3333-333333333333"
+	testGrp1   = "44444444-4444-4444-4444-444444444444"
+	testGrp2   = "55555555-5555-5555-5555-555555555555"
+	testRoleId = "66666666-6666-6666-6666-666666666666"
+)
+
+// Returns a name resolver with every name map already loaded, so it never reads a cache
+func testNameResolver() *nameResolver {
+	r := &nameResolver{
+		principals: map[string][]string{},
+		roles:      map[string][]string{},
+		subs:       map[string][]string{},
+		mgs:        map[string][]string{},
+		rgs:        map[string][]string{},
+	}
+	addName(r.principals, "jo@example.com", testUser)
+	addName(r.principals, "Jo Smith", testUser)
+	addName(r.principals, "Admins", testGrp1)
+	addName(r.principals, "Readers", testGrp1)
+	addName(r.principals, "Readers", testGrp2) // Two groups with the same displayName
+	addName(r.roles, "Reader", testRoleId)
+	for id, name := range map[string]string{testSub1: "Prod-Shared", testSub2: "Dev-Shared"} {
+		addName(r.subs, name, id)
+		addName(r.subs, id, id)
+	}
+	mg := "/providers/Microsoft.Management/managementGroups/platform"
+	addName(r.mgs, "platform", mg)
+	addName(r.mgs, "Platform Root", mg)
+	for _, id := range []string{
+		"/subscriptions/" + testSub1 + "/resourceGroups/network",
+		"/subscriptions/" + testSub2 + "/resourceGroups/network",
+		"/subscriptions/" + testSub2 + "/resourceGroups/apps",
+	} {
+		split := strings.Split(id, "/")
+		addName(r.rgs, split[2]+"/"+split[4], id)
+	}
+	return r
+}
+
+func TestLookupName(t *testing.T) {
+	m := map[string][]string{}
+	addName(m, "One", "id-1")
+	addName(m, "one", "id-1") // Same id again, added once
+	addName(m, "Two", "id-b")
+	addName(m, "Two", "id-a")
+	addName(m, "", "id-x")
+	addName(m, "Blank", "")
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{"one", "id-1", ""},
+		{"ONE", "id-1", ""},
+		{"two", "", "ambiguous thing 'two' matches 2 objects: id-a, id-b"},
+		{"three", "", "unknown thing 'three'"},
+		{"", "", "unknown thing ''"},
+		{"Blank", "", "unknown thing 'Blank'"},
+	}
+	for _, tt := range tests {
+		got, err := lookupName(m, "thing", tt.name)
+		if got != tt.want || (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+			t.Errorf("lookupName(%q) = %q, %v, want %q, %q", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveScope(t *testing.T) {
+	r := testNameResolver()
+	sub1, sub2 := "/subscriptions/"+testSub1, "/subscriptions/"+testSub2
+	tests := []struct {
+		scope   string
+		want    string
+		wantErr string // Substring of the expected error, "" for no error
+	}{
+		{"", "", ""},
+		{"/", "/", ""},
+		{sub1 + "/resourceGroups/anything", sub1 + "/resourceGroups/anything", ""},
+		{"tenant", "/", ""},
+		{"TENANT", "/", ""},
+		{"mg:platform", "/providers/Microsoft.Management/managementGroups/platform", ""},
+		{"MG:platform root", "/providers/Microsoft.Management/managementGroups/platform", ""},
+		{"mg:nowhere", "", "unknown management group 'nowhere'"},
+		{"mg:platform/rg:network", "", "can't have elements after the management group"},
+		{"sub:Prod-Shared", sub1, ""},
+		{"sub:prod-shared", sub1, ""},
+		{"sub:" + testSub2, sub2, ""},
+		{"sub:Staging", "", "unknown subscription 'Staging'"},
+		{"sub:Prod-Shared/rg:network", sub1 + "/resourceGroups/network", ""},
+		{"sub:Dev-Shared/rg:NETWORK", sub2 + "/resourceGroups/network", ""},
+		{"sub:Dev-Shared/rg:apps/providers/Microsoft.Storage/storageAccounts/sa1",
+			sub2 + "/resourceGroups/apps/providers/Microsoft.Storage/storageAccounts/sa1", ""},
+		{"sub:Prod-Shared/rg:apps", "", "unknown resource group '" + testSub1 + "/apps'"},
+		{"sub:Prod-Shared/network", "", "must have an rg:<name> element after the subscription"},
+		{"sub:Prod-Shared/rg:", "", "must have an rg:<name> element after the subscription"},
+		{"rg:network", "", "unknown scope 'rg:network'"},
+		{"Prod-Shared", "", "unknown scope 'Prod-Shared'"},
+	}
+	for _, tt := range tests {
+		got, err := r.resolveScope(tt.scope, Bundle{})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("resolveScope(%q) error: %v", tt.scope, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("resolveScope(%q) = %q, want error containing %q", tt.scope, got, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("resolveScope(%q) error = %q, want error containing %q", tt.scope, err, tt.wantErr)
+		case tt.wantErr == "" && got != tt.want:
+			t.Errorf("resolveScope(%q) = %q, want %q", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestResolveSpecObject(t *testing.T) {
+	roleId := "/providers/Microsoft.Authorization/roleDefinitions/" + testRoleId
+	tests := []struct {
+		name    string
+		t       string
+		x       map[string]interface{}
+		want    map[string]interface{} // The object after resolution, if there is no error
+		wantErr string                 // Substring of the expected error, "" for no error
+	}{
+		{"assignment by names", "a",
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalName": "JO@example.com", "roleName": "reader", "scope": "sub:Prod-Shared/rg:network"}},
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalId": testUser, "roleDefinitionId": testRoleId,
+				"scope": "/subscriptions/" + testSub1 + "/resourceGroups/network"}},
+			""},
+		{"assignment by ids is unchanged", "a",
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalId": testGrp2, "roleDefinitionId": roleId, "scope": "/"}},
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalId": testGrp2, "roleDefinitionId": roleId, "scope": "/"}},
+			""},
+		{"matching principalName and principalId", "a",
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalName": "Jo Smith", "principalId": strings.ToUpper(testUser), "roleDefinitionId": testRoleId, "scope": "/"}},
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalId": testUser, "roleDefinitionId": testRoleId, "scope": "/"}},
+			""},
+		{"conflicting principalName and principalId", "a",
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalName": "Admins", "principalId": testUser, "roleDefinitionId": testRoleId, "scope": "/"}},
+			nil, "principalName 'Admins' is " + testGrp1 + ", but principalId is " + testUser},
+		{"matching roleName and roleDefinitionId", "ae",
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalId": testUser, "roleName": "Reader", "roleDefinitionId": roleId, "scope": "tenant"}},
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalId": testUser, "roleDefinitionId": testRoleId, "scope": "/"}},
+			""},
+		{"conflicting roleName and roleDefinitionId", "a",
+			map[string]interface{}{"properties": map[string]interface{}{
+				"principalId": testUser, "roleName": "Reader", "roleDefinitionId": testSub1, "scope": "/"}},
+			nil, "roleName 'Reader' is " + testRoleId + ", but roleDefinitionId is " + testSub1},
+		{"ambiguous principal", "a",
+			map[string]interface{}{"properties": map[string]interface{}{"principalName": "Readers"}},
+			nil, "ambiguous principal 'Readers' matches 2 objects"},
+		{"unknown principal", "a",
+			map[string]interface{}{"properties": map[string]interface{}{"principalName": "Nobody"}},
+			nil, "unknown principal 'Nobody'"},
+		{"unknown role", "a",
+			map[string]interface{}{"properties": map[string]interface{}{"roleName": "Owner"}},
+			nil, "unknown role 'Owner'"},
+		{"unknown scope", "a",
+			map[string]interface{}{"properties": map[string]interface{}{"scope": "sub:Nowhere"}},
+			nil, "unknown subscription 'Nowhere'"},
+		{"role definition scopes", "d",
+			map[string]interface{}{"properties": map[string]interface{}{
+				"roleName": "Not resolved", "assignableScopes": []interface{}{"mg:platform", "sub:Dev-Shared", "/"}}},
+			map[string]interface{}{"properties": map[string]interface{}{
+				"roleName": "Not resolved", "assignableScopes": []interface{}{
+					"/providers/Microsoft.Management/managementGroups/platform", "/subscriptions/" + testSub2, "/"}}},
+			""},
+		{"role definition unknown scope", "d",
+			map[string]interface{}{"properties": map[string]interface{}{"assignableScopes": []interface{}{"mg:nowhere"}}},
+			nil, "unknown management group 'nowhere'"},
+		{"directory role assignment", "ada",
+			map[string]interface{}{"principalName": "Admins", "roleDefinitionId": "role-template"},
+			map[string]interface{}{"principalId": testGrp1, "roleDefinitionId": "role-template"},
+			""},
+		{"no properties", "a", map[string]interface{}{}, map[string]interface{}{}, ""},
+		{"unresolved type", "u",
+			map[string]interface{}{"principalName": "Admins"},
+			map[string]interface{}{"principalName": "Admins"},
+			""},
+	}
+	for _, tt := range tests {
+		err := testNameResolver().resolve(tt.t, tt.x, Bundle{})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: resolve() error: %v", tt.name, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("%s: resolve() = %v, want error containing %q", tt.name, tt.x, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("%s: resolve() error = %q, want error containing %q", tt.name, err, tt.wantErr)
+		case tt.wantErr == "" && !reflect.DeepEqual(tt.x, tt.want):
+			t.Errorf("%s: resolve() = %v, want %v", tt.name, tt.x, tt.want)
+		}
+	}
+}
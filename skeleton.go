@@ -66,7 +66,31 @@ func CreateSkeletonFile(t string) {
 		fileContent = []byte("properties:\n" +
 			"  principalId: 65c6427a-1111-5555-7777-274d26531314  # Group = \"My Special Group\"\n" +
 			"  roleDefinitionId: 2489dfa4-3333-4444-9999-b04b7a1e4ea6  # Role = \"My Special Role\"\n" +
-			"  scope: /providers/Microsoft.Management/managementGroups/3f550b9f-8888-7777-ad61-111199992222\n")
+			"  scope: /providers/Microsoft.Management/managementGroups/3f550b9f-8888-7777-ad61-111199992222\n" +
+			"  # Names can be used instead, and are resolved from the local cache:\n" +
+			"  #   principalName: My Special Group  # UPN, or group, user or SP displayName\n" +
+			"  #   roleName: My Special Role\n" +
+			"  #   scope: sub:Prod-Shared/rg:network  # Or mg:Platform, or tenant\n" +
+			"  description: Optional. Reason for this assignment\n" +
+			"  # Optional ABAC condition, conditionVersion defaults to '2.0'\n" +
+			"  # condition: ((!(ActionMatches{'Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read'}))\n" +
+			"  #   OR (@Resource[Microsoft.Storage/storageAccounts/blobServices/containers:name] StringEquals 'logs'))\n")
+	case "ae":
+		fileName = "role-eligibility.yaml"
+		fileContent = []byte("properties:\n" +
+			"  principalId: 65c6427a-1111-5555-7777-274d26531314  # Group = \"My Special Group\"\n" +
+			"  roleDefinitionId: 2489dfa4-3333-4444-9999-b04b7a1e4ea6  # Role = \"My Special Role\"\n" +
+			"  scope: /subscriptions/8a3b9c0d-5555-4444-aaaa-222233334444\n" +
+			"  justification: Standing eligibility for on-call engineers\n" +
+			"  scheduleInfo:   # Required, marks this as a PIM eligibility rather than an active assignment\n" +
+			"    expiration:\n" +
+			"      type: AfterDuration   # Or NoExpiration, or AfterDateTime with an endDateTime\n" +
+			"      duration: P365D\n")
+	case "ada":
+		fileName = "ad-assignment.yaml"
+		fileContent = []byte("principalId: 65c6427a-1111-5555-7777-274d26531314  # User = \"jdoe@contoso.com\"\n" +
+			"roleDefinitionId: 729827e3-9c14-49f7-bb1b-9608f156bbb8  # Role = \"Helpdesk Administrator\"\n" +
+			"directoryScopeId: /   # Whole tenant, or /administrativeUnits/<UUID>\n")
 	case "aj":
 		fileName = "role-assignment.json"
 		fileContent = []byte("{\n" +
@@ -0,0 +1,165 @@
+package maz
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/queone/utl"
+)
+
+const (
+	ConstSnapshotDir        = "snapshots"        // Sub-directory of ConfDir where snapshots are archived
+	ConstSnapshotTimeFormat = "20060102T150405Z" // Snapshot directory names, always in UTC
+)
+
+// Rules for pruning old snapshots. Zero values mean no limit. With only KeepLast set, all but
+// the most recent KeepLast snapshots are removed. With MaxAgeDays set, snapshots older than
+// that are removed, except for the most recent KeepLast ones.
+type SnapshotRetention struct {
+	KeepLast   int
+	MaxAgeDays int
+}
+
+// Returns the directory under which all snapshots for the current tenant are archived
+func SnapshotBaseDir(z Bundle) string {
+	return filepath.Join(z.ConfDir, ConstSnapshotDir, z.TenantId)
+}
+
+// Archives the current per-type cache files under a new timestamped snapshot directory,
+// then prunes older snapshots according to given retention rules. Returns the name of
+// the new snapshot.
+func CreateCacheSnapshot(retention SnapshotRetention, z Bundle) (name string) {
+	name = time.Now().UTC().Format(ConstSnapshotTimeFormat)
+	snapDir := filepath.Join(SnapshotBaseDir(z), name)
+	if err := os.MkdirAll(snapDir, 0700); err != nil {
+		utl.Die(utl.Trace() + err.Error() + "\n")
+	}
+	count := 0
+	live := z
+	live.CacheDir = "" // Always archive the live caches, even if z points elsewhere
+	for t := range cacheDescriptors {
+		cacheFile := CacheFile(t, live)
+		if !utl.FileUsable(cacheFile) {
+			continue
+		}
+		content, err := os.ReadFile(cacheFile)
+		if err != nil {
+			utl.Die(utl.Trace() + err.Error() + "\n")
+		}
+		snapFile := filepath.Join(snapDir, filepath.Base(cacheFile))
+		if err := os.WriteFile(snapFile, content, 0600); err != nil {
+			utl.Die(utl.Trace() + err.Error() + "\n")
+		}
+		info, _ := os.Stat(cacheFile)
+		os.Chtimes(snapFile, info.ModTime(), info.ModTime()) // Keep original cache ages
+		count++
+	}
+	if count == 0 {
+		os.Remove(snapDir)
+		utl.Die("There are no cache files to snapshot.\n")
+	}
+	PruneCacheSnapshots(retention, z)
+	return name
+}
+
+// Returns the names of all snapshots for the current tenant, oldest first
+func ListCacheSnapshots(z Bundle) (names []string) {
+	entries, err := os.ReadDir(SnapshotBaseDir(z))
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		if _, err := time.Parse(ConstSnapshotTimeFormat, e.Name()); e.IsDir() && err == nil {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names) // Timestamp format sorts chronologically
+	return names
+}
+
+// Removes snapshots that fall outside of given retention rules. Returns the removed names.
+func PruneCacheSnapshots(retention SnapshotRetention, z Bundle) (removed []string) {
+	names := ListCacheSnapshots(z)
+	cutoff := time.Now().UTC().AddDate(0, 0, -retention.MaxAgeDays)
+	for i, name := range names {
+		if retention.KeepLast > 0 && i >= len(names)-retention.KeepLast {
+			continue // One of the most recent ones
+		}
+		taken, _ := time.Parse(ConstSnapshotTimeFormat, name)
+		expired := retention.MaxAgeDays > 0 && taken.Before(cutoff)
+		if (retention.KeepLast > 0 && retention.MaxAgeDays == 0) || expired {
+			if err := os.RemoveAll(filepath.Join(SnapshotBaseDir(z), name)); err != nil {
+				utl.Die(utl.Trace() + err.Error() + "\n")
+			}
+			removed = append(removed, name)
+		}
+	}
+	return removed
+}
+
+// Returns the name of the most recent snapshot taken at or before given date, which can be
+// a snapshot name, an RFC3339 timestamp, or a plain yyyy-mm-dd date (meaning end of that day).
+// Returns an empty string if there is no such snapshot.
+func FindCacheSnapshot(date string, z Bundle) string {
+	var when time.Time
+	var err error
+	if when, err = time.Parse(ConstSnapshotTimeFormat, date); err != nil {
+		if when, err = time.Parse(time.RFC3339, date); err != nil {
+			if when, err = time.Parse("2006-01-02", date); err != nil {
+				utl.Die("Invalid snapshot date '%s'. Use yyyy-mm-dd or RFC3339 format.\n", date)
+			}
+			when = when.Add(24*time.Hour - time.Second) // Anytime during that day
+		}
+	}
+	found := ""
+	for _, name := range ListCacheSnapshots(z) {
+		taken, _ := time.Parse(ConstSnapshotTimeFormat, name)
+		if taken.After(when.UTC()) {
+			break
+		}
+		found = name
+	}
+	return found
+}
+
+// Returns a copy of the Bundle whose cache points at the snapshot in effect on given date.
+// The returned Bundle is read-only, so all GetMatching* and Print* functions that work
+// off the cache can be used on it to look at the tenant as it was at that time.
+func LoadCacheSnapshot(date string, z Bundle) Bundle {
+	name := FindCacheSnapshot(date, z)
+	if name == "" {
+		utl.Die("There is no snapshot on or before %s.\n", date)
+	}
+	z.CacheDir = filepath.Join(SnapshotBaseDir(z), name)
+	z.CacheReadOnly = true
+	return z
+}
+
+// Prints all snapshots for the current tenant, with their object counts per type
+func PrintCacheSnapshots(z Bundle) {
+	names := ListCacheSnapshots(z)
+	if len(names) < 1 {
+		fmt.Println("There are no snapshots for this tenant.")
+		return
+	}
+	var types []string
+	for t := range cacheDescriptors {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, name := range names {
+		snap := z
+		snap.CacheDir = filepath.Join(SnapshotBaseDir(z), name)
+		var counts []string
+		for _, t := range types {
+			if cacheFile := CacheFile(t, snap); utl.FileUsable(cacheFile) {
+				counts = append(counts, fmt.Sprintf("%s=%d", t, len(GetCachedObjects(cacheFile))))
+			}
+		}
+		fmt.Printf("%s  %s\n", utl.Gre(name), strings.Join(counts, " "))
+	}
+}
@@ -0,0 +1,385 @@
+package maz
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/queone/utl"
+)
+
+// Multi-object specfiles. A specfile can hold several definitions and assignments, either as
+// YAML documents separated by '---' lines, or as a JSON (or YAML) array. Objects are processed
+// in the order they appear, and a per-object result report is printed at the end.
+
+// One object from a specfile
+type SpecObject struct {
+	Index      int                    // 1-based position in the specfile
+	FormatType string                 // "JSON" or "YAML"
+	MazType    string                 // "d", "a", "ae" or "ada", or "" if not a recognized specfile object
+	Object     map[string]interface{} // The object itself
+}
+
+// Result of processing one specfile object
+type SpecResult struct {
+	Object SpecObject
+	Status string // "ok", "skipped", "failed", or for comparisons "exists" and "missing"
+	Err    error
+}
+
+var (
+	errAborted  = errors.New("aborted")        // The user answered no at a confirmation prompt
+	errNotFound = errors.New("does not exist") // The object is not in Azure
+)
+
+// Splits YAML content into its documents, on '---' separator lines
+func splitYamlDocuments(content []byte) (docs [][]byte) {
+	var doc bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimRight(line, " \t\r")
+		if trimmed == "---" || strings.HasPrefix(trimmed, "--- ") || trimmed == "..." {
+			docs = append(docs, bytes.Clone(doc.Bytes()))
+			doc.Reset()
+			continue
+		}
+		doc.WriteString(line + "\n")
+	}
+	return append(docs, doc.Bytes())
+}
+
+// Returns true if YAML document has anything besides blank lines and comments
+func yamlDocumentHasContent(doc []byte) bool {
+	for _, line := range strings.Split(string(doc), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the specfile objects in parsed content, which is a single object or an array of them
+func specObjectsFromRaw(formatType string, raw interface{}) (objects []SpecObject) {
+	list, ok := raw.([]interface{})
+	if !ok {
+		list = []interface{}{raw}
+	}
+	for _, i := range list {
+		_, t, x := classifySpecObject(formatType, i)
+		objects = append(objects, SpecObject{FormatType: formatType, MazType: t, Object: x})
+	}
+	return objects
+}
+
+// Returns every object in specfile content. Documents that can't be parsed are returned as
+// objects with an empty MazType, so they are reported rather than silently dropped.
+func GetObjectsFromBytes(content []byte) (objects []SpecObject) {
+	if raw, _ := utl.JsonBytesToJsonObj(content); raw != nil {
+		objects = specObjectsFromRaw("JSON", raw)
+	} else {
+		for _, doc := range splitYamlDocuments(content) {
+			if !yamlDocumentHasContent(doc) {
+				continue
+			}
+			raw, _ := utl.BytesToYamlObject(doc)
+			if raw == nil {
+				objects = append(objects, SpecObject{})
+				continue
+			}
+			objects = append(objects, specObjectsFromRaw("YAML", raw)...)
+		}
+	}
+	for n := range objects {
+		objects[n].Index = n + 1
+	}
+	return objects
+}
+
+// Returns every object in given specfile, after rendering it if it is a template, and
+// resolving the name-based references of each object. Resolution errors are returned by object
+// Index. Dies if the template can't be rendered.
+func GetObjectsFromSpecfile(filePath string, z Bundle) (objects []SpecObject, errs map[int]error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil
+	}
+	if isSpecTemplate(content) {
+		values, err := LoadSpecValues(z)
+		if err != nil {
+			utl.Die("Error loading template values: %s\n", err.Error())
+		}
+		if content, err = RenderSpecTemplate(filePath, content, values); err != nil {
+			utl.Die("Error rendering specfile template: %s\n", err.Error())
+		}
+		objects = GetObjectsFromBytes(content)
+	} else if objects = GetObjectsFromBytes(content); len(objects) == 0 {
+		// Could still be a gzipped JSON specfile, which only GetObjectFromFile reads
+		if formatType, t, x := GetObjectFromFile(filePath); formatType != "" {
+			objects = []SpecObject{{Index: 1, FormatType: formatType, MazType: t, Object: x}}
+		}
+	}
+	errs = map[int]error{}
+	var r nameResolver // Shared by all objects, so each cache is only read once
+	for _, o := range objects {
+		if o.MazType == "" {
+			continue
+		}
+		if err := r.resolve(o.MazType, o.Object, z); err != nil {
+			errs[o.Index] = err
+		}
+	}
+	return objects, errs
+}
+
+// Checks that a specfile object has the attributes its type requires, so a bad object in a
+// multi-object specfile fails on its own instead of ending the whole run
+func validateSpecObject(t string, x map[string]interface{}) error {
+	xProp, _ := x["properties"].(map[string]interface{})
+	switch t {
+	case "d":
+		scopes, _ := xProp["assignableScopes"].([]interface{})
+		perms, _ := xProp["permissions"].([]interface{})
+		if utl.Str(xProp["roleName"]) == "" || len(scopes) < 1 || utl.Str(scopes[0]) == "" || len(perms) < 1 {
+			return fmt.Errorf("role definition needs roleName, assignableScopes and permissions")
+		}
+	case "a", "ae":
+		if utl.Str(xProp["roleDefinitionId"]) == "" || utl.Str(xProp["principalId"]) == "" || utl.Str(xProp["scope"]) == "" {
+			return fmt.Errorf("assignment needs roleDefinitionId, principalId and scope")
+		}
+		if condition := utl.Str(xProp["condition"]); condition != "" {
+			if err := ValidateAbacCondition(condition); err != nil {
+				return fmt.Errorf("invalid condition: %s", err.Error())
+			}
+		}
+	case "ada":
+		if utl.Str(x["principalId"]) == "" || utl.Str(x["roleDefinitionId"]) == "" {
+			return fmt.Errorf("directory role assignment needs principalId and roleDefinitionId")
+		}
+	}
+	return nil
+}
+
+// Returns a short description of a specfile object for the result report
+func describeSpecObject(o SpecObject) string {
+	xProp, _ := o.Object["properties"].(map[string]interface{})
+	switch o.MazType {
+	case "d":
+		return "role definition '" + utl.Str(xProp["roleName"]) + "'"
+	case "a", "ae":
+		kind := "role assignment"
+		if o.MazType == "ae" {
+			kind = "role eligibility"
+		}
+		return fmt.Sprintf("%s %s -> %s @ %s", kind, utl.LastElem(utl.Str(xProp["roleDefinitionId"]), "/"),
+			utl.Str(xProp["principalId"]), utl.Str(xProp["scope"]))
+	case "ada":
+		return fmt.Sprintf("directory role assignment %s -> %s", utl.Str(o.Object["roleDefinitionId"]), utl.Str(o.Object["principalId"]))
+	}
+	return "unrecognized object"
+}
+
+// Runs fn on every object in order, and returns the results. Objects that are not valid
+// specfile objects, whose names could not be resolved, or that are missing required
+// attributes, fail without running fn. API errors are printed as they happen.
+func processSpecObjects(objects []SpecObject, errs map[int]error, fn func(o SpecObject) (string, error)) (results []SpecResult) {
+	for _, o := range objects {
+		fmt.Printf("%s\n", utl.Blu(fmt.Sprintf("# Object %d of %d: %s", o.Index, len(objects), describeSpecObject(o))))
+		result := SpecResult{Object: o}
+		err := errs[o.Index]
+		if o.MazType == "" {
+			err = fmt.Errorf("not a role definition or assignment")
+		} else if err == nil {
+			err = validateSpecObject(o.MazType, o.Object)
+		}
+		if err != nil {
+			result.Status, result.Err = "failed", err
+		} else {
+			result.Status, result.Err = fn(o)
+			if errors.Is(result.Err, errAborted) {
+				result.Status, result.Err = "skipped", nil
+			} else if result.Err != nil {
+				result.Status = "failed"
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Prints the per-object result report, and returns the number of failures
+func PrintSpecResults(results []SpecResult) (failed int) {
+	fmt.Println(utl.Blu("results") + ":")
+	for _, r := range results {
+		padded := fmt.Sprintf("%-8s", r.Status) // Pad before coloring, escape codes throw off widths
+		status := utl.Gre(padded)
+		switch r.Status {
+		case "failed":
+			status = utl.Red(padded)
+			failed++
+		case "skipped", "missing":
+			status = utl.Yel(padded)
+		}
+		line := fmt.Sprintf("  %3d  %s  %s", r.Object.Index, status, describeSpecObject(r.Object))
+		if r.Err != nil {
+			line += "  # " + r.Err.Error()
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("%d objects, %d failed\n", len(results), failed)
+	return failed
+}
+
+// Prints the result report and exits, with a non-zero status if any object failed
+func exitWithSpecResults(results []SpecResult) {
+	if PrintSpecResults(results) > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Creates or updates one specfile object, prompting before updating an existing role
+// definition unless force is true
+func upsertSpecObject(force bool, o SpecObject, z Bundle) (string, error) {
+	switch o.MazType {
+	case "d":
+		xProp := o.Object["properties"].(map[string]interface{})
+		if xProp["type"] == nil {
+			xProp["type"] = "CustomRole"
+		}
+		if xProp["description"] == nil {
+			xProp["description"] = ""
+		}
+		roleId, err := roleSpecId(o.Object)
+		if err != nil {
+			return "", err
+		}
+		if existing := GetAzRoleDefinitionByName(utl.Str(xProp["roleName"]), z); existing != nil {
+			PrintRoleDefinition(existing, z)
+			if !force && utl.PromptMsg(utl.Yel("Role already exists! UPDATE it? y/n ")) != 'y' {
+				return "", errAborted
+			}
+			roleId = utl.Str(existing["name"])
+		} else if roleId == "" {
+			roleId = uuid.New().String()
+		}
+		r := PutAzRoleDefinition(roleId, o.Object, z)
+		if r == nil {
+			return "", fmt.Errorf("role definition was not saved")
+		}
+		PrintRoleDefinition(r, z)
+		return "ok", nil
+	case "a":
+		return "ok", CreateAzRoleAssignment(o.Object, z)
+	case "ae":
+		return "ok", CreateAzRoleEligibilityRequest(o.Object, z)
+	case "ada":
+		return "ok", CreateAzAdRoleAssignment(o.Object, z)
+	}
+	return "", fmt.Errorf("unsupported object type")
+}
+
+// Deletes the Azure object matching one specfile object, prompting first unless force is true
+func deleteSpecObject(force bool, o SpecObject, z Bundle) (string, error) {
+	confirm := func(msg string) error {
+		if !force && utl.PromptMsg(msg) != 'y' {
+			return errAborted
+		}
+		return nil
+	}
+	switch o.MazType {
+	case "d":
+		y := GetAzRoleDefinitionByObject(o.Object, z)
+		if y == nil {
+			return "", fmt.Errorf("role definition %w", errNotFound)
+		}
+		PrintRoleDefinition(y, z)
+		if err := confirm("DELETE above? y/n "); err != nil {
+			return "", err
+		}
+		return "ok", DeleteAzRoleDefinitionByFqid(utl.Str(y["id"]), z)
+	case "a":
+		y := GetAzRoleAssignmentByObject(o.Object, z)
+		if y == nil {
+			return "", fmt.Errorf("role assignment %w", errNotFound)
+		}
+		PrintRoleAssignment(y, z)
+		if err := confirm("DELETE above? y/n "); err != nil {
+			return "", err
+		}
+		return "ok", DeleteAzRoleAssignmentByFqid(utl.Str(y["id"]), z)
+	case "ae":
+		y := GetAzRoleEligibilityByObject(o.Object, z)
+		if y == nil {
+			return "", fmt.Errorf("role eligibility %w", errNotFound)
+		}
+		PrintPimAssignment(y, z)
+		if err := confirm("REMOVE above eligibility? y/n "); err != nil {
+			return "", err
+		}
+		return "ok", RemoveAzRoleEligibility(o.Object, z)
+	case "ada":
+		y := GetAzAdRoleAssignmentByObject(o.Object, z)
+		if y == nil {
+			return "", fmt.Errorf("directory role assignment %w", errNotFound)
+		}
+		PrintAdRoleAssignment(y, z)
+		if err := confirm("DELETE above? y/n "); err != nil {
+			return "", err
+		}
+		if err := DeleteAzAdRoleAssignmentById(utl.Str(y["id"]), z); err != nil {
+			fmt.Println(err.Error())
+			return "", err
+		}
+		return "ok", nil
+	}
+	return "", fmt.Errorf("unsupported object type")
+}
+
+// Compares one specfile object to what is in Azure, printing the Azure object and any
+// differences if it exists
+func compareSpecObject(o SpecObject, z Bundle) (string, error) {
+	switch o.MazType {
+	case "d":
+		azureDef := GetAzRoleDefinitionByObject(o.Object, z)
+		if azureDef == nil {
+			fileRoleName := utl.Str(o.Object["properties"].(map[string]interface{})["roleName"])
+			fmt.Printf("Role " + utl.Mag(fileRoleName) + " as defined in specfile does " + utl.Red("not") + " exist in Azure.\n")
+			return "missing", nil
+		}
+		fmt.Printf("Role definition in specfile " + utl.Gre("already") + " exist in Azure. See details below:\n")
+		DiffRoleDefinitionSpecfileVsAzure(o.Object, azureDef, z)
+	case "ada":
+		azureDef := GetAzAdRoleAssignmentByObject(o.Object, z)
+		if azureDef == nil {
+			fmt.Printf("Directory role assignment in specfile does " + utl.Red("not") + " exist in Azure.\n")
+			return "missing", nil
+		}
+		fmt.Printf("Directory role assignment in specfile " + utl.Gre("already") + " exist in Azure. See details below:\n")
+		PrintAdRoleAssignment(azureDef, z)
+	case "ae":
+		azureDef := GetAzRoleEligibilityByObject(o.Object, z)
+		if azureDef == nil {
+			fmt.Printf("Role eligibility in specfile does " + utl.Red("not") + " exist in Azure.\n")
+			return "missing", nil
+		}
+		fmt.Printf("Role eligibility in specfile " + utl.Gre("already") + " exist in Azure. See details below:\n")
+		PrintPimAssignment(azureDef, z)
+	default:
+		azureDef := GetAzRoleAssignmentByObject(o.Object, z)
+		if azureDef == nil {
+			fmt.Printf("Role assignment in specfile does " + utl.Red("not") + " exist in Azure.\n")
+			return "missing", nil
+		}
+		fmt.Printf("Role assignment in specfile " + utl.Gre("already") + " exist in Azure. See details below:\n")
+		PrintRoleAssignment(azureDef, z)
+		DiffRoleAssignmentSpecfileVsAzure(o.Object, azureDef)
+	}
+	return "exists", nil
+}
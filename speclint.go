@@ -0,0 +1,458 @@
+package maz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/queone/utl"
+	"gopkg.in/yaml.v3"
+)
+
+// Offline specfile validation. Checks role definition and assignment specfiles against the
+// layout maz and Azure expect, without calling any API, and reports each problem with the
+// line it is on. The only Azure data used is what is already cached: role definitions, to catch
+// custom role names that collide with built-in roles, and provider operations, to catch actions
+// that don't match any operation. Since the cached catalog can be out of date, action mismatches
+// are only warnings, and don't stop an upsert; CheckSpecfileActions checks them strictly.
+
+// One problem found in a specfile
+type SpecDiagnostic struct {
+	File     string
+	Line     int    // 1-based, 0 if not known
+	Severity string // "error" or "warning"
+	Message  string
+}
+
+func (d SpecDiagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+}
+
+// Expected value kinds in the specfile schema
+const (
+	lintString     = iota
+	lintUuid       // A UUID string
+	lintRoleId     // A UUID, or a fully qualified role definition id
+	lintScope      // A fully qualified or friendly scope
+	lintStringList // A list of strings
+	lintScopeList  // A list of scopes
+	lintObject     // An object, checked against a nested schema if there is one
+	lintList       // A list of objects, checked against a nested schema if there is one
+	lintAny        // Anything, e.g. read-only attributes Azure returns
+)
+
+// Keys allowed at one level of a specfile object, with the kind of value each must have
+type lintSchema map[string]int
+
+var lintSchemas = map[string]map[string]lintSchema{
+	"d": {
+		"":            {"properties": lintObject, "name": lintUuid, "id": lintString, "type": lintString},
+		"properties":  {"roleName": lintString, "description": lintString, "type": lintString, "assignableScopes": lintScopeList, "permissions": lintList, "createdOn": lintAny, "updatedOn": lintAny, "createdBy": lintAny, "updatedBy": lintAny},
+		"permissions": {"actions": lintStringList, "notActions": lintStringList, "dataActions": lintStringList, "notDataActions": lintStringList, "condition": lintString, "conditionVersion": lintString},
+	},
+	"a": {
+		"":           {"properties": lintObject, "name": lintUuid, "id": lintString, "type": lintString},
+		"properties": {"roleDefinitionId": lintRoleId, "roleName": lintString, "principalId": lintUuid, "principalName": lintString, "principalType": lintString, "scope": lintScope, "description": lintString, "condition": lintString, "conditionVersion": lintString, "delegatedManagedIdentityResourceId": lintString, "createdOn": lintAny, "updatedOn": lintAny, "createdBy": lintAny, "updatedBy": lintAny},
+	},
+	"ae": {
+		"":             {"properties": lintObject, "name": lintUuid, "id": lintString, "type": lintString},
+		"properties":   {"roleDefinitionId": lintRoleId, "roleName": lintString, "principalId": lintUuid, "principalName": lintString, "principalType": lintString, "scope": lintScope, "justification": lintString, "scheduleInfo": lintObject, "condition": lintString, "conditionVersion": lintString},
+		"scheduleInfo": {"startDateTime": lintString, "expiration": lintObject},
+		"expiration":   {"type": lintString, "endDateTime": lintString, "duration": lintString},
+	},
+	"ada": {
+		"": {"principalId": lintUuid, "principalName": lintString, "roleDefinitionId": lintString, "directoryScopeId": lintString, "appScopeId": lintString, "justification": lintString},
+	},
+}
+
+// Matches YAML list items and values that start with an unquoted '*', which YAML reads as an alias
+var unquotedAsteriskRe = regexp.MustCompile(`^(\s*(?:-\s+|[A-Za-z]+:\s+))(\*[^#]*?)(\s*(?:#.*)?)$`)
+
+// Matches the line number in yaml.v3 parse errors
+var yamlErrLineRe = regexp.MustCompile(`line (\d+)`)
+
+// Validates every object in a specfile, rendering it first if it is a template. Gzipped
+// specfiles are read the same as plain ones. Returns the diagnostics sorted by line.
+func ValidateSpecfile(filePath string, z Bundle) (diags []SpecDiagnostic) {
+	add := func(line int, severity, format string, args ...interface{}) {
+		diags = append(diags, SpecDiagnostic{File: filePath, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		add(0, "error", "%s", err.Error())
+		return diags
+	}
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		if zr, err := gzip.NewReader(bytes.NewReader(content)); err == nil {
+			content, err = io.ReadAll(zr)
+			if err != nil {
+				add(0, "error", "%s", err.Error())
+				return diags
+			}
+		}
+	}
+	if isSpecTemplate(content) {
+		values, err := LoadSpecValues(z)
+		if err == nil {
+			content, err = RenderSpecTemplate(filePath, content, values)
+		}
+		if err != nil {
+			add(0, "error", "template: %s", err.Error())
+			return diags
+		}
+	}
+	builtinNames := map[string]bool{}
+	for _, i := range GetCachedObjects(CacheFile("d", z)) {
+		if xProp, ok := i.(map[string]interface{})["properties"].(map[string]interface{}); ok && utl.Str(xProp["type"]) != "CustomRole" {
+			builtinNames[strings.ToLower(utl.Str(xProp["roleName"]))] = true
+		}
+	}
+	catalog := getCachedOperationCatalog(z) // Actions are only checked if the catalog is cached
+	return lintSpecContent(filePath, content, builtinNames, catalog)
+}
+
+// Validates every object in rendered specfile content, against given lowercased built-in role
+// names and operations catalog, which may be nil. Returns the diagnostics sorted by line.
+func lintSpecContent(filePath string, content []byte, builtinNames map[string]bool, catalog *OperationCatalog) (diags []SpecDiagnostic) {
+	add := func(line int, severity, format string, args ...interface{}) {
+		diags = append(diags, SpecDiagnostic{File: filePath, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Quote leading asterisks so the rest of the file can still be parsed and checked
+	lines := strings.Split(string(content), "\n")
+	for n, line := range lines {
+		if m := unquotedAsteriskRe.FindStringSubmatch(line); m != nil {
+			add(n+1, "error", "value %s starts with an unquoted '*', which YAML reads as an alias; wrap it in single quotes", strings.TrimSpace(m[2]))
+			lines[n] = m[1] + "'" + strings.TrimSpace(m[2]) + "'" + m[3]
+		}
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(strings.Join(lines, "\n")))
+	objects := 0
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if err.Error() != "EOF" {
+				line := 0
+				if m := yamlErrLineRe.FindStringSubmatch(err.Error()); m != nil {
+					line, _ = strconv.Atoi(m[1])
+				}
+				add(line, "error", "not valid YAML or JSON: %s", strings.TrimPrefix(err.Error(), "yaml: "))
+			}
+			break
+		}
+		if len(doc.Content) == 0 {
+			continue // Empty document
+		}
+		root := doc.Content[0]
+		items := []*yaml.Node{root}
+		if root.Kind == yaml.SequenceNode {
+			items = root.Content // A JSON or YAML array of objects
+		}
+		for _, node := range items {
+			objects++
+			diags = append(diags, lintSpecObject(filePath, node, builtinNames, catalog)...)
+		}
+	}
+	if objects == 0 && len(diags) == 0 {
+		add(0, "error", "no objects found")
+	}
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Line < diags[j].Line })
+	return diags
+}
+
+// Validates one specfile object node. Role definition actions are checked against catalog,
+// unless it is nil.
+func lintSpecObject(file string, node *yaml.Node, builtinNames map[string]bool, catalog *OperationCatalog) (diags []SpecDiagnostic) {
+	add := func(line int, severity, format string, args ...interface{}) {
+		diags = append(diags, SpecDiagnostic{File: file, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+	if node.Kind != yaml.MappingNode {
+		add(node.Line, "error", "expected an object, found %s", lintKindName(node))
+		return diags
+	}
+	var raw interface{}
+	if err := node.Decode(&raw); err != nil {
+		add(node.Line, "error", "%s", err.Error())
+		return diags
+	}
+	_, t, x := classifySpecObject("YAML", raw)
+	if t == "" {
+		add(node.Line, "error", "not a role definition or assignment: need properties with roleName, "+
+			"or properties with roleDefinitionId and principalId, or a flat directory role assignment")
+		return diags
+	}
+	schemas := lintSchemas[t]
+	var walk func(n *yaml.Node, schemaName, path string)
+	walk = func(n *yaml.Node, schemaName, path string) {
+		schema := schemas[schemaName]
+		for k := 0; k+1 < len(n.Content); k += 2 {
+			keyNode, valNode := n.Content[k], n.Content[k+1]
+			key := keyNode.Value
+			kind, known := schema[key]
+			if !known {
+				for name := range schema {
+					if strings.EqualFold(name, key) {
+						add(keyNode.Line, "warning", "unknown key '%s%s', did you mean '%s'?", path, key, name)
+						known = true
+						break
+					}
+				}
+				if !known {
+					add(keyNode.Line, "warning", "unknown key '%s%s'", path, key)
+				}
+				continue
+			}
+			diags = append(diags, lintValue(file, path+key, kind, valNode)...)
+			if catalog != nil && schemaName == "permissions" && kind == lintStringList {
+				for _, item := range valNode.Content {
+					if item.Kind != yaml.ScalarNode || item.Tag != "!!str" {
+						continue
+					}
+					if err := catalog.CheckAction(item.Value, dataActionFields[key]); err != nil {
+						add(item.Line, "warning", "%s%s: %s", path, key, err.Error())
+					}
+				}
+			}
+			switch {
+			case kind == lintObject && valNode.Kind == yaml.MappingNode && schemas[key] != nil:
+				walk(valNode, key, path+key+".")
+			case kind == lintList && valNode.Kind == yaml.SequenceNode && schemas[key] != nil:
+				for _, item := range valNode.Content {
+					if item.Kind == yaml.MappingNode {
+						walk(item, key, path+key+"[].")
+					}
+				}
+			}
+		}
+	}
+	walk(node, "", "")
+
+	// Checks that need the object as a whole
+	propsNode := lintChild(node, "properties")
+	switch t {
+	case "d":
+		xProp := x["properties"].(map[string]interface{})
+		roleName := utl.Str(xProp["roleName"])
+		if builtinNames[strings.ToLower(roleName)] {
+			add(lintLine(propsNode, "roleName", node), "error", "roleName '%s' is the name of a built-in role", roleName)
+		}
+		if scopes, _ := xProp["assignableScopes"].([]interface{}); len(scopes) == 0 {
+			add(lintLine(propsNode, "assignableScopes", node), "error", "properties.assignableScopes needs at least one scope")
+		}
+		if perms := lintChild(propsNode, "permissions"); perms == nil {
+			add(lintLine(propsNode, "", node), "error", "properties.permissions is missing")
+		} else if perms.Kind == yaml.SequenceNode && len(perms.Content) != 1 {
+			add(perms.Line, "error", "properties.permissions must have exactly one block, found %d", len(perms.Content))
+		}
+	case "a", "ae":
+		xProp := x["properties"].(map[string]interface{})
+		for _, pair := range [][2]string{{"roleDefinitionId", "roleName"}, {"principalId", "principalName"}} {
+			if xProp[pair[0]] == nil && xProp[pair[1]] == nil {
+				add(lintLine(propsNode, "", node), "error", "properties needs %s or %s", pair[0], pair[1])
+			}
+		}
+		if xProp["scope"] == nil {
+			add(lintLine(propsNode, "", node), "error", "properties.scope is missing")
+		}
+		if c := utl.Str(xProp["condition"]); c != "" {
+			if err := ValidateAbacCondition(c); err != nil {
+				add(lintLine(propsNode, "condition", node), "error", "properties.condition: %s", err.Error())
+			}
+		} else if xProp["conditionVersion"] != nil {
+			add(lintLine(propsNode, "conditionVersion", node), "error", "properties.conditionVersion without a condition")
+		}
+		if v := utl.Str(xProp["conditionVersion"]); v != "" && v != ConstAbacConditionVersion {
+			add(lintLine(propsNode, "conditionVersion", node), "error", "properties.conditionVersion must be '%s'", ConstAbacConditionVersion)
+		}
+	}
+	return diags
+}
+
+// Checks that a value node has the expected kind
+func lintValue(file, path string, kind int, n *yaml.Node) (diags []SpecDiagnostic) {
+	add := func(line int, format string, args ...interface{}) {
+		diags = append(diags, SpecDiagnostic{File: file, Line: line, Severity: "error", Message: fmt.Sprintf(format, args...)})
+	}
+	isString := n.Kind == yaml.ScalarNode && (n.Tag == "!!str" || n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) != 0)
+	switch kind {
+	case lintString, lintUuid, lintRoleId, lintScope:
+		if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+			return diags // Treated as absent
+		}
+		if !isString {
+			add(n.Line, "%s must be a string, found %s", path, lintKindName(n))
+			return diags
+		}
+		switch kind {
+		case lintUuid:
+			if !utl.ValidUuid(n.Value) {
+				add(n.Line, "%s '%s' is not a valid UUID", path, n.Value)
+			}
+		case lintRoleId:
+			if !utl.ValidUuid(utl.LastElem(n.Value, "/")) {
+				add(n.Line, "%s '%s' is not a role definition UUID or fully qualified id", path, n.Value)
+			}
+		case lintScope:
+			if err := checkScopeSyntax(n.Value); err != nil {
+				add(n.Line, "%s: %s", path, err.Error())
+			}
+		}
+	case lintStringList, lintScopeList:
+		if n.Kind != yaml.SequenceNode {
+			add(n.Line, "%s must be a list, found %s", path, lintKindName(n))
+			return diags
+		}
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode || item.Tag != "!!str" {
+				add(item.Line, "%s items must be strings, found %s", path, lintKindName(item))
+			} else if kind == lintScopeList {
+				if err := checkScopeSyntax(item.Value); err != nil {
+					add(item.Line, "%s: %s", path, err.Error())
+				}
+			}
+		}
+	case lintObject:
+		if n.Kind != yaml.MappingNode {
+			add(n.Line, "%s must be an object, found %s", path, lintKindName(n))
+		}
+	case lintList:
+		if n.Kind != yaml.SequenceNode {
+			add(n.Line, "%s must be a list, found %s", path, lintKindName(n))
+		}
+	}
+	return diags
+}
+
+// Checks the syntax of a fully qualified or friendly scope, without resolving any names
+func checkScopeSyntax(scope string) error {
+	if scope == "/" || strings.EqualFold(scope, "tenant") {
+		return nil
+	}
+	if !strings.HasPrefix(scope, "/") {
+		kind, name, _ := strings.Cut(strings.Split(scope, "/")[0], ":")
+		if (strings.EqualFold(kind, "mg") || strings.EqualFold(kind, "sub")) && name != "" {
+			return nil
+		}
+		return fmt.Errorf("scope '%s' is neither fully qualified nor one of tenant, mg:<name> or sub:<name>[/rg:<name>]", scope)
+	}
+	if strings.HasSuffix(scope, "/") || strings.Contains(scope, "//") {
+		return fmt.Errorf("scope '%s' has an empty path element", scope)
+	}
+	split := strings.Split(scope, "/")
+	switch {
+	case strings.EqualFold(split[1], "subscriptions"):
+		if len(split) < 3 || !utl.ValidUuid(split[2]) {
+			return fmt.Errorf("scope '%s' does not have a valid subscription UUID", scope)
+		}
+		if len(split) > 3 && (!strings.EqualFold(split[3], "resourceGroups") || len(split) < 5) {
+			return fmt.Errorf("scope '%s' must continue with /resourceGroups/<name> after the subscription", scope)
+		}
+	case strings.EqualFold(split[1], "providers"):
+		if len(split) != 5 || !strings.EqualFold(split[2], "Microsoft.Management") || !strings.EqualFold(split[3], "managementGroups") {
+			return fmt.Errorf("scope '%s' is not a /providers/Microsoft.Management/managementGroups/<id> scope", scope)
+		}
+	default:
+		return fmt.Errorf("scope '%s' must start with /subscriptions or /providers/Microsoft.Management", scope)
+	}
+	return nil
+}
+
+// Returns the value node of key in a mapping node, or nil
+func lintChild(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for k := 0; k+1 < len(n.Content); k += 2 {
+		if n.Content[k].Value == key {
+			return n.Content[k+1]
+		}
+	}
+	return nil
+}
+
+// Returns the line of key in mapping node n, or of n itself if key is empty or missing, or of
+// fallback if n is nil
+func lintLine(n *yaml.Node, key string, fallback *yaml.Node) int {
+	if n == nil {
+		return fallback.Line
+	}
+	if key != "" {
+		for k := 0; k+1 < len(n.Content); k += 2 {
+			if n.Content[k].Value == key {
+				return n.Content[k].Line
+			}
+		}
+	}
+	return n.Line
+}
+
+// Returns a readable name for the kind of a node
+func lintKindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "an object"
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.AliasNode:
+		return "an alias"
+	}
+	switch n.Tag {
+	case "!!int", "!!float":
+		return "a number"
+	case "!!bool":
+		return "a boolean"
+	case "!!null":
+		return "null"
+	}
+	return "a string"
+}
+
+// Prints diagnostics, and returns the number of errors among them
+func PrintSpecDiagnostics(diags []SpecDiagnostic) (errors int) {
+	for _, d := range diags {
+		severity := utl.Yel(d.Severity)
+		if d.Severity == "error" {
+			severity = utl.Red(d.Severity)
+			errors++
+		}
+		fmt.Printf("%s:%d: %s: %s\n", d.File, d.Line, severity, d.Message)
+	}
+	return errors
+}
+
+// Validates every specfile in given files and directories, and prints the diagnostics.
+// Directories are searched for *.yaml, *.yml and *.json files. Returns the number of errors.
+func LintSpecfiles(paths []string, z Bundle) (errors int) {
+	files, warnings := 0, 0
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := strings.ToLower(filepath.Ext(p))
+			if d.IsDir() || (p != path && ext != ".yaml" && ext != ".yml" && ext != ".json") {
+				return nil
+			}
+			files++
+			diags := ValidateSpecfile(p, z)
+			n := PrintSpecDiagnostics(diags)
+			errors += n
+			warnings += len(diags) - n
+			return nil
+		})
+		if err != nil {
+			fmt.Println(utl.Red(err.Error()))
+			errors++
+		}
+	}
+	fmt.Printf("%d files, %d errors, %d warnings\n", files, errors, warnings)
+	return errors
+}
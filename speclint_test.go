@@ -0,0 +1,276 @@
+package maz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCheckScopeSyntax(t *testing.T) {
+	sub := "/subscriptions/12345678-1234-1234-1234-123456789012"
+	tests := []struct {
+		scope   string
+		wantErr string // Substring of the expected error, "" for a valid scope
+	}{
+		{"/", ""},
+		{"tenant", ""},
+		{"Tenant", ""},
+		{"mg:Platform", ""},
+		{"sub:Prod-Shared", ""},
+		{"sub:Prod-Shared/rg:network", ""},
+		{"SUB:Prod", ""},
+		{sub, ""},
+		{sub + "/resourceGroups/rg1", ""},
+		{sub + "/resourcegroups/rg1/providers/Microsoft.Storage/storageAccounts/sa1", ""},
+		{"/providers/Microsoft.Management/managementGroups/mg1", ""},
+		{"/providers/microsoft.management/managementgroups/mg1", ""},
+		{"mg:", "neither fully qualified"},
+		{"rg:network", "neither fully qualified"},
+		{"Platform", "neither fully qualified"},
+		{sub + "/", "empty path element"},
+		{"/subscriptions//resourceGroups/rg1", "empty path element"},
+		{"/subscriptions/not-a-uuid", "valid subscription UUID"},
+		{"/subscriptions", "valid subscription UUID"},
+		{sub + "/resourceGroups", "must continue with /resourceGroups/<name>"},
+		{sub + "/providers/Microsoft.Storage", "must continue with /resourceGroups/<name>"},
+		{"/providers/Microsoft.Management/managementGroups", "not a /providers/Microsoft.Management"},
+		{"/providers/Microsoft.Compute/virtualMachines/vm1", "not a /providers/Microsoft.Management"},
+		{"/resourceGroups/rg1", "must start with /subscriptions"},
+	}
+	for _, tt := range tests {
+		err := checkScopeSyntax(tt.scope)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("checkScopeSyntax(%q) error: %v", tt.scope, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("checkScopeSyntax(%q) = nil, want error containing %q", tt.scope, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("checkScopeSyntax(%q) = %q, want error containing %q", tt.scope, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLintValue(t *testing.T) {
+	tests := []struct {
+		kind    int
+		value   string // YAML value
+		wantErr string // Substring of the expected error, "" for a valid value
+	}{
+		{lintString, `abc`, ""},
+		{lintString, `'123'`, ""},
+		{lintString, `~`, ""}, // Null is treated as absent
+		{lintString, `123`, "must be a string, found a number"},
+		{lintString, `true`, "must be a string, found a boolean"},
+		{lintString, `[a]`, "must be a string, found a list"},
+		{lintString, `{a: b}`, "must be a string, found an object"},
+		{lintUuid, `12345678-1234-1234-1234-123456789012`, ""},
+		{lintUuid, `not-a-uuid`, "is not a valid UUID"},
+		{lintRoleId, `12345678-1234-1234-1234-123456789012`, ""},
+		{lintRoleId, `/providers/Microsoft.Authorization/roleDefinitions/12345678-1234-1234-1234-123456789012`, ""},
+		{lintRoleId, `/providers/Microsoft.Authorization/roleDefinitions/Reader`, "not a role definition UUID"},
+		{lintScope, `sub:Prod/rg:network`, ""},
+		{lintScope, `/nowhere`, "must start with /subscriptions"},
+		{lintStringList, `[a, 'b']`, ""},
+		{lintStringList, `[]`, ""},
+		{lintStringList, `a`, "must be a list, found a string"},
+		{lintStringList, `[a, 1]`, "items must be strings, found a number"},
+		{lintStringList, `[a, [b]]`, "items must be strings, found a list"},
+		{lintScopeList, `[/, tenant]`, ""},
+		{lintScopeList, `[/, rg:x]`, "neither fully qualified"},
+		{lintObject, `{a: b}`, ""},
+		{lintObject, `[a]`, "must be an object, found a list"},
+		{lintList, `[{a: b}]`, ""},
+		{lintList, `{a: b}`, "must be a list, found an object"},
+		{lintAny, `[1, {a: b}]`, ""},
+	}
+	for _, tt := range tests {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte("v: "+tt.value), &doc); err != nil {
+			t.Fatalf("bad test YAML %q: %v", tt.value, err)
+		}
+		diags := lintValue("f", "key", tt.kind, doc.Content[0].Content[1])
+		switch {
+		case tt.wantErr == "" && len(diags) > 0:
+			t.Errorf("lintValue(%d, %q) = %v, want no diagnostics", tt.kind, tt.value, diags)
+		case tt.wantErr != "" && len(diags) != 1:
+			t.Errorf("lintValue(%d, %q) = %v, want one diagnostic containing %q", tt.kind, tt.value, diags, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(diags[0].Message, tt.wantErr):
+			t.Errorf("lintValue(%d, %q) = %q, want diagnostic containing %q", tt.kind, tt.value, diags[0].Message, tt.wantErr)
+		}
+	}
+}
+
+func TestUnquotedAsteriskRe(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // The rewritten line, "" if it must not match
+	}{
+		{`      - */read`, `      - '*/read'`},
+		{`  - *`, `  - '*'`},
+		{`      - */read  # everything`, `      - '*/read'  # everything`},
+		{`  scope: *`, `  scope: '*'`},
+		{`- '*/read'`, ""},
+		{`- "*/read"`, ""},
+		{`- Microsoft.Compute/*`, ""},
+		{`# - */read`, ""},
+		{`anchor: &x value`, ""},
+	}
+	for _, tt := range tests {
+		m := unquotedAsteriskRe.FindStringSubmatch(tt.line)
+		got := ""
+		if m != nil {
+			got = m[1] + "'" + strings.TrimSpace(m[2]) + "'" + m[3]
+		}
+		if got != tt.want {
+			t.Errorf("unquotedAsteriskRe on %q rewrites to %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+// Returns diagnostics as "line severity message" strings, for comparing against expected ones
+func diagStrings(diags []SpecDiagnostic) (list []string) {
+	for _, d := range diags {
+		list = append(list, fmt.Sprintf("%d %s %s", d.Line, d.Severity, d.Message))
+	}
+	return list
+}
+
+func TestLintSpecContent(t *testing.T) {
+	sub := "/subscriptions/12345678-1234-1234-1234-123456789012"
+	builtinNames := map[string]bool{"reader": true}
+	catalog := testCatalog()
+	tests := []struct {
+		name    string
+		content string
+		want    []string // "line severity message" prefix of each diagnostic, in order
+	}{
+		{"valid role definition", `
+properties:
+  roleName: My Reader
+  assignableScopes:
+    - ` + sub + `
+  permissions:
+    - actions:
+        - '*/read'
+`, nil},
+		{"unquoted asterisk", `
+properties:
+  roleName: My Reader
+  assignableScopes: [/]
+  permissions:
+    - actions:
+        - */read
+`, []string{"7 error value */read starts with an unquoted '*'"}},
+		{"built-in role name", `
+properties:
+  roleName: READER
+  assignableScopes: [/]
+  permissions:
+    - actions: [Microsoft.Compute/disks/read]
+`, []string{"3 error roleName 'READER' is the name of a built-in role"}},
+		{"two permissions blocks", `
+properties:
+  roleName: Two
+  assignableScopes: [/]
+  permissions:
+    - actions: [Microsoft.Compute/disks/read]
+    - actions: [Microsoft.Compute/disks/write]
+`, []string{"6 error properties.permissions must have exactly one block, found 2"}},
+		{"no permissions blocks", `
+properties:
+  roleName: None
+  assignableScopes: [/]
+  permissions: []
+`, []string{"5 error properties.permissions must have exactly one block, found 0"}},
+		{"missing permissions and scopes", `
+properties:
+  roleName: Missing
+`, []string{
+			"3 error properties.assignableScopes needs at least one scope",
+			"3 error properties.permissions is missing",
+		}},
+		{"catalog mismatches are warnings", `
+properties:
+  roleName: Typo
+  assignableScopes: [/]
+  permissions:
+    - actions:
+        - Microsoft.Compte/disks/read
+      dataActions:
+        - Microsoft.Compute/disks/read
+`, []string{
+			"7 warning properties.permissions[].actions: 'Microsoft.Compte/disks/read' has unknown provider",
+			"9 warning properties.permissions[].dataActions: 'Microsoft.Compute/disks/read' only matches control plane actions",
+		}},
+		{"unknown keys", `
+properties:
+  roleName: Keys
+  AssignableScopes: [/]
+  assignableScopes: [/]
+  colour: red
+  permissions:
+    - actions: [Microsoft.Compute/disks/read]
+`, []string{
+			"4 warning unknown key 'properties.AssignableScopes', did you mean 'assignableScopes'?",
+			"6 warning unknown key 'properties.colour'",
+		}},
+		{"assignment line numbers", `
+properties:
+  roleDefinitionId: 12345678-1234-1234-1234-123456789012
+  principalId: nope
+  scope: /nowhere
+  conditionVersion: "2.0"
+`, []string{
+			"4 error properties.principalId 'nope' is not a valid UUID",
+			"5 error properties.scope: scope '/nowhere' must start with /subscriptions",
+			"6 error properties.conditionVersion without a condition",
+		}},
+		{"multiple documents", `
+properties:
+  roleName: Good
+  assignableScopes: [/]
+  permissions:
+    - actions: [Microsoft.Compute/disks/read]
+---
+properties:
+  roleDefinitionId: 12345678-1234-1234-1234-123456789012
+  principalName: someone@example.com
+`, []string{"9 error properties.scope is missing"}},
+		{"not a specfile object", `
+foo: bar
+`, []string{"2 error not a role definition or assignment"}},
+		{"bad YAML", `
+properties: {}
+oops
+`, []string{"3 error not valid YAML or JSON: line 3: could not find expected ':'"}},
+		{"empty", `
+# Nothing here
+`, []string{"0 error no objects found"}},
+	}
+	for _, tt := range tests {
+		got := diagStrings(lintSpecContent("f", []byte(tt.content), builtinNames, catalog))
+		ok := len(got) == len(tt.want)
+		for n := 0; ok && n < len(got); n++ {
+			ok = strings.HasPrefix(got[n], tt.want[n])
+		}
+		if !ok {
+			t.Errorf("%s: diagnostics =\n  %s\nwant\n  %s", tt.name, strings.Join(got, "\n  "), strings.Join(tt.want, "\n  "))
+		}
+	}
+}
+
+func TestLintSpecContentWithoutCatalog(t *testing.T) {
+	content := []byte(`
+properties:
+  roleName: Typo
+  assignableScopes: [/]
+  permissions:
+    - actions: [Microsoft.Compte/disks/read]
+`)
+	if diags := lintSpecContent("f", content, nil, nil); !reflect.DeepEqual(diags, []SpecDiagnostic(nil)) {
+		t.Errorf("lintSpecContent() without a catalog = %v, want no diagnostics", diags)
+	}
+}
@@ -0,0 +1,220 @@
+package maz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/queone/utl"
+)
+
+// Specfile templating. Specfiles containing "{{" are rendered as Go text/template templates
+// before they are parsed, so the same specfiles can be deployed to several tenants. Values
+// come from, in increasing order of precedence:
+//
+//  1. The values file, z.ValuesFile or MAZ_VALUES_FILE, a YAML or JSON object
+//  2. The overlay for the current tenant in that file, under 'overlays', keyed by z.Overlay
+//     or MAZ_OVERLAY if set, else by tenant id
+//  3. MAZ_VAR_<name> environment variables, as string values named <name>
+//
+// For example, with a values file of:
+//
+//	mgRoot: 3f550b9f-8888-7777-ad61-111199992222
+//	scopes: [sub:Dev-Shared, sub:Dev-Apps]
+//	overlays:
+//	  prod:
+//	    mgRoot: 5e1a3c2d-4444-3333-bb22-999988887777
+//	    scopes: [sub:Prod-Shared, sub:Prod-Apps]
+//
+// a role definition specfile can use:
+//
+//	assignableScopes:
+//	  - /providers/Microsoft.Management/managementGroups/{{ .mgRoot }}
+//	{{- range .scopes }}
+//	  - {{ . }}
+//	{{- end }}
+//
+// Besides the standard template functions, these are available: env, default, required,
+// quote, json, split and join. Values that are not defined are empty, so optional values can
+// be given a fallback with {{ .x | default "y" }}, and mandatory ones can be enforced with
+// {{ .x | required "x is needed" }}. Printing an undefined value as is, without either of
+// those, is an error that names the value and its line.
+
+// Returns the template values for the current tenant
+func LoadSpecValues(z Bundle) (values map[string]interface{}, err error) {
+	values = map[string]interface{}{"tenantId": z.TenantId}
+	valuesFile := z.ValuesFile
+	if valuesFile == "" {
+		valuesFile = os.Getenv("MAZ_VALUES_FILE")
+	}
+	if valuesFile != "" {
+		raw, _ := utl.LoadFileJson(valuesFile)
+		if raw == nil {
+			if raw, err = utl.LoadFileYaml(valuesFile); err != nil || raw == nil {
+				return nil, fmt.Errorf("values file %s is not a valid YAML or JSON object", valuesFile)
+			}
+		}
+		fileValues, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("values file %s is not a YAML or JSON object", valuesFile)
+		}
+		overlays, _ := fileValues["overlays"].(map[string]interface{})
+		delete(fileValues, "overlays")
+		mergeValues(values, fileValues)
+
+		overlay := z.Overlay
+		if overlay == "" {
+			overlay = os.Getenv("MAZ_OVERLAY")
+		}
+		if overlay == "" {
+			overlay = z.TenantId
+		}
+		if o, ok := overlays[overlay].(map[string]interface{}); ok {
+			mergeValues(values, o)
+		} else if overlays[overlay] == nil && overlay != z.TenantId {
+			return nil, fmt.Errorf("values file %s has no overlay '%s'", valuesFile, overlay)
+		}
+	}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, "MAZ_VAR_") {
+			values[strings.TrimPrefix(k, "MAZ_VAR_")] = v
+		}
+	}
+	return values, nil
+}
+
+// Merges src into dst, recursing into objects present in both
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+var specTemplateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || utl.Str(v) == "" {
+			return def
+		}
+		return v
+	},
+	"required": func(msg string, v interface{}) (interface{}, error) {
+		if v == nil || utl.Str(v) == "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return v, nil
+	},
+	"quote": func(v interface{}) string { return yamlScalar(utl.Str(v)) },
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"split": func(sep, s string) []string { return strings.Split(s, sep) },
+	"join": func(sep string, v interface{}) string {
+		var items []string
+		switch list := v.(type) {
+		case []string:
+			items = list
+		case []interface{}:
+			for _, i := range list {
+				items = append(items, utl.Str(i))
+			}
+		default:
+			return utl.Str(v)
+		}
+		return strings.Join(items, sep)
+	},
+}
+
+// Returns true if specfile content is a template
+func isSpecTemplate(content []byte) bool {
+	return bytes.Contains(content, []byte("{{"))
+}
+
+// Name of the internal function that printed values are checked with
+const templateDefinedFunc = "mazDefined"
+
+// Returns v, or an error naming the template expression if v is undefined
+func templateDefined(expr string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, fmt.Errorf("%s is not defined; define it, or use default or required", expr)
+	}
+	return v, nil
+}
+
+// Makes every action in node that prints a bare value, such as {{ .x }} or {{ $v.x }}, check
+// that the value is defined, by piping it through templateDefinedFunc. Values that go through
+// a function first, such as default or required, are printed as that function returns them.
+func checkPrintedValues(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			checkPrintedValues(tree, child)
+		}
+	case *parse.IfNode:
+		checkPrintedValues(tree, n.List)
+		checkPrintedValues(tree, n.ElseList)
+	case *parse.RangeNode:
+		checkPrintedValues(tree, n.List)
+		checkPrintedValues(tree, n.ElseList)
+	case *parse.WithNode:
+		checkPrintedValues(tree, n.List)
+		checkPrintedValues(tree, n.ElseList)
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 || len(n.Pipe.Cmds) == 0 {
+			return // Variable assignments print nothing
+		}
+		last := n.Pipe.Cmds[len(n.Pipe.Cmds)-1]
+		if len(last.Args) != 1 {
+			return
+		}
+		switch last.Args[0].(type) {
+		case *parse.FieldNode, *parse.ChainNode, *parse.VariableNode, *parse.DotNode:
+		default:
+			return
+		}
+		expr := n.Pipe.String()
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args: []parse.Node{
+				parse.NewIdentifier(templateDefinedFunc).SetTree(tree).SetPos(n.Pos),
+				&parse.StringNode{NodeType: parse.NodeString, Pos: n.Pos, Quoted: strconv.Quote(expr), Text: expr},
+			},
+		})
+	}
+}
+
+// Renders specfile template content with given values. Printing an undefined value is an
+// error, but undefined values still reach default and required, as empty.
+func RenderSpecTemplate(name string, content []byte, values map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(specTemplateFuncs).
+		Funcs(template.FuncMap{templateDefinedFunc: templateDefined}).Option("missingkey=zero").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			checkPrintedValues(t.Tree, t.Tree.Root)
+		}
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, values); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,160 @@
+package maz
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderSpecTemplate(t *testing.T) {
+	values := map[string]interface{}{
+		"mgRoot": "mg1",
+		"empty":  "",
+		"scopes": []interface{}{"sub:Dev", "sub:Apps"},
+		"team":   map[string]interface{}{"name": "Platform", "owner": nil},
+		"count":  float64(2),
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string // Substring of the expected error, "" for no error
+	}{
+		{"value", `scope: {{ .mgRoot }}`, `scope: mg1`, ""},
+		{"nested value", `name: {{ .team.name }}`, `name: Platform`, ""},
+		{"number", `n: {{ .count }}`, `n: 2`, ""},
+		{"range", "scopes:\n{{- range .scopes }}\n  - {{ . }}\n{{- end }}", "scopes:\n  - sub:Dev\n  - sub:Apps", ""},
+		{"default for undefined", `x: {{ .missing | default "y" }}`, `x: y`, ""},
+		{"default for empty", `x: {{ .empty | default "y" }}`, `x: y`, ""},
+		{"default not needed", `x: {{ .mgRoot | default "y" }}`, `x: mg1`, ""},
+		{"default of nested undefined", `x: {{ .team.missing | default "y" }}`, `x: y`, ""},
+		{"required present", `x: {{ .mgRoot | required "mgRoot is needed" }}`, `x: mg1`, ""},
+		{"required undefined", `x: {{ .missing | required "missing is needed" }}`, "", "missing is needed"},
+		{"required empty", `x: {{ .empty | required "empty is needed" }}`, "", "empty is needed"},
+		{"undefined", "a: 1\nb: {{ .missing }}", "", ":2:"},
+		{"undefined is named", "a: 1\nb: {{ .missing }}", "", ".missing is not defined"},
+		{"nested undefined", `b: {{ .team.missing }}`, "", ".team.missing is not defined"},
+		{"null value", `b: {{ .team.owner }}`, "", ".team.owner is not defined"},
+		{"undefined in if", `{{ if .mgRoot }}b: {{ .missing }}{{ end }}`, "", ".missing is not defined"},
+		{"undefined in skipped branch", `{{ if .missing }}b: {{ .missing }}{{ else }}b: c{{ end }}`, `b: c`, ""},
+		{"undefined in with", `{{ with .team }}b: {{ .missing }}{{ end }}`, "", ".missing is not defined"},
+		{"undefined variable field", `{{ $t := .team }}b: {{ $t.missing }}`, "", "$t.missing is not defined"},
+		{"undefined in defined template", `{{ define "x" }}{{ .missing }}{{ end }}b: {{ template "x" . }}`, "",
+			".missing is not defined"},
+		{"empty is not undefined", `b: '{{ .empty }}'`, `b: ''`, ""},
+		{"literal no value", `description: <no value> {{ .mgRoot }}`, `description: <no value> mg1`, ""},
+		{"quote", `b: {{ quote "yes" }}`, `b: "yes"`, ""},
+		{"json", `b: {{ json .scopes }}`, `b: ["sub:Dev","sub:Apps"]`, ""},
+		{"join", `b: {{ join "," .scopes }}`, `b: sub:Dev,sub:Apps`, ""},
+		{"split", `{{ range split "," "a,b" }}[{{ . }}]{{ end }}`, `[a][b]`, ""},
+		{"bad syntax", `b: {{ .mgRoot `, "", "unclosed action"},
+	}
+	for _, tt := range tests {
+		got, err := RenderSpecTemplate("spec", []byte(tt.template), values)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: RenderSpecTemplate() error: %v", tt.name, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("%s: RenderSpecTemplate() = %q, want error containing %q", tt.name, got, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("%s: RenderSpecTemplate() error = %q, want error containing %q", tt.name, err, tt.wantErr)
+		case tt.wantErr == "" && string(got) != tt.want:
+			t.Errorf("%s: RenderSpecTemplate() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadSpecValues(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valuesFile := write("values.yaml", `
+mgRoot: dev-root
+team:
+  name: Platform
+  owner: dev-owner
+overlays:
+  prod:
+    mgRoot: prod-root
+    team:
+      owner: prod-owner
+  tenant-1:
+    mgRoot: tenant-root
+`)
+	jsonFile := write("values.json", `{"mgRoot": "json-root", "overlays": {"prod": {"mgRoot": "json-prod"}}}`)
+	listFile := write("list.yaml", "- a\n- b\n")
+	badFile := write("bad.yaml", "a: [b\n")
+
+	tests := []struct {
+		name    string
+		z       Bundle
+		env     map[string]string
+		want    map[string]interface{} // Values to check, nil to check none
+		wantErr string                 // Substring of the expected error, "" for no error
+	}{
+		{"no values file", Bundle{TenantId: "tenant-1"}, nil,
+			map[string]interface{}{"tenantId": "tenant-1"}, ""},
+		{"tenant overlay by default", Bundle{TenantId: "tenant-1", ValuesFile: valuesFile}, nil,
+			map[string]interface{}{"mgRoot": "tenant-root", "team": map[string]interface{}{"name": "Platform", "owner": "dev-owner"}}, ""},
+		{"no overlay for tenant", Bundle{TenantId: "tenant-2", ValuesFile: valuesFile}, nil,
+			map[string]interface{}{"mgRoot": "dev-root", "overlays": nil}, ""},
+		{"named overlay merges nested values", Bundle{TenantId: "tenant-1", ValuesFile: valuesFile, Overlay: "prod"}, nil,
+			map[string]interface{}{"mgRoot": "prod-root", "team": map[string]interface{}{"name": "Platform", "owner": "prod-owner"}}, ""},
+		{"overlay from environment", Bundle{TenantId: "tenant-1", ValuesFile: valuesFile}, map[string]string{"MAZ_OVERLAY": "prod"},
+			map[string]interface{}{"mgRoot": "prod-root"}, ""},
+		{"bundle overlay over environment", Bundle{TenantId: "tenant-1", ValuesFile: valuesFile, Overlay: "tenant-1"},
+			map[string]string{"MAZ_OVERLAY": "prod"},
+			map[string]interface{}{"mgRoot": "tenant-root"}, ""},
+		{"values file from environment", Bundle{TenantId: "tenant-2"}, map[string]string{"MAZ_VALUES_FILE": jsonFile},
+			map[string]interface{}{"mgRoot": "json-root"}, ""},
+		{"bundle values file over environment", Bundle{TenantId: "tenant-2", ValuesFile: valuesFile},
+			map[string]string{"MAZ_VALUES_FILE": jsonFile},
+			map[string]interface{}{"mgRoot": "dev-root"}, ""},
+		{"JSON values file", Bundle{TenantId: "tenant-2", ValuesFile: jsonFile, Overlay: "prod"}, nil,
+			map[string]interface{}{"mgRoot": "json-prod"}, ""},
+		{"variables override overlays", Bundle{TenantId: "tenant-1", ValuesFile: valuesFile, Overlay: "prod"},
+			map[string]string{"MAZ_VAR_mgRoot": "var-root", "MAZ_VAR_extra": "x"},
+			map[string]interface{}{"mgRoot": "var-root", "extra": "x"}, ""},
+		{"variables without values file", Bundle{TenantId: "tenant-1"}, map[string]string{"MAZ_VAR_tenantId": "other"},
+			map[string]interface{}{"tenantId": "other"}, ""},
+		{"missing overlay", Bundle{TenantId: "tenant-1", ValuesFile: valuesFile, Overlay: "staging"}, nil,
+			nil, "has no overlay 'staging'"},
+		{"missing overlay from environment", Bundle{TenantId: "tenant-1", ValuesFile: valuesFile},
+			map[string]string{"MAZ_OVERLAY": "staging"}, nil, "has no overlay 'staging'"},
+		{"not an object", Bundle{TenantId: "tenant-1", ValuesFile: listFile}, nil, nil, "is not a YAML or JSON object"},
+		{"bad file", Bundle{TenantId: "tenant-1", ValuesFile: badFile}, nil, nil, "is not a valid YAML or JSON object"},
+		{"missing file", Bundle{TenantId: "tenant-1", ValuesFile: filepath.Join(dir, "none.yaml")}, nil,
+			nil, "is not a valid YAML or JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"MAZ_VALUES_FILE", "MAZ_OVERLAY"} {
+				t.Setenv(k, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			values, err := LoadSpecValues(tt.z)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("LoadSpecValues() error: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("LoadSpecValues() = %v, want error containing %q", values, tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("LoadSpecValues() error = %q, want error containing %q", err, tt.wantErr)
+			}
+			for k, want := range tt.want {
+				if got := values[k]; !reflect.DeepEqual(got, want) {
+					t.Errorf("values[%q] = %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}